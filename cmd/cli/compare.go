@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"cursor-roomer/loadtest/compare"
+	"cursor-roomer/loadtest/store"
+)
+
+func compareCommand(args []string) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	storeDir := flags.String("store", store.DefaultDir(), "Directory runs are recorded in")
+	tolerance := flags.Float64("tolerance", compare.DefaultTolerance, "Capacity drop in percent tolerated before flagging a regression")
+	asJSON := flags.Bool("json", false, "Print the comparison as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: roomer compare [flags] <base run ID> <candidate run ID>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	runStore, err := store.New(*storeDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	base, err := runStore.Get(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("base run %s: %v", flags.Arg(0), err)
+	}
+	candidate, err := runStore.Get(flags.Arg(1))
+	if err != nil {
+		log.Fatalf("candidate run %s: %v", flags.Arg(1), err)
+	}
+
+	comparison, err := compare.Compare(base.Report, candidate.Report, *tolerance)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(comparison, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
+	fmt.Printf("Base:      %s (%s, %s)\n", base.ID, base.Client, base.Config.URL)
+	fmt.Printf("Candidate: %s (%s, %s)\n\n", candidate.ID, candidate.Client, candidate.Config.URL)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	level := "VUs"
+	if comparison.Unit == "requests/s" {
+		level = "Rate"
+	}
+	fmt.Fprintln(w, level+"\tRPS\tΔRPS\tP50\tΔP50\tP75\tΔP75\tP90\tΔP90\tP99\tΔP99\tΔErrors\t")
+	for _, d := range comparison.Iterations {
+		fmt.Fprintf(w, "%d\t%.2f\t%+.1f%%\t%.2f\t%+.1f%%\t%.2f\t%+.1f%%\t%.2f\t%+.1f%%\t%.2f\t%+.1f%%\t%+.2fpp\t\n",
+			d.Level, d.Candidate.RPS, d.RPSChange, d.Candidate.P50, d.P50Change, d.Candidate.P75, d.P75Change,
+			d.Candidate.P90, d.P90Change, d.Candidate.P99, d.P99Change, d.ErrorRateChange)
+	}
+	w.Flush()
+
+	if comparison.BaseNoCapacity {
+		fmt.Printf("\nCapacity: %d -> %d %s (baseline had no capacity)\n", comparison.BaseCapacity, comparison.CandidateCapacity, comparison.Unit)
+		fmt.Printf("Max RPS: %.2f -> %.2f\n", comparison.BaseMaxRPS, comparison.CandidateMaxRPS)
+	} else {
+		fmt.Printf("\nCapacity: %d -> %d %s (%+.1f%%)\n", comparison.BaseCapacity, comparison.CandidateCapacity, comparison.Unit, comparison.CapacityChange)
+		fmt.Printf("Max RPS: %.2f -> %.2f (%+.1f%%)\n", comparison.BaseMaxRPS, comparison.CandidateMaxRPS, comparison.MaxRPSChange)
+	}
+	if len(comparison.Iterations) > 0 {
+		fmt.Printf("Mean RPS change at matched levels: %+.1f%% (t = %.2f)\n", comparison.MeanRPSChange, comparison.TStatistic)
+	}
+	fmt.Printf("Verdict: %s\n", comparison.Verdict)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"cursor-roomer/loadtest/gate"
+	"cursor-roomer/loadtest/plan"
+	"cursor-roomer/loadtest/store"
+	"cursor-roomer/loadtest/types"
+)
+
+// findBaseline returns the recorded run a test is compared against: the run
+// with the given ID, or for "latest" the newest completed run of the same
+// client, URL and method
+func findBaseline(storeDir, id string, test plan.Test) (*store.Run, error) {
+	if storeDir == "" {
+		return nil, fmt.Errorf("comparing against a baseline needs a run store (-store)")
+	}
+	runStore, err := store.New(storeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if id != "" && id != gate.BaselineLatest {
+		run, err := runStore.Get(id)
+		if err != nil {
+			return nil, fmt.Errorf("baseline %s: %v", id, err)
+		}
+		if run.Report == nil {
+			return nil, fmt.Errorf("baseline %s has no report", id)
+		}
+		return run, nil
+	}
+
+	runs, err := runStore.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		if run.Client != test.Client || run.Config.URL != test.Config.URL || run.Config.Method != test.Config.Method {
+			continue
+		}
+		if run.Error != "" || run.Report == nil || run.Report.StopReason == types.StopCancelled {
+			continue
+		}
+		return run, nil
+	}
+	return nil, fmt.Errorf("no completed %s run of %s recorded in %s", test.Client, test.Config.URL, storeDir)
+}
+
+// printGateResults prints every check and the overall verdict
+func printGateResults(results []gate.Result) {
+	fmt.Println("Gate results:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, result := range results {
+		for _, check := range result.Checks {
+			status := "PASS"
+			switch {
+			case check.Skipped:
+				status = "SKIP"
+			case !check.Passed:
+				status = "FAIL"
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", status, result.Target, check.Name, check.Message)
+		}
+	}
+	w.Flush()
+
+	if gate.Passed(results) {
+		fmt.Println("Gate passed")
+	} else {
+		fmt.Println("Gate FAILED")
+	}
+}
+
+// writeGateFiles writes the gate results as JUnit XML and JSON to the paths
+// that are set
+func writeGateFiles(name string, results []gate.Result, junitPath, jsonPath string) error {
+	if junitPath != "" {
+		var buf bytes.Buffer
+		if err := gate.WriteJUnit(&buf, name, results); err != nil {
+			return fmt.Errorf("failed to encode JUnit results: %v", err)
+		}
+		if err := os.WriteFile(junitPath, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write JUnit results: %v", err)
+		}
+	}
+	if jsonPath != "" {
+		var buf bytes.Buffer
+		if err := gate.WriteJSON(&buf, results); err != nil {
+			return fmt.Errorf("failed to encode JSON results: %v", err)
+		}
+		if err := os.WriteFile(jsonPath, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write JSON results: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"cursor-roomer/loadtest/store"
+)
+
+const historyUsage = `Usage: roomer history [-store dir] <command>
+
+Commands:
+  list         List recorded runs, newest first
+  show <id>    Print a recorded run as JSON
+  delete <id>  Delete a recorded run`
+
+// saveRun records a finished run in the store at dir
+func saveRun(dir string, run *store.Run) error {
+	runStore, err := store.New(dir)
+	if err != nil {
+		return err
+	}
+	return runStore.Save(run)
+}
+
+func historyCommand(args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	storeDir := flags.String("store", store.DefaultDir(), "Directory runs are recorded in")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), historyUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	runStore, err := store.New(*storeDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch flags.Arg(0) {
+	case "list", "":
+		runs, err := runStore.List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTARTED\tCLIENT\tURL\tSTOP REASON\tCAPACITY")
+		for _, run := range runs {
+			stopReason, capacity := "-", "-"
+			if run.Report != nil {
+				stopReason = string(run.Report.StopReason)
+				capacity = fmt.Sprintf("%d %s @ %.2f RPS", run.Report.Capacity(), run.Report.CapacityUnit(), run.Report.MaxRPS)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", run.ID, run.StartedAt.Format("2006-01-02 15:04:05"),
+				run.Client, run.Config.URL, stopReason, capacity)
+		}
+		w.Flush()
+	case "show":
+		run, err := runStore.Get(historyRunID(flags))
+		if err != nil {
+			log.Fatal(err)
+		}
+		data, err := json.MarshalIndent(run, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(data))
+	case "delete":
+		id := historyRunID(flags)
+		if err := runStore.Delete(id); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Deleted run %s\n", id)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+}
+
+// historyRunID returns the run ID argument of a history command
+func historyRunID(flags *flag.FlagSet) string {
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	return flags.Arg(1)
+}
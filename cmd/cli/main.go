@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
+	"cursor-roomer/loadtest/feeder"
+	"cursor-roomer/loadtest/gate"
+	"cursor-roomer/loadtest/plan"
 	"cursor-roomer/loadtest/runner"
+	"cursor-roomer/loadtest/store"
 	"cursor-roomer/loadtest/types"
 )
 
@@ -18,31 +25,351 @@ func (h *StdoutHandler) WriteLine(line string) {
 }
 
 func main() {
-	url := flag.String("url", "", "URL to test")
-	initialGoroutines := flag.Int("goroutines", 10, "Initial number of goroutines")
-	duration := flag.Duration("duration", 10*time.Second, "Duration for each test cycle (e.g. 10s, 1m)")
-	latencyThreshold := flag.Float64("max-latency-increase", 15.0, "Maximum allowed latency increase in percentage (e.g. 15.0 for 15%)")
-	rpsThreshold := flag.Float64("min-rps-increase", 4.0, "Minimum required RPS increase in percentage (e.g. 4.0 for 4%)")
-	debug := flag.Bool("debug", false, "Enable debug logging to show raw k6 output")
-	clientType := flag.String("client", "k6", "Load testing client to use (k6, wrk, ghz)")
-	flag.Parse()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "run":
+			runCommand(os.Args[2:])
+			return
+		case "history":
+			historyCommand(os.Args[2:])
+			return
+		case "compare":
+			compareCommand(os.Args[2:])
+			return
+		}
+	}
+	// Without a subcommand the flags describe a run, as they always have
+	runCommand(os.Args[1:])
+}
+
+// stringList is a flag that can be given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// planFlags are the run flags that still apply when the test comes from a plan
+var planFlags = map[string]bool{"f": true, "report": true, "store": true, "junit": true, "json": true}
+
+func runCommand(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	planPath := flags.String("f", "", "Run the tests declared in this YAML or JSON plan file instead of the flags below")
+	url := flags.String("url", "", "URL to test")
+	initialGoroutines := flags.Int("goroutines", 10, "Initial number of goroutines")
+	duration := flags.Duration("duration", 10*time.Second, "Duration for each test cycle (e.g. 10s, 1m)")
+	warmup := flags.Duration("warmup", 0, "Apply load for this long at the start of every iteration, leaving it out of the results")
+	cooldown := flags.Duration("cooldown", 0, "Wait this long before every iteration and trial after the baseline, letting the system settle")
+	trials := flags.Int("trials", 1, "Run each level this many times; thresholds and SLOs then need the whole 95% confidence interval to breach")
+	latencyThreshold := flags.Float64("max-latency-increase", 15.0, "Maximum allowed latency increase in percentage (e.g. 15.0 for 15%)")
+	rpsThreshold := flags.Float64("min-rps-increase", 4.0, "Minimum required RPS increase in percentage (e.g. 4.0 for 4%)")
+	correctedLatency := flags.Bool("corrected-latency", false, "Check the P90 threshold and latency SLOs against latencies corrected for coordinated omission (native client, or k6 in the open model)")
+	errorThreshold := flags.Float64("max-error-rate", 0, "Maximum allowed error rate in percentage (e.g. 1.0 for 1%, 0 disables the check)")
+	model := flags.String("model", types.ModelClosed, "Load model: closed ramps concurrent VUs, open ramps the request arrival rate (k6 and native only)")
+	arrivalRate := flags.Int("rate", 10, "Initial arrival rate in requests/s for the open model")
+	maxVUs := flags.Int("max-vus", 0, fmt.Sprintf("Most requests in flight in the open model before arrivals are dropped (default %d)", types.DefaultMaxVUs))
+	maxDropped := flags.Float64("max-dropped", 1.0, "Maximum allowed percentage of dropped arrivals in the open model (0 disables the check)")
+	debug := flags.Bool("debug", false, "Enable debug logging to show raw k6 output")
+	clientType := flags.String("client", "k6", "Load testing client to use (k6, wrk, ghz, native)")
+	method := flags.String("method", "GET", "HTTP method")
+	body := flags.String("body", "", "Request body (JSON request message for ghz)")
+	var headerLines, cookieLines, scenarioLines stringList
+	flags.Var(&scenarioLines, "request", "Scenario request as \"weight METHOD url [body]\", url may be a path relative to -url (repeatable, replaces -method and -body)")
+	flags.Var(&headerLines, "H", "Request header as \"Name: value\" (repeatable)")
+	contentType := flags.String("content-type", "", "Content-Type of the request body (default application/json)")
+	bearerToken := flags.String("bearer", "", "Bearer token sent in the Authorization header (default $ROOMER_BEARER_TOKEN)")
+	basicAuth := flags.String("basic-auth", "", "user:password for HTTP basic auth (default $ROOMER_BASIC_AUTH)")
+	flags.Var(&cookieLines, "cookie", "Cookies as \"name=value\" or \"a=1; b=2\" (repeatable)")
+	feederPath := flags.String("feeder", "", "CSV or JSON file whose rows fill {{.field}} references in the URL, headers and body")
+	feederMode := flags.String("feeder-mode", "sequential", "How requests take feeder rows (sequential, random, unique per VU)")
+	proto := flags.String("proto", "", "Path to the .proto file for ghz (uses server reflection when empty)")
+	call := flags.String("call", "", "Fully-qualified gRPC method for ghz (e.g. package.Service/Method)")
+	tls := flags.Bool("tls", false, "Connect to the gRPC server over TLS (ghz; implied by a grpcs:// URL)")
+	skipTLSVerify := flags.Bool("skip-tls-verify", false, "Accept any gRPC server certificate, e.g. a self-signed one (ghz)")
+	scaling := flags.String("scaling", "geometric", "Scaling strategy for the capacity search, over VUs or over the arrival rate in the open model (geometric, linear, list, binary)")
+	scaleFactor := flags.Float64("scale-factor", 1.5, "Growth factor for geometric scaling")
+	scaleStep := flags.Int("scale-step", 0, "VUs added per step for linear scaling")
+	scaleLevels := flags.String("scale-levels", "", "Comma-separated VU counts for list scaling (e.g. 10,20,50)")
+	scaleLow := flags.Int("scale-low", 0, "Known-good VU count for binary search")
+	scaleHigh := flags.Int("scale-high", 0, "Known-bad VU count for binary search")
+	scaleResolution := flags.Int("scale-resolution", 1, "Binary search and refinement stop once the VU gap is no larger than this")
+	slos := flags.String("slo", "", "Comma-separated absolute SLO rules checked on every iteration (e.g. \"p99<250ms,error_rate<0.5%\")")
+	reportPath := flags.String("report", "", "Write the capacity report as JSON to this file")
+	refine := flags.Bool("refine", false, "After the first threshold breach, bisect between the last passing and first failing VU count")
+	storeDir := flags.String("store", store.DefaultDir(), "Directory to record runs in; pass -store \"\" to disable recording")
+	assertCapacity := flags.Int("assert-capacity", 0, "Fail unless at least this many VUs, or requests/s with -model open, pass every threshold and SLO")
+	assertRPS := flags.Float64("assert-rps", 0, "Fail unless the capacity found reaches at least this RPS")
+	maxRegression := flags.Float64("max-regression", 0, "Fail if capacity drops by more than this percentage vs the baseline run")
+	baseline := flags.String("baseline", gate.BaselineLatest, "Recorded run ID to check -max-regression against, or latest")
+	requireBaseline := flags.Bool("require-baseline", false, "Fail -max-regression when there is no baseline run instead of skipping it")
+	junitPath := flags.String("junit", "", "Write the gate results as JUnit XML to this file")
+	jsonPath := flags.String("json", "", "Write the gate results as JSON to this file")
+	flags.Parse(args)
+
+	suiteName := "roomer"
+	var tests []plan.Test
+	if *planPath != "" {
+		// The plan is the whole test definition, so test flags would be ignored
+		flags.Visit(func(f *flag.Flag) {
+			if !planFlags[f.Name] {
+				log.Fatalf("-%s can't be combined with -f; set it in the plan instead", f.Name)
+			}
+		})
+
+		testPlan, err := plan.Load(*planPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if tests, err = testPlan.Tests(); err != nil {
+			log.Fatal(err)
+		}
+		if testPlan.Name != "" {
+			suiteName = testPlan.Name
+		}
+	} else {
+		if *url == "" {
+			log.Fatal("Please provide a URL using -url flag, or a plan file using -f")
+		}
+
+		levels, err := runner.ParseLevels(*scaleLevels)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sloRules, err := runner.ParseSLOs(*slos)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		headers, err := runner.ParseHeaders(headerLines)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		cookies, err := runner.ParseCookies(strings.Join(cookieLines, ";"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		scenario, err := runner.ParseScenario(scenarioLines, *url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(scenario) > 0 {
+			if err := runner.ValidateScenario(scenario); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		var requestFeeder *types.Feeder
+		if *feederPath != "" {
+			if requestFeeder, err = feeder.Load(*feederPath, *feederMode); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		// Credentials can come from the environment so they stay out of
+		// shell history and process listings
+		if *bearerToken == "" && *basicAuth == "" {
+			*bearerToken = os.Getenv("ROOMER_BEARER_TOKEN")
+			*basicAuth = os.Getenv("ROOMER_BASIC_AUTH")
+		}
+		if err := runner.ValidateAuth(*bearerToken, *basicAuth); err != nil {
+			log.Fatal(err)
+		}
+
+		assertions := gate.Criteria{
+			MinCapacity:     *assertCapacity,
+			MinRPS:          *assertRPS,
+			Baseline:        *baseline,
+			RequireBaseline: *requireBaseline,
+		}
+		// -max-regression 0 asks for no capacity drop at all, so only an
+		// unset flag disables the check
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name == "max-regression" {
+				assertions.MaxRegression = maxRegression
+			}
+		})
+		if err := assertions.Validate(); err != nil {
+			log.Fatal(err)
+		}
+
+		config := types.LoadTestConfig{
+			URL:                *url,
+			Goroutines:         *initialGoroutines,
+			Duration:           *duration,
+			Warmup:             *warmup,
+			Cooldown:           *cooldown,
+			Trials:             *trials,
+			MaxLatencyIncrease: *latencyThreshold,
+			MinRpsIncrease:     *rpsThreshold,
+			MaxErrorRate:       *errorThreshold,
+			CorrectedLatency:   *correctedLatency,
+			Debug:              *debug,
+			Method:             *method,
+			Body:               *body,
+			Headers:            headers,
+			ContentType:        *contentType,
+			BearerToken:        *bearerToken,
+			BasicAuth:          *basicAuth,
+			Cookies:            cookies,
+			Proto:              *proto,
+			Call:               *call,
+			TLS:                *tls,
+			SkipTLSVerify:      *skipTLSVerify,
+			Scaling: types.ScalingConfig{
+				Strategy:   *scaling,
+				Factor:     *scaleFactor,
+				Step:       *scaleStep,
+				Levels:     levels,
+				Low:        *scaleLow,
+				High:       *scaleHigh,
+				Resolution: *scaleResolution,
+				Refine:     *refine,
+			},
+			SLOs:     sloRules,
+			Scenario: scenario,
+			Feeder:   requestFeeder,
+		}
+		if *model == types.ModelOpen {
+			config.Model = types.ModelOpen
+			config.ArrivalRate = *arrivalRate
+			config.MaxVUs = *maxVUs
+			config.MaxDropped = *maxDropped
+		} else if *model != types.ModelClosed {
+			config.Model = *model
+		}
+		if err := runner.ValidateModel(config); err != nil {
+			log.Fatal(err)
+		}
 
-	if *url == "" {
-		log.Fatal("Please provide a URL using -url flag")
+		tests = []plan.Test{{
+			Name:       *url,
+			Client:     *clientType,
+			Config:     config,
+			Assertions: assertions,
+		}}
 	}
 
-	config := types.LoadTestConfig{
-		URL:                *url,
-		Goroutines:         *initialGoroutines,
-		Duration:           *duration,
-		MaxLatencyIncrease: *latencyThreshold,
-		MinRpsIncrease:     *rpsThreshold,
-		Debug:              *debug,
-		Ctx:                context.Background(),
+	// Gating turns failed assertions into a non-zero exit code, so a pipeline
+	// can require a minimum capacity. Without it a threshold breach is just
+	// how the search ends.
+	gating := *junitPath != "" || *jsonPath != ""
+	for _, test := range tests {
+		gating = gating || test.Assertions.Enabled()
 	}
 
+	var results []targetReport
+	var gateResults []gate.Result
+	var firstErr error
+	for _, test := range tests {
+		if len(tests) > 1 {
+			fmt.Printf("=== %s ===\n", test.Name)
+		}
+
+		// The baseline is looked up first so "latest" can't be this run
+		gateResult := gate.Result{Target: test.Name}
+		var baselineReport *types.LoadTestReport
+		if test.Assertions.MaxRegression != nil {
+			if baselineRun, err := findBaseline(*storeDir, test.Assertions.Baseline, test); err != nil {
+				log.Printf("%s: %v", test.Name, err)
+			} else {
+				gateResult.BaselineID = baselineRun.ID
+				baselineReport = baselineRun.Report
+			}
+		}
+
+		run, err := runTest(test, *storeDir)
+		results = append(results, targetReport{Target: test.Name, Report: run.Report})
+		if err != nil {
+			log.Printf("%s: %v", test.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		if gating {
+			if *storeDir != "" {
+				gateResult.RunID = run.ID
+			}
+			gateResult.Report = run.Report
+			gateResult.Duration = run.FinishedAt.Sub(run.StartedAt)
+			gate.Evaluate(&gateResult, err, test.Assertions, baselineReport)
+			gateResults = append(gateResults, gateResult)
+		}
+
+		if len(tests) > 1 {
+			fmt.Println()
+		}
+	}
+
+	if *reportPath != "" {
+		// A single test writes its report as before, a plan with several
+		// targets writes one entry per target
+		var v interface{} = results
+		if len(results) == 1 {
+			v = results[0].Report
+		}
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*reportPath, data, 0644); err != nil {
+			log.Fatalf("failed to write report: %v", err)
+		}
+	}
+
+	if gating {
+		printGateResults(gateResults)
+		if err := writeGateFiles(suiteName, gateResults, *junitPath, *jsonPath); err != nil {
+			log.Fatal(err)
+		}
+		if !gate.Passed(gateResults) {
+			os.Exit(1)
+		}
+	}
+
+	if firstErr != nil {
+		os.Exit(1)
+	}
+}
+
+// targetReport is the report of one target of a plan
+type targetReport struct {
+	Target string                `json:"target"`
+	Report *types.LoadTestReport `json:"report"`
+}
+
+// runTest runs a single capacity test and records it in the store at storeDir
+func runTest(test plan.Test, storeDir string) (*store.Run, error) {
+	test.Config.Ctx = context.Background()
+
 	output := &StdoutHandler{}
-	if err := runner.RunLoadTest(config, output, *clientType); err != nil {
-		log.Fatal(err)
+	run := &store.Run{
+		ID:        store.NewID(),
+		Client:    test.Client,
+		Config:    test.Config,
+		StartedAt: time.Now(),
+	}
+	report, err := runner.RunLoadTest(test.Config, output, test.Client)
+	run.Report = report
+	run.FinishedAt = time.Now()
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	if storeDir != "" {
+		if saveErr := saveRun(storeDir, run); saveErr != nil {
+			log.Printf("failed to record run: %v", saveErr)
+		} else {
+			fmt.Printf("Run recorded as %s\n", run.ID)
+		}
 	}
+	return run, err
 }
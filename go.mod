@@ -2,8 +2,17 @@ module cursor-roomer
 
 go 1.21
 
+require github.com/HdrHistogram/hdrhistogram-go v1.1.2
+
+require (
+	google.golang.org/grpc v1.64.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
-	github.com/HdrHistogram/hdrhistogram-go v1.1.2 // indirect
 	golang.org/x/net v0.28.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
 	golang.org/x/text v0.17.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
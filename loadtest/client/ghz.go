@@ -1,7 +1,11 @@
 package client
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"os/exec"
+	"strings"
 
 	"cursor-roomer/loadtest/types"
 )
@@ -19,7 +23,126 @@ func (c *GHZClient) Name() string {
 	return "ghz"
 }
 
+func (c *GHZClient) buildArgs(config types.LoadTestConfig) ([]string, error) {
+	if len(config.Scenario) > 0 {
+		return nil, fmt.Errorf("ghz doesn't support scenarios; use k6, wrk or native")
+	}
+	if config.Feeder != nil {
+		return nil, fmt.Errorf("ghz doesn't support feeders; use k6, wrk or native")
+	}
+	if config.Open() {
+		return nil, fmt.Errorf("ghz has no arrival-rate mode; use k6 or native for the open model")
+	}
+	if config.Call == "" {
+		return nil, fmt.Errorf("ghz requires a fully-qualified gRPC method to call (e.g. package.Service/Method)")
+	}
+
+	// grpcs:// asks for TLS the way https:// does; ghz expects host:port, so
+	// drop any scheme the user may have typed
+	target := strings.TrimPrefix(config.URL, "grpc://")
+	tls := config.TLS
+	if strings.HasPrefix(target, "grpcs://") {
+		target, tls = strings.TrimPrefix(target, "grpcs://"), true
+	}
+
+	args := []string{
+		"--call", config.Call,
+		"-c", fmt.Sprintf("%d", config.Goroutines),
+		"-z", config.Duration.String(),
+		"--format", "json",
+	}
+	switch {
+	case !tls:
+		args = append(args, "--insecure")
+	case config.SkipTLSVerify:
+		args = append(args, "--skipTLS")
+	}
+
+	// Without a proto file ghz falls back to server reflection
+	if config.Proto != "" {
+		args = append(args, "--proto", config.Proto)
+	}
+
+	if config.Body != "" {
+		args = append(args, "-d", config.Body)
+	}
+
+	// Headers travel as gRPC metadata, whose keys are lowercase. gRPC sets
+	// its own content type.
+	metadata := make(map[string]string)
+	for name, value := range config.RequestHeaders() {
+		if name != "Content-Type" {
+			metadata[strings.ToLower(name)] = value
+		}
+	}
+	if len(metadata) > 0 {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode metadata: %v", err)
+		}
+		args = append(args, "--metadata", string(data))
+	}
+
+	args = append(args, target)
+
+	return args, nil
+}
+
 func (c *GHZClient) RunTest(config types.LoadTestConfig) (string, error) {
-	// TODO: Implement GHZ client
-	return "", fmt.Errorf("ghz client not implemented yet")
-} 
\ No newline at end of file
+	// Check if ghz is installed
+	if _, err := exec.LookPath("ghz"); err != nil {
+		return "", fmt.Errorf("ghz is not installed. Please install it first: %v", err)
+	}
+
+	// ghz can't leave part of a run out of its results, so the warmup is a
+	// separate run whose output is thrown away
+	if config.Warmup > 0 {
+		c.output.WriteLine(fmt.Sprintf("Warming up for %v (results are discarded)...", config.Warmup))
+		warmup := config
+		warmup.Duration, warmup.Warmup = config.Warmup, 0
+		if _, err := c.RunTest(warmup); err != nil {
+			return "", fmt.Errorf("failed to run warmup: %v", err)
+		}
+	}
+
+	c.output.WriteLine(fmt.Sprintf("Running test with %d concurrent workers for %v...",
+		config.Goroutines, config.Duration))
+
+	args, err := c.buildArgs(config)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.CommandContext(config.Ctx, "ghz", args...)
+
+	if config.Debug {
+		c.output.WriteLine("\nExecuting command:")
+		c.output.WriteLine(fmt.Sprintf("ghz %s", strings.Join(args, " ")))
+		c.output.WriteLine("---")
+	}
+
+	// The JSON report goes to stdout, keep stderr apart so it doesn't corrupt it
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		if config.Ctx.Err() != nil {
+			return "", fmt.Errorf("test cancelled")
+		}
+		// Log the command output for debugging
+		c.output.WriteLine(fmt.Sprintf("\nCommand failed with error: %v", err))
+		c.output.WriteLine("Command stderr:")
+		c.output.WriteLine(stderr.String())
+		return "", fmt.Errorf("failed to run ghz: %v\nstderr: %s", err, stderr.String())
+	}
+
+	if config.Debug && stderr.String() != "" {
+		c.output.WriteLine("\nError output:")
+		c.output.WriteLine(stderr.String())
+		c.output.WriteLine("---")
+	}
+
+	return stdout.String(), nil
+}
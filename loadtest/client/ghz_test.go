@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+
+	"cursor-roomer/loadtest/parser"
+	"cursor-roomer/loadtest/types"
+)
+
+// discardOutput drops everything a client writes
+type discardOutput struct{}
+
+func (discardOutput) WriteLine(string) {}
+
+// startGRPCServer serves the health and reflection services on a free local
+// port until the test ends, over TLS when a certificate is given
+func startGRPCServer(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	var options []grpc.ServerOption
+	if cert != nil {
+		options = append(options, grpc.Creds(credentials.NewServerTLSFromCert(cert)))
+	}
+	server := grpc.NewServer(options...)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	reflection.Register(server)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+// selfSignedCert creates a certificate for 127.0.0.1 that no client trusts
+func selfSignedCert(t *testing.T) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "roomer-test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func healthCheckConfig(url string) types.LoadTestConfig {
+	return types.LoadTestConfig{
+		URL:        url,
+		Call:       "grpc.health.v1.Health/Check",
+		Goroutines: 2,
+		Duration:   time.Second,
+		Ctx:        context.Background(),
+	}
+}
+
+func TestGHZBuildArgsTransport(t *testing.T) {
+	tests := []struct {
+		name          string
+		url           string
+		tls           bool
+		skipTLSVerify bool
+		wantFlags     []string
+		wantTarget    string
+	}{
+		{name: "plaintext by default", url: "localhost:50051", wantFlags: []string{"--insecure"}, wantTarget: "localhost:50051"},
+		{name: "grpc scheme stays plaintext", url: "grpc://localhost:50051", wantFlags: []string{"--insecure"}, wantTarget: "localhost:50051"},
+		{name: "tls option", url: "localhost:50051", tls: true, wantTarget: "localhost:50051"},
+		{name: "grpcs scheme implies tls", url: "grpcs://localhost:50051", wantTarget: "localhost:50051"},
+		{name: "skip verification", url: "localhost:50051", tls: true, skipTLSVerify: true, wantFlags: []string{"--skipTLS"}, wantTarget: "localhost:50051"},
+		{name: "skip verification needs tls", url: "localhost:50051", skipTLSVerify: true, wantFlags: []string{"--insecure"}, wantTarget: "localhost:50051"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := healthCheckConfig(tt.url)
+			config.TLS, config.SkipTLSVerify = tt.tls, tt.skipTLSVerify
+			args, err := (&GHZClient{output: discardOutput{}}).buildArgs(config)
+			if err != nil {
+				t.Fatalf("buildArgs: %v", err)
+			}
+
+			var flags []string
+			for _, arg := range args {
+				if arg == "--insecure" || arg == "--skipTLS" {
+					flags = append(flags, arg)
+				}
+			}
+			if !reflect.DeepEqual(flags, tt.wantFlags) {
+				t.Errorf("transport flags = %v, want %v", flags, tt.wantFlags)
+			}
+			if target := args[len(args)-1]; target != tt.wantTarget {
+				t.Errorf("target = %q, want %q", target, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestGHZAgainstLocalServer(t *testing.T) {
+	if _, err := exec.LookPath("ghz"); err != nil {
+		t.Skip("ghz is not installed")
+	}
+	plaintext := startGRPCServer(t, nil)
+	secure := startGRPCServer(t, selfSignedCert(t))
+
+	tests := []struct {
+		name    string
+		config  types.LoadTestConfig
+		wantErr bool
+	}{
+		{name: "plaintext with reflection", config: healthCheckConfig(plaintext)},
+		{name: "tls with a self-signed certificate", config: func() types.LoadTestConfig {
+			config := healthCheckConfig("grpcs://" + secure)
+			config.SkipTLSVerify = true
+			return config
+		}()},
+		{name: "tls verifies the certificate", config: func() types.LoadTestConfig {
+			config := healthCheckConfig(secure)
+			config.TLS = true
+			return config
+		}(), wantErr: true},
+		{name: "plaintext to a tls server", config: healthCheckConfig(secure), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := NewGHZClient(discardOutput{}).RunTest(tt.config)
+			if tt.wantErr {
+				// ghz gives up when it can't connect at all
+				if err == nil {
+					t.Fatalf("expected the connection to fail, got %s", output)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RunTest: %v", err)
+			}
+			result, err := parser.ParseGHZOutput(output)
+			if err != nil {
+				t.Fatalf("ParseGHZOutput: %v", err)
+			}
+			if result.TotalRequests == 0 || result.RPS <= 0 {
+				t.Fatalf("expected requests to be made, got %d at %.2f RPS", result.TotalRequests, result.RPS)
+			}
+			// Calls still in flight when the duration is up count as errors
+			if result.StatusCodes["OK"] < result.TotalRequests-int64(tt.config.Goroutines) {
+				t.Errorf("expected the calls to succeed, got status codes %v", result.StatusCodes)
+			}
+		})
+	}
+}
@@ -2,11 +2,16 @@ package client
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"text/template"
+	"time"
 
+	"cursor-roomer/loadtest/feeder"
 	"cursor-roomer/loadtest/types"
 )
 
@@ -23,58 +28,357 @@ func (c *K6Client) Name() string {
 	return "k6"
 }
 
-func (c *K6Client) createScript(config types.LoadTestConfig) (string, error) {
-	// Create a temporary file for the k6 script
-	tmpFile, err := os.CreateTemp("", "k6-script-*.js")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temporary file: %v", err)
-	}
-	// Don't defer the removal here, we'll do it after k6 has finished running
-
-	// Write the k6 script to the temporary file
-	script := fmt.Sprintf(`
+// k6ScriptTemplate is the script k6 runs. Every value spliced into it is
+// JSON-encoded first, which makes it a valid JavaScript literal; the requests
+// themselves are read from data files so URLs and bodies never become code.
+// Bodies without feeder references are read as binary and sent byte for byte.
+var k6ScriptTemplate = template.Must(template.New("k6").Parse(`
 import http from 'k6/http';
-import { sleep } from 'k6';
-
-export const options = {
-  vus: %d,
-  duration: '%s',
-  thresholds: {
-    http_req_duration: ['p(90)<1000'],
-  },
-  summaryTrendStats: ['avg', 'min', 'med', 'max', 'p(50)', 'p(75)', 'p(90)', 'p(99)'],
+import exec from 'k6/execution';
+import { check, group } from 'k6';
+import { SharedArray } from 'k6/data';
+import { Counter } from 'k6/metrics';
+
+const data = JSON.parse(open({{.DataPath}}));
+const requests = data.requests;
+const bodies = requests.map((request) => request.bodyPath ? open(request.bodyPath, 'b') : null);
+const totalWeight = requests.reduce((sum, request) => sum + request.weight, 0);
+
+// The feeder rows are loaded once and shared by every VU
+const rows = data.feeder ? new SharedArray('feeder', () => JSON.parse(open(data.feeder.rowsPath))) : [];
+
+// nextRow returns the feeder row the next request is filled from
+function nextRow() {
+  switch (data.feeder.mode) {
+    case 'random':
+      return rows[Math.floor(Math.random() * rows.length)];
+    case 'unique':
+      return rows[exec.vu.idInTest - 1];
+    default:
+      return rows[exec.scenario.iterationInTest % rows.length];
+  }
+}
+
+// escapeURL percent-encodes everything but unreserved characters
+function escapeURL(s) {
+  return encodeURIComponent(s).replace(/[!'()*]/g, (c) => '%' + c.charCodeAt(0).toString(16).toUpperCase());
+}
+
+// render fills a template, given as literals alternating with field indexes
+function render(template, row, escape) {
+  let s = template[0];
+  for (let i = 1; i < template.length; i += 2) {
+    const value = row[template[i]];
+    s += (escape ? escapeURL(value) : value) + template[i + 1];
+  }
+  return s;
+}
+
+// selector returns the submetric selector for the given tags, limited to the
+// measured part of the run when it starts with a warmup
+function selector(tags) {
+  const all = data.warmupMs ? tags.concat(['phase:measure']) : tags;
+  return all.length ? '{' + all.join(',') + '}' : '';
+}
+
+// Thresholds without conditions make k6 export the submetrics of each
+// request's tag, which break the results down per endpoint
+const thresholds = {};
+requests.forEach((request, i) => {
+  thresholds['http_reqs' + selector(['endpoint:e' + i])] = [];
+  thresholds['http_req_duration' + selector(['endpoint:e' + i])] = [];
+  thresholds['http_req_failed' + selector(['endpoint:e' + i])] = [];
+});
+if (data.warmupMs) {
+  ['http_reqs', 'http_req_duration', 'http_req_failed', 'status_1xx', 'status_2xx', 'status_3xx', 'status_4xx', 'status_5xx',
+    'request_timeouts', 'socket_errors', 'checks'].forEach((metric) => {
+    thresholds[metric + selector([])] = [];
+  });
+  // Dropped iterations run no code to tag, so the open model measures in a
+  // scenario of its own to tell them apart
+  thresholds['dropped_iterations{scenario:measure}'] = [];
+}
+
+// pick returns the index of a request chosen in proportion to the weights
+function pick() {
+  let x = Math.random() * totalWeight;
+  for (let i = 0; i < requests.length - 1; i++) {
+    x -= requests[i].weight;
+    if (x < 0) {
+      return i;
+    }
+  }
+  return requests.length - 1;
+}
+
+// Status classes, timeouts and socket errors end up in the summary export
+const statusCounters = {
+  1: new Counter('status_1xx'),
+  2: new Counter('status_2xx'),
+  3: new Counter('status_3xx'),
+  4: new Counter('status_4xx'),
+  5: new Counter('status_5xx'),
 };
+const timeouts = new Counter('request_timeouts');
+const socketErrors = new Counter('socket_errors');
+
+export const options = Object.assign({{.Load}}, {
+  summaryTrendStats: ['avg', 'min', 'med', 'max', 'p(50)', 'p(75)', 'p(90)', 'p(99)'],
+  thresholds: thresholds,
+});
+
+function send(i) {
+  const request = requests[i];
+  const row = request.templated ? nextRow() : null;
+  const headers = {};
+  for (const name in request.headers) {
+    headers[name] = render(request.headers[name], row, false);
+  }
+  const tags = { endpoint: 'e' + i };
+  if (request.urlName) {
+    tags.name = request.urlName;
+  }
+  const body = request.body ? render(request.body, row, false) : bodies[i];
+  return http.request(request.method, render(request.url, row, true), body, { headers: headers, tags: tags });
+}
 
 export default function() {
-  const params = {
-    headers: {
-      'Content-Type': 'application/json',
-    },
-  };
-  %s
-}
-`, config.Goroutines, config.Duration, func() string {
-		if config.Method == "" || config.Method == "GET" {
-			return fmt.Sprintf("const res = http.get('%s', params);", config.URL)
+  // Everything a VU measures during the warmup is tagged apart, so the
+  // results leave it out
+  if (data.warmupMs) {
+    const warmup = exec.scenario.name === 'measure' ? false : Date.now() - exec.scenario.startTime < data.warmupMs;
+    exec.vu.metrics.tags.phase = warmup ? 'warmup' : 'measure';
+  }
+  const i = pick();
+  let res;
+  if (data.scenario) {
+    group(requests[i].name, () => {
+      res = send(i);
+    });
+  } else {
+    res = send(i);
+  }
+  check(res, {
+    'status is 2xx/3xx': (r) => r.status >= 200 && r.status < 400,
+  });
+  if (res.status === 0) {
+    // 1050 is k6's request timeout error code
+    if (res.error_code === 1050) {
+      timeouts.add(1);
+    } else {
+      socketErrors.add(1);
+    }
+  } else if (statusCounters[Math.floor(res.status / 100)]) {
+    statusCounters[Math.floor(res.status / 100)].add(1);
+  }
+}
+`))
+
+// k6Data is the data file the k6 script reads its requests from
+type k6Data struct {
+	Scenario bool        `json:"scenario"` // Groups each request under its name
+	Requests []k6Request `json:"requests"`
+	Feeder   *k6Feeder   `json:"feeder,omitempty"`
+	WarmupMs int64       `json:"warmupMs,omitempty"` // Length of the warmup at the start of the run
+}
+
+// k6Request is a request of the data file. Its URL, header values and body
+// are templates, written as literals alternating with feeder field indexes.
+type k6Request struct {
+	Name      string                   `json:"name"`
+	Method    string                   `json:"method"`
+	URL       []interface{}            `json:"url"`
+	URLName   string                   `json:"urlName,omitempty"`  // Tags requests to a templated URL with one name
+	Body      []interface{}            `json:"body,omitempty"`     // A body that refers to feeder fields
+	BodyPath  string                   `json:"bodyPath,omitempty"` // File holding any other body; no body when both are empty
+	Headers   map[string][]interface{} `json:"headers"`
+	Weight    float64                  `json:"weight"`
+	Templated bool                     `json:"templated"` // Takes a feeder row
+}
+
+type k6Feeder struct {
+	Mode     string `json:"mode"`
+	RowsPath string `json:"rowsPath"`
+}
+
+// k6Template writes a template the way the k6 script renders it
+func k6Template(t feeder.Template) []interface{} {
+	parts := []interface{}{t.Literals[0]}
+	for i, field := range t.Fields {
+		parts = append(parts, field, t.Literals[i+1])
+	}
+	return parts
+}
+
+// jsLiteral encodes v as JSON, which is also a JavaScript literal. Go escapes
+// U+2028 and U+2029, the only characters where the two differ.
+func jsLiteral(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// k6Load returns the options that set how k6 generates load: a fixed number
+// of VUs in the closed model, a constant-arrival-rate scenario in the open
+// one. An open-model warmup runs as a scenario of its own before the measure
+// scenario, so the iterations each one drops are counted apart.
+func k6Load(config types.LoadTestConfig) map[string]interface{} {
+	if !config.Open() {
+		return map[string]interface{}{
+			"vus":      config.Goroutines,
+			"duration": (config.Warmup + config.Duration).String(),
 		}
-		if config.Body != "" {
-			return fmt.Sprintf("const res = http.%s('%s', %s, params);", strings.ToLower(config.Method), config.URL, config.Body)
+	}
+	// Start with a VU for every 10 requests/s and let k6 add more, up to
+	// the cap, when responses slow down
+	preAllocated := config.ArrivalRate / 10
+	if preAllocated < 10 {
+		preAllocated = 10
+	}
+	if preAllocated > config.VUs() {
+		preAllocated = config.VUs()
+	}
+	scenario := func(duration time.Duration) map[string]interface{} {
+		return map[string]interface{}{
+			"executor":        "constant-arrival-rate",
+			"rate":            config.ArrivalRate,
+			"timeUnit":        "1s",
+			"duration":        duration.String(),
+			"preAllocatedVUs": preAllocated,
+			"maxVUs":          config.VUs(),
 		}
-		return fmt.Sprintf("const res = http.%s('%s', null, params);", strings.ToLower(config.Method), config.URL)
-	}())
+	}
+	if config.Warmup <= 0 {
+		return map[string]interface{}{"scenarios": map[string]interface{}{"load": scenario(config.Duration)}}
+	}
+	// The scenarios don't overlap, so k6 hands the warmup's VUs and their
+	// connections on to the measure scenario
+	warmup, measure := scenario(config.Warmup), scenario(config.Duration)
+	warmup["gracefulStop"] = "0s"
+	measure["startTime"] = config.Warmup.String()
+	return map[string]interface{}{"scenarios": map[string]interface{}{"warmup": warmup, "measure": measure}}
+}
 
-	fmt.Printf("Generated k6 script:\n%s\n", script)
-	fmt.Printf("Config: %+v\n", config)
+// afterWarmup describes the warmup that precedes the measured part of a run,
+// for the line announcing the run
+func afterWarmup(config types.LoadTestConfig) string {
+	if config.Warmup <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" after a %v warmup", config.Warmup)
+}
 
-	if _, err := tmpFile.WriteString(script); err != nil {
+// createScript writes the k6 script and its request data file to a new
+// temporary directory and returns the directory and the script path. The
+// caller removes the directory when k6 has finished.
+func (c *K6Client) createScript(config types.LoadTestConfig) (string, string, error) {
+	dir, err := os.MkdirTemp("", "k6-script-*")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	scriptPath, err := c.writeScript(dir, config)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	return dir, scriptPath, nil
+}
+
+func (c *K6Client) writeScript(dir string, config types.LoadTestConfig) (string, error) {
+	requests, err := feeder.CompileRequests(config)
+	if err != nil {
+		return "", err
+	}
+	urls := make([]string, len(requests))
+	for i, request := range config.Requests() {
+		urls[i] = request.URL
+	}
+	data := k6Data{Scenario: len(config.Scenario) > 0, WarmupMs: config.Warmup.Milliseconds()}
+	for i, request := range requests {
+		k6Req := k6Request{
+			Name:      request.Name,
+			Method:    request.Method,
+			URL:       k6Template(request.URL),
+			Headers:   make(map[string][]interface{}, len(request.Headers)),
+			Weight:    request.Weight,
+			Templated: !request.Static(),
+		}
+		if !request.URL.Static() {
+			k6Req.URLName = urls[i]
+		}
+		for name, header := range request.Headers {
+			k6Req.Headers[name] = k6Template(header)
+		}
+		if !request.Body.Static() {
+			k6Req.Body = k6Template(request.Body)
+		} else if body := request.Body.Literals[0]; body != "" {
+			k6Req.BodyPath = filepath.Join(dir, fmt.Sprintf("body-%d", i))
+			if err := os.WriteFile(k6Req.BodyPath, []byte(body), 0600); err != nil {
+				return "", fmt.Errorf("failed to write request body: %v", err)
+			}
+		}
+		data.Requests = append(data.Requests, k6Req)
+	}
+
+	if config.Feeder != nil {
+		rows, err := json.Marshal(config.Feeder.Rows)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode feeder rows: %v", err)
+		}
+		data.Feeder = &k6Feeder{Mode: config.Feeder.Mode, RowsPath: filepath.Join(dir, "rows.json")}
+		if err := os.WriteFile(data.Feeder.RowsPath, rows, 0600); err != nil {
+			return "", fmt.Errorf("failed to write feeder rows: %v", err)
+		}
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode k6 requests: %v", err)
+	}
+	dataPath := filepath.Join(dir, "requests.json")
+	if err := os.WriteFile(dataPath, encoded, 0600); err != nil {
+		return "", fmt.Errorf("failed to write k6 requests: %v", err)
+	}
+
+	values := struct {
+		DataPath string
+		Load     string
+	}{}
+	if values.DataPath, err = jsLiteral(dataPath); err != nil {
+		return "", err
+	}
+	if values.Load, err = jsLiteral(k6Load(config)); err != nil {
+		return "", err
+	}
+
+	var script bytes.Buffer
+	err = k6ScriptTemplate.Execute(&script, values)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate k6 script: %v", err)
+	}
+
+	if config.Debug {
+		c.output.WriteLine("Generated k6 script:")
+		c.output.WriteLine(script.String())
+	}
+
+	scriptPath := filepath.Join(dir, "script.js")
+	if err := os.WriteFile(scriptPath, script.Bytes(), 0600); err != nil {
 		return "", fmt.Errorf("failed to write k6 script: %v", err)
 	}
+	return scriptPath, nil
+}
 
-	// Close the file so k6 can read it
+// createSummaryFile reserves a temporary path for k6 to export its JSON summary to
+func (c *K6Client) createSummaryFile() (string, error) {
+	tmpFile, err := os.CreateTemp("", "k6-summary-*.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
 	if err := tmpFile.Close(); err != nil {
-		return "", fmt.Errorf("failed to close k6 script: %v", err)
+		return "", fmt.Errorf("failed to close k6 summary file: %v", err)
 	}
-
 	return tmpFile.Name(), nil
 }
 
@@ -84,21 +388,32 @@ func (c *K6Client) RunTest(config types.LoadTestConfig) (string, error) {
 		return "", fmt.Errorf("k6 is not installed. Please install it first: %v", err)
 	}
 
-	c.output.WriteLine(fmt.Sprintf("Running test with %d virtual users for %v...",
-		config.Goroutines, config.Duration))
+	if config.Open() {
+		c.output.WriteLine(fmt.Sprintf("Running test at %d requests/s (up to %d virtual users) for %v%s...",
+			config.ArrivalRate, config.VUs(), config.Duration, afterWarmup(config)))
+	} else {
+		c.output.WriteLine(fmt.Sprintf("Running test with %d virtual users for %v%s...",
+			config.Goroutines, config.Duration, afterWarmup(config)))
+	}
 
-	scriptPath, err := c.createScript(config)
+	scriptDir, scriptPath, err := c.createScript(config)
 	if err != nil {
 		return "", err
 	}
-	// Clean up the temporary script after k6 has finished running
-	// defer os.Remove(scriptPath)
+	defer os.RemoveAll(scriptDir)
 
-	cmd := exec.CommandContext(config.Ctx, "k6", "run", scriptPath)
+	summaryPath, err := c.createSummaryFile()
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(summaryPath)
+
+	args := []string{"run", "--summary-export", summaryPath, scriptPath}
+	cmd := exec.CommandContext(config.Ctx, "k6", args...)
 
 	if config.Debug {
 		c.output.WriteLine("\nExecuting command:")
-		c.output.WriteLine(fmt.Sprintf("k6 run %s", scriptPath))
+		c.output.WriteLine(fmt.Sprintf("k6 %s", strings.Join(args, " ")))
 		c.output.WriteLine("---")
 	}
 
@@ -132,5 +447,11 @@ func (c *K6Client) RunTest(config types.LoadTestConfig) (string, error) {
 		c.output.WriteLine("---")
 	}
 
-	return stdout.String(), nil
+	// The results come from the JSON summary rather than the console output
+	summary, err := os.ReadFile(summaryPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read k6 summary: %v", err)
+	}
+
+	return string(summary), nil
 }
@@ -0,0 +1,248 @@
+package client
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"cursor-roomer/loadtest/feeder"
+	"cursor-roomer/loadtest/types"
+)
+
+// hostileStrings are values that would break out of, or change the meaning
+// of, a generated script if they were spliced into it without quoting
+var hostileStrings = []struct {
+	name  string
+	value string
+}{
+	{name: "quotes", value: `"double" 'single' \"escaped\"`},
+	{name: "backslashes", value: `back\slash \\ A \x41 \065`},
+	{name: "template literal", value: "`backtick` ${globalThis.pwned = 1}"},
+	{name: "long brackets", value: "]] ]=] --[[ comment ]] [==[ ]==]"},
+	{name: "newlines", value: "line\nbreak\r\nend"},
+	{name: "control bytes", value: "nul\x0042 bell\x07 del\x7f tab\t"},
+	{name: "script tag", value: "</script><script>alert(1)</script><!--"},
+	{name: "line separators", value: "\u2028\u2029 é 漢"},
+	{name: "invalid utf-8", value: "\xff\xfe\x80 \xc3"},
+}
+
+// k6Values returns the hostile strings a k6 script can carry: JavaScript
+// strings hold text, so only a request body can be raw bytes
+func k6Values() []struct{ name, value string } {
+	var values []struct{ name, value string }
+	for _, hostile := range hostileStrings {
+		if utf8.ValidString(hostile.value) {
+			values = append(values, struct{ name, value string }{hostile.name, hostile.value})
+		}
+	}
+	return values
+}
+
+func TestJSLiteral(t *testing.T) {
+	values := k6Values()
+	literals := make([]string, len(values))
+	for i, hostile := range values {
+		literal, err := jsLiteral(hostile.value)
+		if err != nil {
+			t.Fatalf("%s: jsLiteral: %v", hostile.name, err)
+		}
+		for _, raw := range []string{"\n", "\r", "\x00", "\u2028", "\u2029", "</script", "<!--"} {
+			if strings.Contains(literal, raw) {
+				t.Errorf("%s: literal %s contains %q unescaped", hostile.name, literal, raw)
+			}
+		}
+		literals[i] = literal
+	}
+
+	// Evaluate the literals as JavaScript and check they read back as the
+	// values they were made from
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+	program := "process.stdout.write(JSON.stringify([" + strings.Join(literals, ", ") + "]))"
+	output, err := exec.Command("node", "-e", program).Output()
+	if err != nil {
+		t.Fatalf("node failed to evaluate the literals: %v", err)
+	}
+	var got []string
+	if err := json.Unmarshal(output, &got); err != nil {
+		t.Fatalf("failed to decode %s: %v", output, err)
+	}
+	for i, hostile := range values {
+		if got[i] != hostile.value {
+			t.Errorf("%s: evaluated to %q, want %q", hostile.name, got[i], hostile.value)
+		}
+	}
+}
+
+// readK6Data decodes the data file a generated script reads its requests from
+func readK6Data(t *testing.T, dir string) k6Data {
+	t.Helper()
+	encoded, err := os.ReadFile(filepath.Join(dir, "requests.json"))
+	if err != nil {
+		t.Fatalf("failed to read the k6 requests: %v", err)
+	}
+	var data k6Data
+	if err := json.Unmarshal(encoded, &data); err != nil {
+		t.Fatalf("failed to decode the k6 requests: %v", err)
+	}
+	return data
+}
+
+func TestK6WriteScriptHostileInput(t *testing.T) {
+	client := &K6Client{output: discardOutput{}}
+	plain := types.LoadTestConfig{URL: "http://example.test/", Method: "POST", Body: "{}"}
+
+	for _, hostile := range k6Values() {
+		t.Run(hostile.name, func(t *testing.T) {
+			// The script is generated into a directory whose name is hostile
+			// too, as its path is the one value written into the script
+			dir := filepath.Join(t.TempDir(), strings.NewReplacer("/", "", "\x00", "").Replace(hostile.value))
+			if err := os.Mkdir(dir, 0700); err != nil {
+				t.Fatalf("failed to create %q: %v", dir, err)
+			}
+			url := "http://example.test/search?q=" + hostile.value
+			config := types.LoadTestConfig{
+				URL:     url,
+				Method:  "POST",
+				Body:    hostile.value,
+				Headers: map[string]string{"X-Hostile": hostile.value},
+			}
+			scriptPath, err := client.writeScript(dir, config)
+			if err != nil {
+				t.Fatalf("writeScript: %v", err)
+			}
+
+			request := readK6Data(t, dir).Requests[0]
+			if !reflect.DeepEqual(request.URL, []interface{}{url}) {
+				t.Errorf("url = %q, want %q", request.URL, url)
+			}
+			if !reflect.DeepEqual(request.Headers["X-Hostile"], []interface{}{hostile.value}) {
+				t.Errorf("header = %q, want %q", request.Headers["X-Hostile"], hostile.value)
+			}
+			if body, _ := os.ReadFile(request.BodyPath); string(body) != hostile.value {
+				t.Errorf("body = %q, want %q", body, hostile.value)
+			}
+
+			// The requests never reach the script, so it is the same as one
+			// generated for a harmless request
+			script, err := os.ReadFile(scriptPath)
+			if err != nil {
+				t.Fatalf("failed to read the script: %v", err)
+			}
+			plainPath, err := client.writeScript(dir, plain)
+			if err != nil {
+				t.Fatalf("writeScript: %v", err)
+			}
+			if plainScript, _ := os.ReadFile(plainPath); string(plainScript) != string(script) {
+				t.Errorf("the script depends on the requests:\n%s", script)
+			}
+			dataPath, _ := jsLiteral(filepath.Join(dir, "requests.json"))
+			if !strings.Contains(string(script), "JSON.parse(open("+dataPath+"))") {
+				t.Errorf("the script doesn't open %s", dataPath)
+			}
+			if _, err := exec.LookPath("node"); err == nil {
+				// node parses the script as a module without running it
+				modulePath := filepath.Join(t.TempDir(), "script.mjs")
+				if err := os.WriteFile(modulePath, script, 0600); err != nil {
+					t.Fatalf("failed to copy the script: %v", err)
+				}
+				if output, err := exec.Command("node", "--check", modulePath).CombinedOutput(); err != nil {
+					t.Errorf("the script doesn't parse: %v\n%s", err, output)
+				}
+			}
+		})
+	}
+}
+
+func TestK6WriteScriptHostileBody(t *testing.T) {
+	// Bodies are read from a file as binary, so even invalid UTF-8 is sent
+	// byte for byte
+	for _, hostile := range hostileStrings {
+		t.Run(hostile.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := types.LoadTestConfig{URL: "http://example.test/", Method: "PUT", Body: hostile.value}
+			if _, err := (&K6Client{output: discardOutput{}}).writeScript(dir, config); err != nil {
+				t.Fatalf("writeScript: %v", err)
+			}
+			body, err := os.ReadFile(readK6Data(t, dir).Requests[0].BodyPath)
+			if err != nil {
+				t.Fatalf("failed to read the body: %v", err)
+			}
+			if string(body) != hostile.value {
+				t.Errorf("body = %q, want %q", body, hostile.value)
+			}
+		})
+	}
+}
+
+func TestK6WriteScriptHostileTemplate(t *testing.T) {
+	for _, hostile := range k6Values() {
+		t.Run(hostile.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := types.LoadTestConfig{
+				URL:     "http://example.test/items/{{.id}}?q=" + hostile.value,
+				Method:  "POST",
+				Body:    hostile.value + "{{.id}}" + hostile.value,
+				Headers: map[string]string{"X-Hostile": "{{.id}}" + hostile.value},
+				Feeder:  &types.Feeder{Mode: feeder.ModeSequential, Fields: []string{"id"}, Rows: [][]string{{hostile.value}}},
+			}
+			if _, err := (&K6Client{output: discardOutput{}}).writeScript(dir, config); err != nil {
+				t.Fatalf("writeScript: %v", err)
+			}
+
+			// Field indexes decode as float64, like every JSON number
+			data := readK6Data(t, dir)
+			request := data.Requests[0]
+			want := map[string][]interface{}{
+				"url":    {"http://example.test/items/", 0.0, "?q=" + hostile.value},
+				"body":   {hostile.value, 0.0, hostile.value},
+				"header": {"", 0.0, hostile.value},
+			}
+			got := map[string][]interface{}{"url": request.URL, "body": request.Body, "header": request.Headers["X-Hostile"]}
+			for name := range want {
+				if !reflect.DeepEqual(got[name], want[name]) {
+					t.Errorf("%s = %q, want %q", name, got[name], want[name])
+				}
+			}
+
+			encoded, err := os.ReadFile(data.Feeder.RowsPath)
+			if err != nil {
+				t.Fatalf("failed to read the feeder rows: %v", err)
+			}
+			var rows [][]string
+			if err := json.Unmarshal(encoded, &rows); err != nil {
+				t.Fatalf("failed to decode the feeder rows: %v", err)
+			}
+			if !reflect.DeepEqual(rows, config.Feeder.Rows) {
+				t.Errorf("rows = %q, want %q", rows, config.Feeder.Rows)
+			}
+		})
+	}
+}
+
+func TestK6LoadOpenModelWarmup(t *testing.T) {
+	config := types.LoadTestConfig{Model: types.ModelOpen, ArrivalRate: 200, MaxVUs: 50, Warmup: 5 * time.Second, Duration: 10 * time.Second}
+	scenarios := k6Load(config)["scenarios"].(map[string]interface{})
+	warmup, _ := scenarios["warmup"].(map[string]interface{})
+	measure, _ := scenarios["measure"].(map[string]interface{})
+	if warmup == nil || measure == nil || len(scenarios) != 2 {
+		t.Fatalf("scenarios = %v, want a warmup and a measure scenario", scenarios)
+	}
+	if warmup["duration"] != "5s" || measure["duration"] != "10s" || measure["startTime"] != "5s" {
+		t.Errorf("warmup runs %v, measure runs %v from %v; want 5s, then 10s from 5s",
+			warmup["duration"], measure["duration"], measure["startTime"])
+	}
+
+	config.Warmup = 0
+	scenarios = k6Load(config)["scenarios"].(map[string]interface{})
+	if load, _ := scenarios["load"].(map[string]interface{}); load == nil || load["duration"] != "10s" || len(scenarios) != 1 {
+		t.Errorf("scenarios = %v, want a single 10s scenario without a warmup", scenarios)
+	}
+}
@@ -0,0 +1,501 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+
+	"cursor-roomer/loadtest/feeder"
+	"cursor-roomer/loadtest/types"
+)
+
+const (
+	// Latencies are recorded in microseconds, up to one hour per request.
+	// Longer ones are clamped to the maximum and counted.
+	histogramMinValue    = 1
+	histogramMaxValue    = int64(time.Hour / time.Microsecond)
+	histogramSignificant = 3
+
+	// lateThreshold is how long after its due time an open-model arrival can
+	// be handed to a worker before it counts as late
+	lateThreshold = 10 * time.Millisecond
+)
+
+// NativeClient implements LoadTestClient with an in-process net/http load generator
+type NativeClient struct {
+	output types.OutputHandler
+}
+
+func NewNativeClient(output types.OutputHandler) types.LoadTestClient {
+	return &NativeClient{output: output}
+}
+
+func (c *NativeClient) Name() string {
+	return "native"
+}
+
+// nativeWorker holds the measurements of a single worker goroutine so that
+// workers never contend on a shared histogram while the test is running
+type nativeWorker struct {
+	id           int
+	endpoints    []nativeEndpoint // One per request of the scenario
+	timeouts     int64
+	socketErrors int64
+	statusCodes  map[int]int64
+	rand         *rand.Rand
+	arrivals     chan time.Time // Open model: due times of the requests to send
+	latencySum   int64          // Microseconds, to estimate how often the worker sends
+	responses    int64
+	clamped      int64     // Responses with a latency past histogramMaxValue
+	measureFrom  time.Time // Requests sent before this belong to the warmup and aren't recorded
+}
+
+// nativeEndpoint holds a worker's measurements of one request
+type nativeEndpoint struct {
+	histogram *hdrhistogram.Histogram
+	corrected *hdrhistogram.Histogram // Latencies corrected for coordinated omission
+	requests  int64
+	failed    int64
+}
+
+func newHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(histogramMinValue, histogramMaxValue, histogramSignificant)
+}
+
+// record records the latency of a response. due is when the request was
+// meant to be sent: the arrival time in the open model, zero in the closed
+// one. A closed-model worker only sends once its last response is in, so a
+// stall also hides the requests it would have sent meanwhile; those are
+// filled in at the worker's mean interval between requests, like wrk2 does.
+// Latencies past the histograms' range are recorded at the maximum rather
+// than dropped, so a stall still shows in the tail, and are counted.
+func (w *nativeWorker) record(endpoint int, latency time.Duration, due time.Time) {
+	stats := &w.endpoints[endpoint]
+	value := int64(latency / time.Microsecond)
+	correctedValue := value
+	if !due.IsZero() {
+		correctedValue = int64(time.Since(due) / time.Microsecond)
+	}
+	// The corrected latency is never the shorter one, so it tells whether
+	// either was clamped
+	if correctedValue > histogramMaxValue {
+		w.clamped++
+	}
+	value, correctedValue = clampLatency(value), clampLatency(correctedValue)
+
+	// Clamped values are always in range, so recording can't fail
+	stats.histogram.RecordValue(value)
+	if due.IsZero() {
+		var interval int64
+		if w.responses > 0 {
+			interval = w.latencySum / w.responses
+		}
+		stats.corrected.RecordCorrectedValue(value, interval)
+	} else {
+		stats.corrected.RecordValue(correctedValue)
+	}
+	w.latencySum += value
+	w.responses++
+}
+
+// clampLatency limits a latency in microseconds to the histograms' range
+func clampLatency(value int64) int64 {
+	switch {
+	case value > histogramMaxValue:
+		return histogramMaxValue
+	case value < 0:
+		return 0
+	default:
+		return value
+	}
+}
+
+// recordError counts a request that never got a response
+func (w *nativeWorker) recordError(endpoint int, err error) {
+	w.endpoints[endpoint].requests++
+	w.endpoints[endpoint].failed++
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		w.timeouts++
+	} else {
+		w.socketErrors++
+	}
+}
+
+func (c *NativeClient) newRequest(ctx context.Context, request types.Request) (*http.Request, error) {
+	var body io.Reader
+	if request.Body != "" {
+		body = strings.NewReader(request.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, request.Method, request.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range request.Headers {
+		if strings.EqualFold(name, "Host") {
+			// Go sends the Host header from req.Host and ignores the header map
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
+
+// nativeRequests are the requests a test sends, shared by all workers
+type nativeRequests struct {
+	templates         []feeder.Request
+	rendered          []*types.Request // Set for requests that are the same for every row
+	cumulativeWeights []float64        // Running totals of the weights
+	feeder            *types.Feeder
+	next              int64 // Next row of a sequential feeder
+}
+
+func newNativeRequests(config types.LoadTestConfig) (*nativeRequests, error) {
+	templates, err := feeder.CompileRequests(config)
+	if err != nil {
+		return nil, err
+	}
+	r := &nativeRequests{
+		templates:         templates,
+		rendered:          make([]*types.Request, len(templates)),
+		cumulativeWeights: make([]float64, len(templates)),
+		feeder:            config.Feeder,
+	}
+	var totalWeight float64
+	for i, template := range templates {
+		if template.Static() {
+			request := template.Execute(nil)
+			r.rendered[i] = &request
+		}
+		totalWeight += template.Weight
+		r.cumulativeWeights[i] = totalWeight
+	}
+	return r, nil
+}
+
+// pick returns the index of a request chosen in proportion to the weights
+func (r *nativeRequests) pick(worker *nativeWorker) int {
+	if len(r.cumulativeWeights) == 1 {
+		return 0
+	}
+	x := worker.rand.Float64() * r.cumulativeWeights[len(r.cumulativeWeights)-1]
+	return sort.Search(len(r.cumulativeWeights)-1, func(i int) bool { return r.cumulativeWeights[i] > x })
+}
+
+// request returns the request to send, filled from the worker's next row
+func (r *nativeRequests) request(endpoint int, worker *nativeWorker) types.Request {
+	if r.rendered[endpoint] != nil {
+		return *r.rendered[endpoint]
+	}
+	var row []string
+	switch rows := r.feeder.Rows; r.feeder.Mode {
+	case feeder.ModeRandom:
+		row = rows[worker.rand.Intn(len(rows))]
+	case feeder.ModeUnique:
+		row = rows[worker.id]
+	default:
+		row = rows[(atomic.AddInt64(&r.next, 1)-1)%int64(len(rows))]
+	}
+	return r.templates[endpoint].Execute(row)
+}
+
+// runWorker sends requests back to back until the test ends, the closed model
+func (c *NativeClient) runWorker(ctx context.Context, httpClient *http.Client, requests *nativeRequests, worker *nativeWorker) {
+	for ctx.Err() == nil {
+		c.send(ctx, httpClient, requests, worker, time.Time{})
+	}
+}
+
+// send sends one request and records its outcome in the worker. due is when
+// the request was meant to be sent, zero in the closed model. A request a
+// feeder row can't be built from counts as a failed request, so the worker
+// keeps going with the next row.
+func (c *NativeClient) send(ctx context.Context, httpClient *http.Client, requests *nativeRequests, worker *nativeWorker, due time.Time) {
+	sent := due
+	if sent.IsZero() {
+		sent = time.Now()
+	}
+	measured := !sent.Before(worker.measureFrom)
+
+	endpoint := requests.pick(worker)
+	req, err := c.newRequest(ctx, requests.request(endpoint, worker))
+	if err != nil {
+		if measured {
+			worker.recordError(endpoint, err)
+		}
+		return
+	}
+
+	start := time.Now()
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// Requests cut off by the end of the test are not failures
+		if ctx.Err() == nil && measured {
+			worker.recordError(endpoint, err)
+		}
+		return
+	}
+	// Drain the body so the connection can be reused
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		if ctx.Err() == nil && measured {
+			worker.recordError(endpoint, err)
+		}
+		return
+	}
+	if !measured {
+		return
+	}
+
+	stats := &worker.endpoints[endpoint]
+	stats.requests++
+	worker.statusCodes[resp.StatusCode]++
+	if resp.StatusCode >= 400 {
+		stats.failed++
+	}
+	worker.record(endpoint, time.Since(start), due)
+}
+
+// runArrivals starts a request every 1/ArrivalRate seconds until the test
+// ends, the open model. Each arrival goes to an idle worker; workers are
+// started as they are needed, up to the VU cap, and an arrival that finds
+// them all busy is dropped rather than delaying the ones after it. Arrivals
+// start a warmup before measureFrom and are only counted from then on.
+func (c *NativeClient) runArrivals(ctx context.Context, httpClient *http.Client, requests *nativeRequests, config types.LoadTestConfig, measureFrom time.Time, newWorker func(id int) *nativeWorker) (workers []*nativeWorker, dropped, late int64) {
+	idle := make(chan *nativeWorker, config.VUs())
+	var wg sync.WaitGroup
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	start := measureFrom.Add(-config.Warmup)
+	for i := 0; ; i++ {
+		due := start.Add(time.Duration(float64(i) * float64(time.Second) / float64(config.ArrivalRate)))
+		if wait := time.Until(due); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		var worker *nativeWorker
+		select {
+		case worker = <-idle:
+		default:
+			if len(workers) < config.VUs() {
+				worker = newWorker(len(workers))
+				worker.arrivals = make(chan time.Time, 1)
+				workers = append(workers, worker)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for due := range worker.arrivals {
+						c.send(ctx, httpClient, requests, worker, due)
+						idle <- worker
+					}
+				}()
+			}
+		}
+		measured := !due.Before(measureFrom)
+		if worker == nil {
+			if measured {
+				dropped++
+			}
+			continue
+		}
+		if measured && time.Since(due) > lateThreshold {
+			late++
+		}
+		worker.arrivals <- due
+	}
+
+	for _, worker := range workers {
+		close(worker.arrivals)
+	}
+	wg.Wait()
+	return workers, dropped, late
+}
+
+// RunTestResult runs the test in-process and returns the measured results directly
+func (c *NativeClient) RunTestResult(config types.LoadTestConfig) (*types.LoadTestResult, error) {
+	if config.Open() {
+		if config.ArrivalRate < 1 {
+			return nil, fmt.Errorf("native client needs an arrival rate of at least 1 request/s, got %d", config.ArrivalRate)
+		}
+	} else if config.Goroutines < 1 {
+		return nil, fmt.Errorf("native client needs at least one goroutine, got %d", config.Goroutines)
+	}
+	if err := feeder.CheckVUs(config.Feeder, config.VUs()); err != nil {
+		return nil, err
+	}
+	requests, err := newNativeRequests(config)
+	if err != nil {
+		return nil, err
+	}
+	// Validate the requests once up front instead of failing inside every worker
+	var firstRow []string
+	if config.Feeder != nil {
+		firstRow = config.Feeder.Rows[0]
+	}
+	for _, template := range requests.templates {
+		if _, err := c.newRequest(config.Ctx, template.Execute(firstRow)); err != nil {
+			return nil, fmt.Errorf("invalid request %s: %v", template.Name, err)
+		}
+	}
+
+	if config.Open() {
+		c.output.WriteLine(fmt.Sprintf("Running test at %d requests/s (up to %d goroutines) for %v%s...",
+			config.ArrivalRate, config.VUs(), config.Duration, afterWarmup(config)))
+	} else {
+		c.output.WriteLine(fmt.Sprintf("Running test with %d goroutines for %v%s...",
+			config.Goroutines, config.Duration, afterWarmup(config)))
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConns:        config.VUs(),
+			MaxIdleConnsPerHost: config.VUs(),
+		},
+	}
+	defer httpClient.CloseIdleConnections()
+
+	// The warmup is part of the run, so connections stay open into the
+	// measured part, but its requests aren't recorded
+	ctx, cancel := context.WithTimeout(config.Ctx, config.Warmup+config.Duration)
+	defer cancel()
+	measureFrom := time.Now().Add(config.Warmup)
+
+	newWorker := func(id int) *nativeWorker {
+		worker := &nativeWorker{
+			id:          id,
+			endpoints:   make([]nativeEndpoint, len(requests.templates)),
+			statusCodes: make(map[int]int64),
+			rand:        rand.New(rand.NewSource(time.Now().UnixNano() + int64(id))),
+			measureFrom: measureFrom,
+		}
+		for j := range worker.endpoints {
+			worker.endpoints[j].histogram = newHistogram()
+			worker.endpoints[j].corrected = newHistogram()
+		}
+		return worker
+	}
+
+	var workers []*nativeWorker
+	var dropped, late int64
+	if config.Open() {
+		workers, dropped, late = c.runArrivals(ctx, httpClient, requests, config, measureFrom, newWorker)
+	} else {
+		workers = make([]*nativeWorker, config.Goroutines)
+		var wg sync.WaitGroup
+		for i := range workers {
+			workers[i] = newWorker(i)
+			wg.Add(1)
+			go func(worker *nativeWorker) {
+				defer wg.Done()
+				c.runWorker(ctx, httpClient, requests, worker)
+			}(workers[i])
+		}
+		wg.Wait()
+	}
+	elapsed := time.Since(measureFrom)
+
+	if config.Ctx.Err() != nil {
+		return nil, fmt.Errorf("test cancelled")
+	}
+
+	histogram, corrected := newHistogram(), newHistogram()
+	endpoints := make([]nativeEndpoint, len(requests.templates))
+	for i := range endpoints {
+		endpoints[i].histogram = newHistogram()
+	}
+	result := &types.LoadTestResult{StatusCodes: make(map[string]int64), Dropped: dropped, Late: late}
+	for _, worker := range workers {
+		for i, stats := range worker.endpoints {
+			endpoints[i].histogram.Merge(stats.histogram)
+			corrected.Merge(stats.corrected)
+			endpoints[i].requests += stats.requests
+			endpoints[i].failed += stats.failed
+		}
+		result.Timeouts += worker.timeouts
+		result.SocketErrors += worker.socketErrors
+		result.ClampedLatencies += worker.clamped
+		for code, count := range worker.statusCodes {
+			result.StatusCodes[strconv.Itoa(code)] += count
+		}
+	}
+
+	for _, stats := range endpoints {
+		histogram.Merge(stats.histogram)
+		result.TotalRequests += stats.requests
+		result.FailedRequests += stats.failed
+	}
+
+	if histogram.TotalCount() == 0 {
+		return nil, fmt.Errorf("no responses received (%d errors)", result.FailedRequests)
+	}
+
+	result.RPS = float64(histogram.TotalCount()) / elapsed.Seconds()
+	result.P50 = float64(histogram.ValueAtQuantile(50)) / 1000
+	result.P75 = float64(histogram.ValueAtQuantile(75)) / 1000
+	result.P90 = float64(histogram.ValueAtQuantile(90)) / 1000
+	result.P99 = float64(histogram.ValueAtQuantile(99)) / 1000
+	result.CorrectedP50 = float64(corrected.ValueAtQuantile(50)) / 1000
+	result.CorrectedP75 = float64(corrected.ValueAtQuantile(75)) / 1000
+	result.CorrectedP90 = float64(corrected.ValueAtQuantile(90)) / 1000
+	result.CorrectedP99 = float64(corrected.ValueAtQuantile(99)) / 1000
+	result.ErrorRate = float64(result.FailedRequests) / float64(result.TotalRequests) * 100
+
+	if len(config.Scenario) > 0 {
+		for i, stats := range endpoints {
+			endpoint := types.EndpointResult{
+				Name:           requests.templates[i].Name,
+				Requests:       stats.requests,
+				FailedRequests: stats.failed,
+				RPS:            float64(stats.histogram.TotalCount()) / elapsed.Seconds(),
+				P50:            float64(stats.histogram.ValueAtQuantile(50)) / 1000,
+				P75:            float64(stats.histogram.ValueAtQuantile(75)) / 1000,
+				P90:            float64(stats.histogram.ValueAtQuantile(90)) / 1000,
+				P99:            float64(stats.histogram.ValueAtQuantile(99)) / 1000,
+			}
+			if stats.requests > 0 {
+				endpoint.ErrorRate = float64(stats.failed) / float64(stats.requests) * 100
+			}
+			result.Endpoints = append(result.Endpoints, endpoint)
+		}
+	}
+
+	if config.Debug {
+		c.output.WriteLine(fmt.Sprintf("\nCompleted %d requests (%d failed) in %v",
+			result.TotalRequests, result.FailedRequests, elapsed))
+		c.output.WriteLine("---")
+	}
+
+	return result, nil
+}
+
+// RunTest satisfies LoadTestClient. The runner takes the native client's
+// results from RunTestResult, so there is no text output to produce.
+func (c *NativeClient) RunTest(config types.LoadTestConfig) (string, error) {
+	return "", fmt.Errorf("the native client has no text output to parse; use RunTestResult")
+}
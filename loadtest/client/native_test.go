@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cursor-roomer/loadtest/feeder"
+	"cursor-roomer/loadtest/types"
+)
+
+func TestNativeRecordClampsLongLatencies(t *testing.T) {
+	tests := []struct {
+		name          string
+		latency       time.Duration
+		dueAgo        time.Duration // Zero in the closed model
+		wantClamped   int64
+		wantMeasured  int64 // Microseconds, at the histogram's resolution
+		wantCorrected int64
+	}{
+		{name: "in range", latency: 2 * time.Minute, wantMeasured: int64(2 * time.Minute / time.Microsecond), wantCorrected: int64(2 * time.Minute / time.Microsecond)},
+		{name: "closed model stall", latency: 2 * time.Hour, wantClamped: 1, wantMeasured: histogramMaxValue, wantCorrected: histogramMaxValue},
+		{name: "open model arrival due long ago", latency: time.Second, dueAgo: 2 * time.Hour, wantClamped: 1, wantMeasured: int64(time.Second / time.Microsecond), wantCorrected: histogramMaxValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			worker := &nativeWorker{endpoints: []nativeEndpoint{{histogram: newHistogram(), corrected: newHistogram()}}}
+			var due time.Time
+			if tt.dueAgo > 0 {
+				due = time.Now().Add(-tt.dueAgo)
+			}
+			worker.record(0, tt.latency, due)
+
+			if worker.clamped != tt.wantClamped {
+				t.Errorf("clamped = %d, want %d", worker.clamped, tt.wantClamped)
+			}
+			stats := worker.endpoints[0]
+			if count := stats.histogram.TotalCount(); count != 1 {
+				t.Fatalf("measured histogram holds %d values, want the latency recorded", count)
+			}
+			if max := stats.histogram.Max(); !stats.histogram.ValuesAreEquivalent(max, tt.wantMeasured) {
+				t.Errorf("measured latency = %dµs, want %dµs", max, tt.wantMeasured)
+			}
+			if max := stats.corrected.Max(); !stats.corrected.ValuesAreEquivalent(max, tt.wantCorrected) {
+				t.Errorf("corrected latency = %dµs, want %dµs", max, tt.wantCorrected)
+			}
+		})
+	}
+}
+
+func TestNativeWorkersKeepGoingPastBadRows(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	// Every other row names a port the URL can't be built from
+	config := types.LoadTestConfig{
+		URL:        "http://127.0.0.1:{{.port}}/",
+		Method:     "GET",
+		Goroutines: 2,
+		Duration:   200 * time.Millisecond,
+		Feeder:     &types.Feeder{Mode: feeder.ModeSequential, Fields: []string{"port"}, Rows: [][]string{{port}, {"bad"}}},
+		Ctx:        context.Background(),
+	}
+	result, err := (&NativeClient{output: discardOutput{}}).RunTestResult(config)
+	if err != nil {
+		t.Fatalf("RunTestResult: %v", err)
+	}
+	if result.FailedRequests == 0 || result.SocketErrors != result.FailedRequests {
+		t.Errorf("failed = %d with %d socket errors, want the bad rows counted as errors", result.FailedRequests, result.SocketErrors)
+	}
+	// The workers carried on after the first bad row
+	if succeeded := result.TotalRequests - result.FailedRequests; succeeded < 2 || result.FailedRequests < 2 {
+		t.Errorf("%d requests succeeded and %d failed, want the workers to keep sending", succeeded, result.FailedRequests)
+	}
+}
+
+func TestNativeOpenModelDropsArrivals(t *testing.T) {
+	// Every response takes 150ms, so the two VUs take about one arrival in
+	// seven and the rest are dropped
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(150 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		warmup      time.Duration
+		duration    time.Duration
+		wantDropped [2]int64 // Bounds, as the arrivals are timed by the clock
+	}{
+		// 50 arrivals, of which the VUs take about 8
+		{name: "no warmup", duration: 500 * time.Millisecond, wantDropped: [2]int64{36, 46}},
+		// Arrivals dropped during the warmup don't count, only those among
+		// the 30 after it
+		{name: "warmup", warmup: 300 * time.Millisecond, duration: 300 * time.Millisecond, wantDropped: [2]int64{20, 28}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := types.LoadTestConfig{
+				URL:         server.URL,
+				Method:      "GET",
+				Model:       types.ModelOpen,
+				ArrivalRate: 100,
+				MaxVUs:      2,
+				Duration:    tt.duration,
+				Warmup:      tt.warmup,
+				Ctx:         context.Background(),
+			}
+			result, err := (&NativeClient{output: discardOutput{}}).RunTestResult(config)
+			if err != nil {
+				t.Fatalf("RunTestResult: %v", err)
+			}
+			if result.Dropped < tt.wantDropped[0] || result.Dropped > tt.wantDropped[1] {
+				t.Errorf("dropped = %d, want %d to %d", result.Dropped, tt.wantDropped[0], tt.wantDropped[1])
+			}
+			// Requests cut off by the end of the test aren't failures
+			if result.FailedRequests != 0 {
+				t.Errorf("failed = %d, want none", result.FailedRequests)
+			}
+			if want := float64(result.Dropped) / float64(result.Dropped+result.TotalRequests) * 100; result.DroppedRate() != want || want < 75 {
+				t.Errorf("dropped rate = %.2f%%, want most arrivals dropped", result.DroppedRate())
+			}
+		})
+	}
+}
+
+func TestNativeArrivalsCountLate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	config := types.LoadTestConfig{URL: server.URL, Method: "GET", Model: types.ModelOpen, ArrivalRate: 100, MaxVUs: 200, Ctx: context.Background()}
+	requests, err := newNativeRequests(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newWorker := func(id int) *nativeWorker {
+		return &nativeWorker{
+			id:          id,
+			endpoints:   []nativeEndpoint{{histogram: newHistogram(), corrected: newHistogram()}},
+			statusCodes: make(map[int]int64),
+			rand:        rand.New(rand.NewSource(int64(id))),
+		}
+	}
+
+	// The schedule started a second ago, as if the generator had stalled, so
+	// the first 100 arrivals are handed out well after they were due
+	measureFrom := time.Now().Add(-time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	client := &NativeClient{output: discardOutput{}}
+	_, dropped, late := client.runArrivals(ctx, &http.Client{}, requests, config, measureFrom, newWorker)
+
+	// The 10 arrivals on schedule after that may also be a little late
+	// under load
+	if late < 95 || late > 110 {
+		t.Errorf("late = %d, want the 100 overdue arrivals", late)
+	}
+	if dropped != 0 {
+		t.Errorf("dropped = %d, want none with VUs to spare", dropped)
+	}
+}
@@ -1,15 +1,18 @@
 package client
 
 import (
+	"bytes"
 	"fmt"
-	"math/rand"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
-	"time"
 
+	"cursor-roomer/loadtest/feeder"
 	"cursor-roomer/loadtest/types"
 )
 
@@ -26,35 +29,389 @@ func (c *WRKClient) Name() string {
 	return "wrk"
 }
 
-func (c *WRKClient) createLuaScript(body string, method string) (string, error) {
-	// Create a temporary directory for the script
-	tmpDir := os.TempDir()
+// statusCountingLua counts responses per status code in each thread and
+// prints the totals when wrk finishes, so they can be parsed with the rest.
+// Scenario scripts define prepare to set up their requests and count them per
+// endpoint, which are printed too.
+const statusCountingLua = `
+local threads = {}
 
-	// Generate a random filename with timestamp and random number
-	rand.Seed(time.Now().UnixNano())
-	randomNum := rand.Intn(10000)
-	timestamp := time.Now().Format("20060102150405")
-	scriptPath := filepath.Join(tmpDir, fmt.Sprintf("wrk-script-%s-%d.lua", timestamp, randomNum))
+function setup(thread)
+  table.insert(threads, thread)
+  thread:set("id", #threads)
+end
 
-	// Create the Lua script content
-	scriptContent := fmt.Sprintf(`wrk.method = "%s"
-wrk.headers["Content-Type"] = "application/json"
-wrk.body = [[%s]]`, method, body)
+function init(args)
+  statuses = {}
+  endpoints = {}
+  math.randomseed(os.time() * 1000 + id)
+  if prepare then
+    prepare()
+  end
+end
 
-	// Write the script to a temporary file
-	err := os.WriteFile(scriptPath, []byte(scriptContent), 0644)
+function response(status, headers, body)
+  statuses[status] = (statuses[status] or 0) + 1
+end
+
+function done(summary, latency, requests)
+  local totals = {}
+  for _, thread in ipairs(threads) do
+    for status, count in pairs(thread:get("statuses")) do
+      totals[status] = (totals[status] or 0) + count
+    end
+  end
+  for status, count in pairs(totals) do
+    io.write(string.format("Status %d: %d\n", status, count))
+  end
+
+  local endpointTotals = {}
+  for _, thread in ipairs(threads) do
+    for endpoint, count in pairs(thread:get("endpoints")) do
+      endpointTotals[endpoint] = (endpointTotals[endpoint] or 0) + count
+    end
+  end
+  for endpoint, count in pairs(endpointTotals) do
+    io.write(string.format("Endpoint %d: %d\n", endpoint, count))
+  end
+end
+`
+
+// scenarioLua picks each request at random in proportion to the weights of
+// the scenario table that is generated in front of it, and fills templated
+// requests from the rows of the feeder table when there is one. wrk only
+// connects to the host on its command line, so the requests differ in method,
+// path, headers and body.
+const scenarioLua = `
+local requests = {}
+local totalWeight = 0
+local rows = {}
+local sent = 0
+
+-- loadRows reads the feeder rows, written as length-prefixed values with a
+-- newline after each row
+local function loadRows()
+  local file = assert(io.open(feeder.rowsPath, "rb"))
+  local data = file:read("*a")
+  file:close()
+  local pos = 1
+  while pos <= #data do
+    local row = {}
+    for i = 1, feeder.fields do
+      local colon = string.find(data, ":", pos, true)
+      local length = tonumber(string.sub(data, pos, colon - 1))
+      row[i] = string.sub(data, colon + 1, colon + length)
+      pos = colon + length + 1
+    end
+    rows[#rows + 1] = row
+    pos = pos + 1
+  end
+end
+
+-- nextRow returns the feeder row the next request is filled from. Threads
+-- take turns in sequential mode, so the rows go round in order across all of
+-- them.
+local function nextRow()
+  if feeder.mode == "random" then
+    return rows[math.random(#rows)]
+  end
+  local row = rows[((id - 1) + sent * feeder.threads) % #rows + 1]
+  sent = sent + 1
+  return row
+end
+
+-- escapeURL percent-encodes everything but unreserved characters
+local function escapeURL(s)
+  return (string.gsub(s, "[^%w%-%._~]", function(c)
+    return string.format("%%%02X", string.byte(c))
+  end))
+end
+
+-- render fills a template, given as literals alternating with field indexes
+local function render(template, row, escape)
+  local parts = {template[1]}
+  for i = 2, #template, 2 do
+    local value = row[template[i]]
+    if escape then
+      value = escapeURL(value)
+    end
+    parts[#parts + 1] = value
+    parts[#parts + 1] = template[i + 1]
+  end
+  return table.concat(parts)
+end
+
+local function format(r, row)
+  local headers = {}
+  for name, value in pairs(r.headers) do
+    headers[name] = render(value, row, false)
+  end
+  local body = r.bodyData
+  if r.body then
+    body = render(r.body, row, false)
+  end
+  return wrk.format(r.method, render(r.path, row, true), headers, body)
+end
+
+function prepare()
+  if feeder then
+    loadRows()
+  end
+  for i, r in ipairs(scenario) do
+    if r.bodyPath then
+      local file = assert(io.open(r.bodyPath, "rb"))
+      r.bodyData = file:read("*a")
+      file:close()
+    end
+    if not r.templated then
+      requests[i] = format(r, nil)
+    end
+    totalWeight = totalWeight + r.weight
+  end
+end
+
+function request()
+  local x = math.random() * totalWeight
+  local pick = #scenario
+  for i, r in ipairs(scenario) do
+    x = x - r.weight
+    if x < 0 then
+      pick = i
+      break
+    end
+  end
+  endpoints[pick] = (endpoints[pick] or 0) + 1
+  if scenario[pick].templated then
+    return format(scenario[pick], nextRow())
+  end
+  return requests[pick]
+end
+`
+
+// luaQuote returns s as a Lua string literal. Anything but printable ASCII
+// is written as a decimal escape, which every Lua version understands.
+func luaQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '"' || ch == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case ch >= 0x20 && ch < 0x7f:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "\\%03d", ch)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// createLuaScript writes the wrk script to a new temporary directory and
+// returns the directory and the script path. Request bodies are read from
+// data files next to the script, so they never have to be quoted. The caller
+// removes the directory.
+func (c *WRKClient) createLuaScript(config types.LoadTestConfig, threads int) (string, string, error) {
+	dir, err := os.MkdirTemp("", "wrk-script-*")
 	if err != nil {
-		return "", fmt.Errorf("failed to create Lua script: %v", err)
+		return "", "", fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	scriptPath, err := c.writeLuaScript(dir, config, threads)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	return dir, scriptPath, nil
+}
+
+func (c *WRKClient) writeLuaScript(dir string, config types.LoadTestConfig, threads int) (string, error) {
+	var script strings.Builder
+	if len(config.Scenario) > 0 || config.Feeder != nil {
+		if err := writeLuaScenario(&script, dir, config, threads); err != nil {
+			return "", err
+		}
+	} else if method := strings.ToUpper(config.Method); method != "" && method != "GET" {
+		// Non-GET requests also set the method and body
+		bodyPath := filepath.Join(dir, "body")
+		if err := os.WriteFile(bodyPath, []byte(config.Body), 0600); err != nil {
+			return "", fmt.Errorf("failed to write request body: %v", err)
+		}
+		fmt.Fprintf(&script, `wrk.method = %s
+local body = assert(io.open(%s, "rb"))
+wrk.body = body:read("*a")
+body:close()
+`, luaQuote(method), luaQuote(bodyPath))
+	}
+	script.WriteString(statusCountingLua)
+
+	if config.Debug {
+		c.output.WriteLine("Generated wrk script:")
+		c.output.WriteLine(script.String())
 	}
 
+	scriptPath := filepath.Join(dir, "script.lua")
+	if err := os.WriteFile(scriptPath, []byte(script.String()), 0600); err != nil {
+		return "", fmt.Errorf("failed to create Lua script: %v", err)
+	}
 	return scriptPath, nil
 }
 
+// writeLuaScenario writes the scenario table of the requests and, with a
+// feeder, the feeder table and its rows file
+func writeLuaScenario(script *strings.Builder, dir string, config types.LoadTestConfig, threads int) error {
+	var fields []string
+	if config.Feeder != nil {
+		if config.Feeder.Mode == feeder.ModeUnique {
+			return fmt.Errorf("wrk can't give each connection a row of its own; use a sequential or random feeder")
+		}
+		fields = config.Feeder.Fields
+	}
+	requests, err := feeder.CompileRequests(config)
+	if err != nil {
+		return err
+	}
+
+	script.WriteString("scenario = {\n")
+	for i, request := range config.Requests() {
+		path, err := wrkPath(request, fields)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(script, "  {method = %s, path = %s, weight = %s, templated = %t, headers = {",
+			luaQuote(request.Method), luaTemplate(path), strconv.FormatFloat(request.Weight, 'g', -1, 64), !requests[i].Static())
+		for _, name := range sortedKeys(request.Headers) {
+			fmt.Fprintf(script, "[%s] = %s, ", luaQuote(name), luaTemplate(requests[i].Headers[name]))
+		}
+		script.WriteString("}")
+		if body := requests[i].Body; !body.Static() {
+			fmt.Fprintf(script, ", body = %s", luaTemplate(body))
+		} else if request.Body != "" {
+			bodyPath := filepath.Join(dir, fmt.Sprintf("body-%d", i))
+			if err := os.WriteFile(bodyPath, []byte(request.Body), 0600); err != nil {
+				return fmt.Errorf("failed to write request body: %v", err)
+			}
+			fmt.Fprintf(script, ", bodyPath = %s", luaQuote(bodyPath))
+		}
+		script.WriteString("},\n")
+	}
+	script.WriteString("}\n")
+
+	if config.Feeder != nil {
+		var rows bytes.Buffer
+		for _, row := range config.Feeder.Rows {
+			for _, value := range row {
+				fmt.Fprintf(&rows, "%d:%s", len(value), value)
+			}
+			rows.WriteByte('\n')
+		}
+		rowsPath := filepath.Join(dir, "rows")
+		if err := os.WriteFile(rowsPath, rows.Bytes(), 0600); err != nil {
+			return fmt.Errorf("failed to write feeder rows: %v", err)
+		}
+		fmt.Fprintf(script, "feeder = {rowsPath = %s, mode = %s, fields = %d, threads = %d}\n",
+			luaQuote(rowsPath), luaQuote(config.Feeder.Mode), len(config.Feeder.Fields), threads)
+	}
+	script.WriteString(scenarioLua)
+	return nil
+}
+
+// wrkPath returns the template of the path and query a request sends, which
+// is its URL without the scheme and host
+func wrkPath(request types.Request, fields []string) (feeder.Template, error) {
+	u, err := url.Parse(request.URL)
+	if err != nil {
+		return feeder.Template{}, fmt.Errorf("%s: invalid URL: %v", request.Name, err)
+	}
+	t, err := feeder.Compile(request.URL, fields)
+	if err != nil {
+		return feeder.Template{}, fmt.Errorf("%s: url %v", request.Name, err)
+	}
+	if t.Static() {
+		return feeder.Template{Literals: []string{u.RequestURI()}}, nil
+	}
+
+	// The escaped form of a templated URL would escape the references too
+	prefix := u.Scheme + "://" + u.Host
+	if len(request.URL) < len(prefix) || !strings.EqualFold(request.URL[:len(prefix)], prefix) {
+		return feeder.Template{}, fmt.Errorf("%s: wrk needs the scheme and host of %s to be fixed", request.Name, request.URL)
+	}
+	path, _, _ := strings.Cut(request.URL[len(prefix):], "#")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return feeder.Compile(path, fields)
+}
+
+// luaTemplate returns a template as a Lua table of literals alternating with
+// field indexes, counted from 1 as Lua does
+func luaTemplate(t feeder.Template) string {
+	var b strings.Builder
+	b.WriteString("{")
+	b.WriteString(luaQuote(t.Literals[0]))
+	for i, field := range t.Fields {
+		fmt.Fprintf(&b, ", %d, %s", field+1, luaQuote(t.Literals[i+1]))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// sortedKeys returns the keys of m in order, so generated scripts and command
+// lines are the same on every run
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// wrkTarget returns the URL wrk connects to. A scenario's requests must all
+// go to the same scheme and host, which wrk takes from its first request;
+// the script sets the path, as it does for templated URLs.
+func wrkTarget(config types.LoadTestConfig) (string, error) {
+	if len(config.Scenario) == 0 && config.Feeder == nil {
+		return config.URL, nil
+	}
+	requests := config.Requests()
+	first, err := url.Parse(requests[0].URL)
+	if err != nil {
+		return "", fmt.Errorf("%s: invalid URL: %v", requests[0].Name, err)
+	}
+	for _, request := range requests[1:] {
+		u, err := url.Parse(request.URL)
+		if err != nil {
+			return "", fmt.Errorf("%s: invalid URL: %v", request.Name, err)
+		}
+		if u.Scheme != first.Scheme || u.Host != first.Host {
+			return "", fmt.Errorf("wrk can only send a scenario to one host, but %s goes to %s://%s and %s to %s://%s",
+				requests[0].Name, first.Scheme, first.Host, request.Name, u.Scheme, u.Host)
+		}
+	}
+	return first.Scheme + "://" + first.Host + "/", nil
+}
+
 func (c *WRKClient) RunTest(config types.LoadTestConfig) (string, error) {
 	// Check if wrk is installed
 	if _, err := exec.LookPath("wrk"); err != nil {
 		return "", fmt.Errorf("wrk is not installed. Please install it first: %v", err)
 	}
+	if config.Open() {
+		return "", fmt.Errorf("wrk has no arrival-rate mode; use k6 or native for the open model")
+	}
+
+	// wrk can't leave part of a run out of its results, so the warmup is a
+	// separate run whose output is thrown away
+	if config.Warmup > 0 {
+		c.output.WriteLine(fmt.Sprintf("Warming up for %v (results are discarded)...", config.Warmup))
+		warmup := config
+		warmup.Duration, warmup.Warmup = config.Warmup, 0
+		if _, err := c.RunTest(warmup); err != nil {
+			return "", fmt.Errorf("failed to run warmup: %v", err)
+		}
+	}
 
 	maxThreads := runtime.NumCPU() * 2
 	threads := config.Goroutines
@@ -62,6 +419,11 @@ func (c *WRKClient) RunTest(config types.LoadTestConfig) (string, error) {
 		threads = maxThreads
 	}
 
+	target, err := wrkTarget(config)
+	if err != nil {
+		return "", err
+	}
+
 	c.output.WriteLine(fmt.Sprintf("Running test with %d threads and %d connections for %v...", threads, config.Goroutines, config.Duration))
 
 	args := []string{
@@ -71,17 +433,20 @@ func (c *WRKClient) RunTest(config types.LoadTestConfig) (string, error) {
 		"--latency",
 	}
 
-	// Handle non-GET requests using Lua script
-	if config.Method != "" && config.Method != "GET" {
-		scriptPath, err := c.createLuaScript(config.Body, config.Method)
-		if err != nil {
-			return "", err
-		}
-		defer os.Remove(scriptPath) // Clean up the temporary script
-		args = append(args, "-s", scriptPath)
+	headers := config.RequestHeaders()
+	for _, name := range sortedKeys(headers) {
+		args = append(args, "-H", fmt.Sprintf("%s: %s", name, headers[name]))
+	}
+
+	// The Lua script counts status codes and handles non-GET requests and scenarios
+	scriptDir, scriptPath, err := c.createLuaScript(config, threads)
+	if err != nil {
+		return "", err
 	}
+	defer os.RemoveAll(scriptDir) // Clean up the temporary script
+	args = append(args, "-s", scriptPath)
 
-	args = append(args, config.URL)
+	args = append(args, target)
 
 	cmd := exec.CommandContext(config.Ctx, "wrk", args...)
 
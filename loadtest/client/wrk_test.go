@@ -0,0 +1,206 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cursor-roomer/loadtest/feeder"
+	"cursor-roomer/loadtest/types"
+)
+
+// luaUnquote reads the Lua short string literal at the start of s the way the
+// Lua lexer does, and returns its value and the rest of s. It only accepts
+// what luaQuote may write: raw newlines and unknown escapes are errors.
+func luaUnquote(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", fmt.Errorf("%q doesn't start with a quote", s)
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '"':
+			return b.String(), s[i+1:], nil
+		case ch == '\n' || ch == '\r':
+			return "", "", fmt.Errorf("unfinished string at byte %d", i)
+		case ch != '\\':
+			b.WriteByte(ch)
+		case i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\'):
+			i++
+			b.WriteByte(s[i])
+		default:
+			// A decimal escape takes up to three digits
+			value, digits := 0, 0
+			for digits < 3 && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9' {
+				i++
+				value = value*10 + int(s[i]-'0')
+				digits++
+			}
+			if digits == 0 || value > 255 {
+				return "", "", fmt.Errorf("invalid escape at byte %d", i)
+			}
+			b.WriteByte(byte(value))
+		}
+	}
+	return "", "", fmt.Errorf("unfinished string")
+}
+
+// luaLiterals returns the values of the string literals in generated Lua,
+// checking that everything outside them is printable ASCII
+func luaLiterals(t *testing.T, script string) []string {
+	t.Helper()
+	var literals []string
+	for rest := script; rest != ""; {
+		switch ch := rest[0]; {
+		case ch == '"':
+			value, after, err := luaUnquote(rest)
+			if err != nil {
+				t.Fatalf("invalid string literal: %v\n%s", err, script)
+			}
+			literals = append(literals, value)
+			rest = after
+		case ch == '\n' || (ch >= 0x20 && ch < 0x7f):
+			rest = rest[1:]
+		default:
+			t.Fatalf("raw byte %q outside a string literal:\n%s", ch, script)
+		}
+	}
+	return literals
+}
+
+func TestLuaQuote(t *testing.T) {
+	for _, hostile := range hostileStrings {
+		t.Run(hostile.name, func(t *testing.T) {
+			quoted := luaQuote(hostile.value)
+			for i := 0; i < len(quoted); i++ {
+				if quoted[i] < 0x20 || quoted[i] >= 0x7f {
+					t.Fatalf("%s holds the raw byte %q", quoted, quoted[i])
+				}
+			}
+			value, rest, err := luaUnquote(quoted)
+			if err != nil {
+				t.Fatalf("luaUnquote(%s): %v", quoted, err)
+			}
+			if rest != "" {
+				t.Errorf("%s ends the literal early, before %q", quoted, rest)
+			}
+			if value != hostile.value {
+				t.Errorf("%s reads back as %q, want %q", quoted, value, hostile.value)
+			}
+		})
+	}
+}
+
+// containsLiteral reports whether value is one of the literals
+func containsLiteral(literals []string, value string) bool {
+	for _, literal := range literals {
+		if literal == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWRKWriteLuaScenarioHostileInput(t *testing.T) {
+	client := &WRKClient{output: discardOutput{}}
+	for _, hostile := range hostileStrings {
+		t.Run(hostile.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := types.LoadTestConfig{
+				URL: "http://example.test/",
+				Scenario: []types.Request{
+					{
+						Name:    "templated",
+						Method:  "POST",
+						URL:     "http://example.test/items/{{.id}}?q=" + hostile.value,
+						Body:    hostile.value + "{{.id}}",
+						Headers: map[string]string{"X-Hostile": "{{.id}}" + hostile.value},
+						Weight:  1,
+					},
+					{Name: "static", Method: "PUT", URL: "http://example.test/", Body: hostile.value, Weight: 1},
+				},
+				Feeder: &types.Feeder{Mode: feeder.ModeSequential, Fields: []string{"id"}, Rows: [][]string{{hostile.value}}},
+			}
+			want := []string{"?q=" + hostile.value, hostile.value}
+			if _, err := url.Parse(config.Scenario[0].URL); err != nil {
+				// wrk can't send a URL Go can't parse, so it is rejected and
+				// only the headers and bodies carry the value
+				if _, err := client.writeLuaScript(dir, config, 2); err == nil {
+					t.Fatalf("expected %q to be rejected", config.Scenario[0].URL)
+				}
+				config.Scenario[0].URL = "http://example.test/items/{{.id}}"
+				want = want[1:]
+			}
+			scriptPath, err := client.writeLuaScript(dir, config, 2)
+			if err != nil {
+				t.Fatalf("writeLuaScript: %v", err)
+			}
+
+			script, err := os.ReadFile(scriptPath)
+			if err != nil {
+				t.Fatalf("failed to read the script: %v", err)
+			}
+			// Only the tables before the fixed part of the script are generated
+			generated, _, found := strings.Cut(string(script), scenarioLua)
+			if !found {
+				t.Fatalf("the script doesn't end with the scenario code:\n%s", script)
+			}
+			literals := luaLiterals(t, generated)
+			for _, value := range want {
+				if !containsLiteral(literals, value) {
+					t.Errorf("no literal reads back as %q in:\n%s", value, generated)
+				}
+			}
+
+			body, err := os.ReadFile(filepath.Join(dir, "body-1"))
+			if err != nil {
+				t.Fatalf("failed to read the static body: %v", err)
+			}
+			if string(body) != hostile.value {
+				t.Errorf("static body = %q, want %q", body, hostile.value)
+			}
+			rows, err := os.ReadFile(filepath.Join(dir, "rows"))
+			if err != nil {
+				t.Fatalf("failed to read the feeder rows: %v", err)
+			}
+			if want := fmt.Sprintf("%d:%s\n", len(hostile.value), hostile.value); string(rows) != want {
+				t.Errorf("rows = %q, want %q", rows, want)
+			}
+		})
+	}
+}
+
+func TestWRKWriteLuaScriptHostileBody(t *testing.T) {
+	client := &WRKClient{output: discardOutput{}}
+	for _, hostile := range hostileStrings {
+		t.Run(hostile.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := types.LoadTestConfig{URL: "http://example.test/", Method: "POST", Body: hostile.value}
+			scriptPath, err := client.writeLuaScript(dir, config, 2)
+			if err != nil {
+				t.Fatalf("writeLuaScript: %v", err)
+			}
+
+			script, err := os.ReadFile(scriptPath)
+			if err != nil {
+				t.Fatalf("failed to read the script: %v", err)
+			}
+			generated, _, _ := strings.Cut(string(script), statusCountingLua)
+			literals := luaLiterals(t, generated)
+			bodyPath := filepath.Join(dir, "body")
+			if !containsLiteral(literals, bodyPath) {
+				t.Errorf("the script doesn't read the body from %s:\n%s", bodyPath, generated)
+			}
+			body, err := os.ReadFile(bodyPath)
+			if err != nil {
+				t.Fatalf("failed to read the body: %v", err)
+			}
+			if string(body) != hostile.value {
+				t.Errorf("body = %q, want %q", body, hostile.value)
+			}
+		})
+	}
+}
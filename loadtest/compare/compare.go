@@ -0,0 +1,178 @@
+package compare
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"cursor-roomer/loadtest/stats"
+	"cursor-roomer/loadtest/types"
+)
+
+// DefaultTolerance is the capacity drop, in percent, tolerated before a
+// candidate is considered for a regression
+const DefaultTolerance = 10.0
+
+// IterationDelta pairs the results two runs measured at the same level: VU
+// count, or arrival rate when both runs used the open model.
+// Changes are relative to the base run in percent, except ErrorRateChange
+// which is the difference in percentage points.
+type IterationDelta struct {
+	Level           int                   `json:"level"`
+	Base            *types.LoadTestResult `json:"base"`
+	Candidate       *types.LoadTestResult `json:"candidate"`
+	RPSChange       float64               `json:"rpsChange"`
+	P50Change       float64               `json:"p50Change"`
+	P75Change       float64               `json:"p75Change"`
+	P90Change       float64               `json:"p90Change"`
+	P99Change       float64               `json:"p99Change"`
+	ErrorRateChange float64               `json:"errorRateChange"`
+}
+
+// Comparison is the outcome of comparing a candidate run against a base run
+type Comparison struct {
+	Iterations        []IterationDelta `json:"iterations"` // Levels both runs measured, in ascending order
+	Unit              string           `json:"unit"`       // Unit of the levels and capacities, VUs or requests/s
+	BaseCapacity      int              `json:"baseCapacity"`
+	CandidateCapacity int              `json:"candidateCapacity"`
+	CapacityChange    float64          `json:"capacityChange"` // Percent
+	BaseMaxRPS        float64          `json:"baseMaxRps"`
+	CandidateMaxRPS   float64          `json:"candidateMaxRps"`
+	MaxRPSChange      float64          `json:"maxRpsChange"` // Percent
+	// BaseNoCapacity is set when no level of the base run passed, so there
+	// is no capacity to change from and both changes above are left at zero
+	BaseNoCapacity bool `json:"baseNoCapacity,omitempty"`
+	// MeanRPSChange is the geometric mean RPS change across matched levels, in
+	// percent, and TStatistic the paired t statistic of the log RPS ratios
+	MeanRPSChange float64 `json:"meanRpsChange"`
+	TStatistic    float64 `json:"tStatistic"`
+	// Significant is set when the RPS difference across matched levels is
+	// significant at the 95% level. It needs at least two matched levels.
+	Significant bool    `json:"significant"`
+	Tolerance   float64 `json:"tolerance"`
+	Regression  bool    `json:"regression"`
+	Verdict     string  `json:"verdict"`
+}
+
+// Compare diffs two capacity reports. The candidate regressed when its
+// capacity dropped by more than tolerance percent and the throughput at the
+// levels both runs measured is significantly lower. With fewer than two
+// matched levels there is nothing to test and the capacity drop alone decides.
+// A tolerance of 0 counts any capacity drop; callers without a preference
+// pass DefaultTolerance.
+func Compare(base, candidate *types.LoadTestReport, tolerance float64) (*Comparison, error) {
+	if base == nil || candidate == nil {
+		return nil, fmt.Errorf("both runs need a capacity report to be compared")
+	}
+	if tolerance < 0 {
+		return nil, fmt.Errorf("tolerance must not be negative, got %.2f", tolerance)
+	}
+	// VU counts and arrival rates can't be matched against each other
+	if base.Model == types.ModelOpen != (candidate.Model == types.ModelOpen) {
+		return nil, fmt.Errorf("can't compare a run measured in %s with one measured in %s", base.CapacityUnit(), candidate.CapacityUnit())
+	}
+
+	c := &Comparison{
+		Unit:              base.CapacityUnit(),
+		BaseCapacity:      base.Capacity(),
+		CandidateCapacity: candidate.Capacity(),
+		CapacityChange:    percentChange(float64(base.Capacity()), float64(candidate.Capacity())),
+		BaseMaxRPS:        base.MaxRPS,
+		CandidateMaxRPS:   candidate.MaxRPS,
+		MaxRPSChange:      percentChange(base.MaxRPS, candidate.MaxRPS),
+		BaseNoCapacity:    base.Capacity() == 0,
+		Tolerance:         tolerance,
+	}
+
+	baseLevels := levels(base)
+	candidateLevels := levels(candidate)
+	var logRatios []float64
+	for level, baseResult := range baseLevels {
+		candidateResult, ok := candidateLevels[level]
+		if !ok {
+			continue
+		}
+		c.Iterations = append(c.Iterations, IterationDelta{
+			Level:           level,
+			Base:            baseResult,
+			Candidate:       candidateResult,
+			RPSChange:       percentChange(baseResult.RPS, candidateResult.RPS),
+			P50Change:       percentChange(baseResult.P50, candidateResult.P50),
+			P75Change:       percentChange(baseResult.P75, candidateResult.P75),
+			P90Change:       percentChange(baseResult.P90, candidateResult.P90),
+			P99Change:       percentChange(baseResult.P99, candidateResult.P99),
+			ErrorRateChange: candidateResult.ErrorRate - baseResult.ErrorRate,
+		})
+		if baseResult.RPS > 0 && candidateResult.RPS > 0 {
+			logRatios = append(logRatios, math.Log(candidateResult.RPS/baseResult.RPS))
+		}
+	}
+	sort.Slice(c.Iterations, func(i, j int) bool {
+		return c.Iterations[i].Level < c.Iterations[j].Level
+	})
+
+	// Throughput scales with load, so compare the ratio at each level rather
+	// than the raw RPS values
+	testable := len(logRatios) >= 2
+	if len(logRatios) > 0 {
+		meanLog := stats.Mean(logRatios)
+		c.MeanRPSChange = (math.Exp(meanLog) - 1) * 100
+		if testable {
+			stdErr := stats.StdDev(logRatios) / math.Sqrt(float64(len(logRatios)))
+			switch {
+			case stdErr > 0:
+				c.TStatistic = meanLog / stdErr
+				c.Significant = math.Abs(c.TStatistic) > stats.TCritical95(len(logRatios)-1)
+			case meanLog != 0:
+				// Every level moved by exactly the same ratio. The t statistic
+				// is unbounded, so it is left at zero.
+				c.Significant = true
+			}
+		}
+	}
+
+	capacityDropped := c.CapacityChange < -tolerance
+	throughputDropped := c.Significant && c.MeanRPSChange < 0
+	c.Regression = capacityDropped && (throughputDropped || !testable)
+
+	switch {
+	case c.BaseNoCapacity:
+		c.Verdict = fmt.Sprintf("baseline had no capacity; the candidate reached %d %s", c.CandidateCapacity, c.Unit)
+	case c.Regression && testable:
+		c.Verdict = fmt.Sprintf("regression: capacity dropped by %.1f%% and throughput is %.1f%% lower at matched levels", -c.CapacityChange, -c.MeanRPSChange)
+	case c.Regression:
+		c.Verdict = fmt.Sprintf("regression: capacity dropped by %.1f%% (too few matched levels to test throughput)", -c.CapacityChange)
+	case capacityDropped:
+		c.Verdict = fmt.Sprintf("capacity dropped by %.1f%% but throughput at matched levels is not significantly lower", -c.CapacityChange)
+	case throughputDropped:
+		c.Verdict = fmt.Sprintf("throughput is %.1f%% lower at matched levels but capacity is within the %.1f%% tolerance", -c.MeanRPSChange, tolerance)
+	default:
+		c.Verdict = "no regression"
+	}
+	return c, nil
+}
+
+// levels returns the result measured at each level of a report. A level
+// measured more than once, as can happen while refining, keeps its last result.
+func levels(report *types.LoadTestReport) map[int]*types.LoadTestResult {
+	results := make(map[int]*types.LoadTestResult)
+	if report.Baseline != nil {
+		results[1] = report.Baseline
+	}
+	for _, iteration := range report.Iterations {
+		if iteration.Result != nil {
+			results[iteration.Level()] = iteration.Result
+		}
+	}
+	return results
+}
+
+// percentChange returns the change from one value to another in percent. A
+// change from 0 has no percentage and is returned as 0, so callers that can
+// see a zero base check for it.
+func percentChange(from, to float64) float64 {
+	if from == 0 {
+		return 0
+	}
+	return (to - from) / from * 100
+}
@@ -0,0 +1,178 @@
+package compare
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// closedReport returns a closed-model report with the baseline and an
+// iteration at each VU count of rps, and the given capacity
+func closedReport(capacity int, rps map[int]float64) *types.LoadTestReport {
+	report := &types.LoadTestReport{MaxConcurrency: capacity}
+	for vus, value := range rps {
+		result := &types.LoadTestResult{RPS: value, P50: 5, P75: 8, P90: 10, P99: 20}
+		if vus == 1 {
+			report.Baseline = result
+		} else {
+			report.Iterations = append(report.Iterations, types.IterationResult{VUs: vus, Result: result})
+		}
+		if vus == capacity {
+			report.MaxRPS = value
+		}
+	}
+	return report
+}
+
+func TestCompare(t *testing.T) {
+	base := closedReport(8, map[int]float64{1: 100, 2: 200, 4: 400, 8: 800})
+	tests := []struct {
+		name            string
+		candidate       *types.LoadTestReport
+		tolerance       float64
+		wantSignificant bool
+		wantRegression  bool
+		wantVerdict     string
+	}{
+		{name: "unchanged", candidate: closedReport(8, map[int]float64{1: 100, 2: 200, 4: 400, 8: 800}),
+			tolerance: DefaultTolerance, wantVerdict: "no regression"},
+		// Noise around the same throughput isn't significant
+		{name: "noise", candidate: closedReport(8, map[int]float64{1: 103, 2: 195, 4: 410, 8: 790}),
+			tolerance: DefaultTolerance, wantVerdict: "no regression"},
+		{name: "lower capacity and throughput", candidate: closedReport(6, map[int]float64{1: 89, 2: 181, 4: 358, 6: 540}),
+			tolerance: DefaultTolerance, wantSignificant: true, wantRegression: true,
+			wantVerdict: "regression: capacity dropped by 25.0% and throughput is 10.3% lower at matched levels"},
+		{name: "lower capacity only", candidate: closedReport(6, map[int]float64{1: 103, 2: 195, 4: 410, 6: 600}),
+			tolerance: DefaultTolerance, wantVerdict: "capacity dropped by 25.0% but throughput at matched levels is not significantly lower"},
+		{name: "lower throughput only", candidate: closedReport(8, map[int]float64{1: 89, 2: 181, 4: 358, 8: 720}),
+			tolerance: DefaultTolerance, wantSignificant: true,
+			wantVerdict: "throughput is 10.3% lower at matched levels but capacity is within the 10.0% tolerance"},
+		// Only the baseline matches, so the capacity drop alone decides
+		{name: "too few matched levels", candidate: closedReport(3, map[int]float64{1: 100, 3: 300}),
+			tolerance: DefaultTolerance, wantRegression: true,
+			wantVerdict: "regression: capacity dropped by 62.5% (too few matched levels to test throughput)"},
+		{name: "zero tolerance", candidate: closedReport(7, map[int]float64{1: 100, 7: 700}),
+			wantRegression: true, wantVerdict: "regression: capacity dropped by 12.5% (too few matched levels to test throughput)"},
+		{name: "within tolerance", candidate: closedReport(7, map[int]float64{1: 100, 7: 700}),
+			tolerance: 20, wantVerdict: "no regression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Compare(base, tt.candidate, tt.tolerance)
+			if err != nil {
+				t.Fatalf("Compare: %v", err)
+			}
+			if c.Significant != tt.wantSignificant || c.Regression != tt.wantRegression {
+				t.Errorf("significant = %v, regression = %v; want %v, %v (t = %.2f)",
+					c.Significant, c.Regression, tt.wantSignificant, tt.wantRegression, c.TStatistic)
+			}
+			if c.Verdict != tt.wantVerdict {
+				t.Errorf("verdict = %q, want %q", c.Verdict, tt.wantVerdict)
+			}
+			if c.BaseNoCapacity {
+				t.Error("base run reported without capacity")
+			}
+		})
+	}
+}
+
+func TestComparePairedTTest(t *testing.T) {
+	base := closedReport(8, map[int]float64{1: 100, 2: 200, 4: 400, 8: 800})
+	candidate := closedReport(8, map[int]float64{1: 90, 2: 182, 4: 356, 8: 724})
+	c, err := Compare(base, candidate, DefaultTolerance)
+	if err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+
+	// The log ratios are ln(0.9), ln(0.91), ln(0.89) and ln(0.905)
+	logs := []float64{math.Log(0.9), math.Log(0.91), math.Log(0.89), math.Log(0.905)}
+	var mean float64
+	for _, l := range logs {
+		mean += l / 4
+	}
+	var variance float64
+	for _, l := range logs {
+		variance += (l - mean) * (l - mean) / 3
+	}
+	wantT := mean / (math.Sqrt(variance) / 2)
+	if math.Abs(c.TStatistic-wantT) > 1e-9 {
+		t.Errorf("t = %v, want %v", c.TStatistic, wantT)
+	}
+	if wantChange := (math.Exp(mean) - 1) * 100; math.Abs(c.MeanRPSChange-wantChange) > 1e-9 {
+		t.Errorf("mean RPS change = %v, want %v", c.MeanRPSChange, wantChange)
+	}
+	if !c.Significant {
+		t.Error("expected a consistent 10% drop to be significant")
+	}
+
+	if len(c.Iterations) != 4 {
+		t.Fatalf("matched %d levels, want 4", len(c.Iterations))
+	}
+	for i, level := range []int{1, 2, 4, 8} {
+		if c.Iterations[i].Level != level {
+			t.Errorf("level %d = %d VUs, want %d", i, c.Iterations[i].Level, level)
+		}
+	}
+	if d := c.Iterations[0]; d.RPSChange != -10 || d.P90Change != 0 {
+		t.Errorf("changes at 1 VU = %+.1f%% RPS, %+.1f%% P90; want -10%%, 0%%", d.RPSChange, d.P90Change)
+	}
+}
+
+func TestCompareUniformChange(t *testing.T) {
+	// Every level moved by the same ratio, so there is no variance to test
+	// against but the change is certain
+	base := closedReport(4, map[int]float64{1: 100, 2: 200, 4: 400})
+	candidate := closedReport(4, map[int]float64{1: 50, 2: 100, 4: 200})
+	c, err := Compare(base, candidate, DefaultTolerance)
+	if err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	if !c.Significant || c.TStatistic != 0 || math.Abs(c.MeanRPSChange+50) > 1e-9 {
+		t.Errorf("significant = %v, t = %v, mean change = %v; want true, 0, -50", c.Significant, c.TStatistic, c.MeanRPSChange)
+	}
+}
+
+func TestCompareBaseWithoutCapacity(t *testing.T) {
+	base := closedReport(0, map[int]float64{1: 100})
+	candidate := closedReport(4, map[int]float64{1: 100, 2: 200, 4: 400})
+	c, err := Compare(base, candidate, DefaultTolerance)
+	if err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	if !c.BaseNoCapacity {
+		t.Error("expected the base run to be reported without capacity")
+	}
+	if c.CapacityChange != 0 || c.MaxRPSChange != 0 || c.Regression {
+		t.Errorf("capacity change = %v, max RPS change = %v, regression = %v; want no change and no regression",
+			c.CapacityChange, c.MaxRPSChange, c.Regression)
+	}
+	if want := "baseline had no capacity; the candidate reached 4 VUs"; c.Verdict != want {
+		t.Errorf("verdict = %q, want %q", c.Verdict, want)
+	}
+}
+
+func TestCompareErrors(t *testing.T) {
+	closed := closedReport(4, map[int]float64{1: 100, 4: 400})
+	open := &types.LoadTestReport{Model: types.ModelOpen, MaxArrivalRate: 400}
+	tests := []struct {
+		name      string
+		base      *types.LoadTestReport
+		candidate *types.LoadTestReport
+		tolerance float64
+		want      string
+	}{
+		{name: "missing report", base: closed, want: "both runs need a capacity report"},
+		{name: "negative tolerance", base: closed, candidate: closed, tolerance: -1, want: "tolerance must not be negative"},
+		{name: "different models", base: closed, candidate: open, want: "can't compare a run measured in VUs with one measured in requests/s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compare(tt.base, tt.candidate, tt.tolerance)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
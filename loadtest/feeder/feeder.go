@@ -0,0 +1,158 @@
+package feeder
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// Modes in which requests take rows from a feeder
+const (
+	ModeSequential = "sequential" // Rows in order, shared by all virtual users, wrapping around at the end
+	ModeRandom     = "random"     // A row picked at random for every request
+	ModeUnique     = "unique"     // Each virtual user keeps a row of its own for the whole test
+)
+
+// Load reads a feeder from a CSV file with a header row, or from a JSON file
+// holding an array of objects. The format is picked by the file extension.
+func Load(path, mode string) (*types.Feeder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feeder: %v", err)
+	}
+	format := "csv"
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		format = "json"
+	}
+	f, err := Parse(bytes.NewReader(data), format, mode)
+	if err != nil {
+		return nil, fmt.Errorf("feeder %s: %v", path, err)
+	}
+	f.File = path
+	return f, nil
+}
+
+// Parse reads a feeder in the given format, csv or json
+func Parse(r io.Reader, format, mode string) (*types.Feeder, error) {
+	if mode == "" {
+		mode = ModeSequential
+	}
+	if mode != ModeSequential && mode != ModeRandom && mode != ModeUnique {
+		return nil, fmt.Errorf("unsupported feeder mode %q (use sequential, random or unique)", mode)
+	}
+
+	var fields []string
+	var rows [][]string
+	var err error
+	switch format {
+	case "csv":
+		fields, rows, err = parseCSV(r)
+	case "json":
+		fields, rows, err = parseJSON(r)
+	default:
+		return nil, fmt.Errorf("unsupported feeder format %q (use csv or json)", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("feeder has no rows")
+	}
+
+	// k6 reads the rows as JSON, which can only hold valid UTF-8
+	for i, row := range rows {
+		for j, value := range row {
+			if !utf8.ValidString(value) {
+				return nil, fmt.Errorf("row %d: %s is not valid UTF-8", i+1, fields[j])
+			}
+		}
+	}
+	return &types.Feeder{Mode: mode, Fields: fields, Rows: rows}, nil
+}
+
+func parseCSV(r io.Reader) ([]string, [][]string, error) {
+	reader := csv.NewReader(r)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CSV: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("CSV has no header row")
+	}
+
+	fields := records[0]
+	// Spreadsheets often start the file with a byte order mark
+	fields[0] = strings.TrimPrefix(fields[0], "\ufeff")
+	seen := make(map[string]bool, len(fields))
+	for i, field := range fields {
+		fields[i] = strings.TrimSpace(field)
+		if fields[i] == "" {
+			return nil, nil, fmt.Errorf("CSV header has an empty column name")
+		}
+		if seen[fields[i]] {
+			return nil, nil, fmt.Errorf("CSV header has more than one %s column", fields[i])
+		}
+		seen[fields[i]] = true
+	}
+	return fields, records[1:], nil
+}
+
+func parseJSON(r io.Reader) ([]string, [][]string, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var objects []map[string]interface{}
+	if err := decoder.Decode(&objects); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse JSON, expected an array of objects: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	var fields []string
+	for _, object := range objects {
+		for field := range object {
+			if !seen[field] {
+				seen[field] = true
+				fields = append(fields, field)
+			}
+		}
+	}
+	sort.Strings(fields)
+
+	rows := make([][]string, len(objects))
+	for i, object := range objects {
+		row := make([]string, len(fields))
+		for j, field := range fields {
+			switch value := object[field].(type) {
+			case nil:
+				// Missing fields and nulls are empty
+			case string:
+				row[j] = value
+			case json.Number:
+				row[j] = value.String()
+			case bool:
+				row[j] = fmt.Sprint(value)
+			default:
+				return nil, nil, fmt.Errorf("row %d: %s must be a string, number or boolean", i+1, field)
+			}
+		}
+		rows[i] = row
+	}
+	return fields, rows, nil
+}
+
+// CheckVUs checks that a feeder has a row for each of the given number of
+// virtual users when every one needs a row of its own
+func CheckVUs(f *types.Feeder, vus int) error {
+	if f != nil && f.Mode == ModeUnique && len(f.Rows) < vus {
+		return fmt.Errorf("unique feeder has %d rows, not enough for %d virtual users", len(f.Rows), vus)
+	}
+	return nil
+}
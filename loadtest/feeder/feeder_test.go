@@ -0,0 +1,132 @@
+package feeder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cursor-roomer/loadtest/types"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		data       string
+		wantFields []string
+		wantRows   [][]string
+	}{
+		{name: "csv", format: "csv", data: "id,name\n1,alice\n2,bob\n",
+			wantFields: []string{"id", "name"}, wantRows: [][]string{{"1", "alice"}, {"2", "bob"}}},
+		// Spreadsheets add a byte order mark and padding around names
+		{name: "csv with BOM and spaces", format: "csv", data: "\ufeff id , name\n1,\"smith, j\"\n",
+			wantFields: []string{"id", "name"}, wantRows: [][]string{{"1", "smith, j"}}},
+		// Fields are sorted, and missing fields and nulls are empty
+		{name: "json", format: "json", data: `[{"name": "alice", "id": 1, "admin": true}, {"id": 2.5, "name": null}]`,
+			wantFields: []string{"admin", "id", "name"}, wantRows: [][]string{{"true", "1", "alice"}, {"", "2.5", ""}}},
+		// Numbers keep their text rather than going through float64
+		{name: "json large number", format: "json", data: `[{"id": 12345678901234567890}]`,
+			wantFields: []string{"id"}, wantRows: [][]string{{"12345678901234567890"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse(strings.NewReader(tt.data), tt.format, "")
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if f.Mode != ModeSequential {
+				t.Errorf("mode = %q, want sequential by default", f.Mode)
+			}
+			if !reflect.DeepEqual(f.Fields, tt.wantFields) {
+				t.Errorf("fields = %q, want %q", f.Fields, tt.wantFields)
+			}
+			if !reflect.DeepEqual(f.Rows, tt.wantRows) {
+				t.Errorf("rows = %q, want %q", f.Rows, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		mode   string
+		data   string
+		want   string
+	}{
+		{name: "unknown mode", format: "csv", mode: "shuffle", data: "id\n1\n", want: `unsupported feeder mode "shuffle"`},
+		{name: "unknown format", format: "xml", data: "<rows/>", want: `unsupported feeder format "xml"`},
+		{name: "empty csv", format: "csv", data: "", want: "CSV has no header row"},
+		{name: "header only", format: "csv", data: "id,name\n", want: "feeder has no rows"},
+		{name: "empty column name", format: "csv", data: "id,,name\n1,2,3\n", want: "empty column name"},
+		{name: "duplicate column", format: "csv", data: "id,id\n1,2\n", want: "more than one id column"},
+		{name: "ragged csv", format: "csv", data: "id,name\n1\n", want: "failed to parse CSV"},
+		{name: "invalid UTF-8", format: "csv", data: "name\n\xff\n", want: "row 1: name is not valid UTF-8"},
+		{name: "json object", format: "json", data: `{"id": 1}`, want: "expected an array of objects"},
+		{name: "empty json", format: "json", data: `[]`, want: "feeder has no rows"},
+		{name: "nested json", format: "json", data: `[{"id": 1}, {"id": [1, 2]}]`, want: "row 2: id must be a string, number or boolean"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.data), tt.format, tt.mode)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	// The format follows the extension, whatever the content looks like
+	jsonPath := filepath.Join(dir, "users.JSON")
+	if err := os.WriteFile(jsonPath, []byte(`[{"user": "alice"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(jsonPath, ModeUnique)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if f.File != jsonPath || f.Mode != ModeUnique || !reflect.DeepEqual(f.Rows, [][]string{{"alice"}}) {
+		t.Errorf("feeder = %+v, want alice read from %s", f, jsonPath)
+	}
+
+	txtPath := filepath.Join(dir, "users.txt")
+	if err := os.WriteFile(txtPath, []byte(`[{"user": "alice"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(txtPath, ""); err == nil || !strings.Contains(err.Error(), "feeder "+txtPath) {
+		t.Errorf("error = %v, want the file read as CSV and rejected", err)
+	}
+	if _, err := Load(filepath.Join(dir, "missing.csv"), ""); err == nil || !strings.Contains(err.Error(), "failed to read feeder") {
+		t.Errorf("error = %v, want the missing file reported", err)
+	}
+}
+
+func TestCheckVUs(t *testing.T) {
+	rows := [][]string{{"alice"}, {"bob"}}
+	tests := []struct {
+		name    string
+		feeder  *types.Feeder
+		vus     int
+		wantErr bool
+	}{
+		{name: "no feeder", vus: 100},
+		{name: "unique with a row each", feeder: &types.Feeder{Mode: ModeUnique, Rows: rows}, vus: 2},
+		{name: "unique short of rows", feeder: &types.Feeder{Mode: ModeUnique, Rows: rows}, vus: 3, wantErr: true},
+		// Shared rows can serve any number of VUs
+		{name: "sequential", feeder: &types.Feeder{Mode: ModeSequential, Rows: rows}, vus: 100},
+		{name: "random", feeder: &types.Feeder{Mode: ModeRandom, Rows: rows}, vus: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckVUs(tt.feeder, tt.vus)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckVUs(%d) = %v, want error %v", tt.vus, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package feeder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// reference matches a {{.field}} reference in a template. Anything else
+// between braces is left as it is, so bodies can still contain {{ }}.
+var reference = regexp.MustCompile(`\{\{\s*\.([A-Za-z0-9_-]+)\s*\}\}`)
+
+// Template is a string with references to feeder fields. Rendering writes
+// Literals[0], the value of Fields[0], Literals[1] and so on; there is always
+// one more literal than there are fields.
+type Template struct {
+	Literals []string
+	Fields   []int // Indexes into the feeder's fields
+}
+
+// Compile parses the {{.field}} references in s against the feeder's fields.
+// With no feeder, fields is nil and any reference is an error.
+func Compile(s string, fields []string) (Template, error) {
+	index := make(map[string]int, len(fields))
+	for i, field := range fields {
+		index[field] = i
+	}
+
+	var t Template
+	last := 0
+	for _, match := range reference.FindAllStringSubmatchIndex(s, -1) {
+		name := s[match[2]:match[3]]
+		i, ok := index[name]
+		if !ok {
+			if fields == nil {
+				return Template{}, fmt.Errorf("refers to {{.%s}} but no feeder is set", name)
+			}
+			return Template{}, fmt.Errorf("refers to {{.%s}} but the feeder has no %s field", name, name)
+		}
+		t.Literals = append(t.Literals, s[last:match[0]])
+		t.Fields = append(t.Fields, i)
+		last = match[1]
+	}
+	t.Literals = append(t.Literals, s[last:])
+	return t, nil
+}
+
+// Static reports whether the template renders the same for every row
+func (t Template) Static() bool {
+	return len(t.Fields) == 0
+}
+
+// Execute renders the template with the values of a row. Values are passed
+// through escape first when it is set.
+func (t Template) Execute(row []string, escape func(string) string) string {
+	if t.Static() {
+		return t.Literals[0]
+	}
+	var b strings.Builder
+	b.WriteString(t.Literals[0])
+	for i, field := range t.Fields {
+		value := row[field]
+		if escape != nil {
+			value = escape(value)
+		}
+		b.WriteString(value)
+		b.WriteString(t.Literals[i+1])
+	}
+	return b.String()
+}
+
+// EscapeURL percent-encodes everything but unreserved characters, so a value
+// can go anywhere in a path or query string. That includes "/", "?", "&" and
+// "=": a value is always a single path segment or query value, never several.
+// The k6 and wrk scripts escape the same way.
+func EscapeURL(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z' || '0' <= ch && ch <= '9' || strings.IndexByte("-._~", ch) >= 0 {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+// Request is a request whose URL, headers and body are templates
+type Request struct {
+	Name    string
+	Method  string
+	URL     Template // Values are escaped with EscapeURL
+	Headers map[string]Template
+	Body    Template
+	Weight  float64
+}
+
+// Static reports whether the request is the same for every row
+func (r Request) Static() bool {
+	if !r.URL.Static() || !r.Body.Static() {
+		return false
+	}
+	for _, header := range r.Headers {
+		if !header.Static() {
+			return false
+		}
+	}
+	return true
+}
+
+// Execute renders the request with the values of a row
+func (r Request) Execute(row []string) types.Request {
+	request := types.Request{
+		Name:    r.Name,
+		Method:  r.Method,
+		URL:     r.URL.Execute(row, EscapeURL),
+		Body:    r.Body.Execute(row, nil),
+		Headers: make(map[string]string, len(r.Headers)),
+		Weight:  r.Weight,
+	}
+	for name, header := range r.Headers {
+		request.Headers[name] = header.Execute(row, nil)
+	}
+	return request
+}
+
+// CompileRequests compiles the templates of every request the config sends
+func CompileRequests(config types.LoadTestConfig) ([]Request, error) {
+	var fields []string
+	if config.Feeder != nil {
+		fields = config.Feeder.Fields
+	}
+
+	requests := config.Requests()
+	compiled := make([]Request, len(requests))
+	for i, request := range requests {
+		c := Request{
+			Name:    request.Name,
+			Method:  request.Method,
+			Headers: make(map[string]Template, len(request.Headers)),
+			Weight:  request.Weight,
+		}
+		var err error
+		if c.URL, err = Compile(request.URL, fields); err != nil {
+			return nil, fmt.Errorf("%s: url %v", request.Name, err)
+		}
+		if c.Body, err = Compile(request.Body, fields); err != nil {
+			return nil, fmt.Errorf("%s: body %v", request.Name, err)
+		}
+		for name, value := range request.Headers {
+			if c.Headers[name], err = Compile(value, fields); err != nil {
+				return nil, fmt.Errorf("%s: header %s %v", request.Name, name, err)
+			}
+		}
+		compiled[i] = c
+	}
+	return compiled, nil
+}
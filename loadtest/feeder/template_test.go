@@ -0,0 +1,105 @@
+package feeder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"cursor-roomer/loadtest/types"
+)
+
+func TestCompile(t *testing.T) {
+	fields := []string{"room_id", "user-name"}
+	row := []string{"42", "alice"}
+	tests := []struct {
+		template   string
+		wantStatic bool
+		want       string
+	}{
+		{template: "/rooms", wantStatic: true, want: "/rooms"},
+		{template: "", wantStatic: true, want: ""},
+		{template: "/rooms/{{.room_id}}", want: "/rooms/42"},
+		{template: "{{ .user-name }}:{{.room_id}}{{.room_id}}", want: "alice:4242"},
+		// Anything that isn't a field reference is left as it is
+		{template: `{"a": {{1}}, "b": "{{ .room_id }}"}`, want: `{"a": {{1}}, "b": "42"}`},
+		{template: "{{room_id}} {{.room_id", wantStatic: true, want: "{{room_id}} {{.room_id"},
+	}
+	for _, tt := range tests {
+		tmpl, err := Compile(tt.template, fields)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.template, err)
+			continue
+		}
+		if tmpl.Static() != tt.wantStatic {
+			t.Errorf("Compile(%q).Static() = %v, want %v", tt.template, tmpl.Static(), tt.wantStatic)
+		}
+		if got := tmpl.Execute(row, nil); got != tt.want {
+			t.Errorf("Compile(%q).Execute = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	if _, err := Compile("/rooms/{{.room}}", []string{"room_id"}); err == nil || !strings.Contains(err.Error(), "the feeder has no room field") {
+		t.Errorf("error = %v, want the unknown field reported", err)
+	}
+	if _, err := Compile("/rooms/{{.room}}", nil); err == nil || !strings.Contains(err.Error(), "no feeder is set") {
+		t.Errorf("error = %v, want the missing feeder reported", err)
+	}
+}
+
+func TestEscapeURL(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "Az09-._~", want: "Az09-._~"},
+		{value: "a b+c", want: "a%20b%2Bc"},
+		// Reserved characters are encoded too, so a value never adds a path
+		// segment or query parameter
+		{value: "a/b", want: "a%2Fb"},
+		{value: "x?y=1&z=#", want: "x%3Fy%3D1%26z%3D%23"},
+		{value: "%41", want: "%2541"},
+		{value: "é", want: "%C3%A9"},
+	}
+	for _, tt := range tests {
+		if got := EscapeURL(tt.value); got != tt.want {
+			t.Errorf("EscapeURL(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCompileRequests(t *testing.T) {
+	config := types.LoadTestConfig{
+		URL:     "http://example.com/rooms/{{.room}}",
+		Method:  "POST",
+		Headers: map[string]string{"Authorization": "Bearer {{.token}}"},
+		Body:    `{"room": "{{.room}}"}`,
+		Feeder:  &types.Feeder{Fields: []string{"room", "token"}},
+	}
+	requests, err := CompileRequests(config)
+	if err != nil {
+		t.Fatalf("CompileRequests: %v", err)
+	}
+	if len(requests) != 1 || requests[0].Static() {
+		t.Fatalf("requests = %+v, want one templated request", requests)
+	}
+
+	// Only the URL is escaped
+	got := requests[0].Execute([]string{"a/b c", "t/k n"})
+	want := types.Request{
+		Method:  "POST",
+		URL:     "http://example.com/rooms/a%2Fb%20c",
+		Headers: map[string]string{"Authorization": "Bearer t/k n", "Content-Type": "application/json"},
+		Body:    `{"room": "a/b c"}`,
+	}
+	got.Name, got.Weight = "", 0
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("request = %+v, want %+v", got, want)
+	}
+
+	config.URL, config.Body, config.Feeder = "http://example.com/rooms", "", nil
+	if _, err := CompileRequests(config); err == nil || !strings.Contains(err.Error(), "header Authorization refers to {{.token}}") {
+		t.Errorf("error = %v, want the header reference rejected without a feeder", err)
+	}
+}
@@ -0,0 +1,254 @@
+package gate
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+
+	"cursor-roomer/loadtest/compare"
+	"cursor-roomer/loadtest/types"
+)
+
+// BaselineLatest selects the most recent completed run of the same target as
+// the baseline to compare against. It is used when no baseline is given.
+const BaselineLatest = "latest"
+
+// Criteria are the assertions a run must meet to pass the gate. Zero values
+// disable an assertion, except MaxRegression which is disabled when unset so
+// that 0 can require no capacity drop at all.
+type Criteria struct {
+	MinCapacity   int      `yaml:"minCapacity" json:"minCapacity,omitempty"`     // VUs, or requests/s in the open model, that must pass every threshold and SLO
+	MinRPS        float64  `yaml:"minRps" json:"minRps,omitempty"`               // RPS that must be reached at the capacity found
+	MaxRegression *float64 `yaml:"maxRegression" json:"maxRegression,omitempty"` // Largest tolerated capacity drop vs the baseline, in percent
+	Baseline      string   `yaml:"baseline" json:"baseline,omitempty"`           // Run ID to compare against, "latest" when empty
+	// RequireBaseline fails MaxRegression when there is no baseline. Without
+	// it the check is skipped until the first run of a target is recorded,
+	// unless Baseline names a run.
+	RequireBaseline bool `yaml:"requireBaseline" json:"requireBaseline,omitempty"`
+}
+
+// Enabled reports whether any assertion is set
+func (c Criteria) Enabled() bool {
+	return c.MinCapacity > 0 || c.MinRPS > 0 || c.MaxRegression != nil
+}
+
+// Validate checks that the criteria are usable
+func (c Criteria) Validate() error {
+	if c.MinCapacity < 0 {
+		return fmt.Errorf("minCapacity must not be negative, got %d", c.MinCapacity)
+	}
+	if c.MinRPS < 0 {
+		return fmt.Errorf("minRps must not be negative, got %g", c.MinRPS)
+	}
+	if c.MaxRegression != nil && *c.MaxRegression < 0 {
+		return fmt.Errorf("maxRegression must not be negative, got %g", *c.MaxRegression)
+	}
+	return nil
+}
+
+// Check is the outcome of one assertion
+type Check struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Skipped bool   `json:"skipped,omitempty"` // Passed without being checked, as there was nothing to check against
+	Message string `json:"message"`
+}
+
+// Result is the gate outcome of one target
+type Result struct {
+	Target     string                `json:"target"`
+	RunID      string                `json:"runId,omitempty"`
+	BaselineID string                `json:"baselineId,omitempty"`
+	Passed     bool                  `json:"passed"`
+	Checks     []Check               `json:"checks"`
+	Error      string                `json:"error,omitempty"` // Set when the test could not be run
+	Duration   time.Duration         `json:"duration"`
+	Report     *types.LoadTestReport `json:"report"`
+}
+
+// Evaluate checks a finished run against the criteria. baseline is the
+// report of the run to compare against, nil when there is none.
+func Evaluate(result *Result, runErr error, criteria Criteria, baseline *types.LoadTestReport) {
+	report := result.Report
+	result.Checks = nil
+
+	// A search that didn't run to completion says nothing about capacity
+	completed := Check{Name: "completed", Passed: true, Message: "capacity search completed"}
+	switch {
+	case runErr != nil:
+		result.Error = runErr.Error()
+		completed = Check{Name: "completed", Message: fmt.Sprintf("load test failed: %v", runErr)}
+	case report == nil:
+		completed = Check{Name: "completed", Message: "load test produced no report"}
+	case report.StopReason == types.StopCancelled:
+		completed = Check{Name: "completed", Message: "load test was cancelled"}
+	}
+	result.Checks = append(result.Checks, completed)
+
+	if completed.Passed && criteria.MinCapacity > 0 {
+		check := Check{Name: "min_capacity", Passed: report.Capacity() >= criteria.MinCapacity}
+		if check.Passed {
+			check.Message = fmt.Sprintf("sustained %d %s (required %d)", report.Capacity(), report.CapacityUnit(), criteria.MinCapacity)
+		} else {
+			check.Message = fmt.Sprintf("sustained only %d %s (required %d); stopped with %s: %s",
+				report.Capacity(), report.CapacityUnit(), criteria.MinCapacity, report.StopReason, report.StopMessage)
+		}
+		result.Checks = append(result.Checks, check)
+	}
+
+	if completed.Passed && criteria.MinRPS > 0 {
+		check := Check{Name: "min_rps", Passed: report.MaxRPS >= criteria.MinRPS}
+		if check.Passed {
+			check.Message = fmt.Sprintf("reached %.2f RPS (required %.2f)", report.MaxRPS, criteria.MinRPS)
+		} else {
+			check.Message = fmt.Sprintf("reached only %.2f RPS (required %.2f)", report.MaxRPS, criteria.MinRPS)
+		}
+		result.Checks = append(result.Checks, check)
+	}
+
+	if completed.Passed && criteria.MaxRegression != nil {
+		maxRegression := *criteria.MaxRegression
+		check := Check{Name: "max_regression"}
+		if baseline == nil {
+			// A fresh environment has no earlier run of the target yet
+			if criteria.RequireBaseline || (criteria.Baseline != "" && criteria.Baseline != BaselineLatest) {
+				check.Message = "no baseline run to compare against"
+			} else {
+				check.Passed, check.Skipped = true, true
+				check.Message = "skipped: no earlier run to compare against yet"
+			}
+		} else if comparison, err := compare.Compare(baseline, report, maxRegression); err != nil {
+			check.Message = err.Error()
+		} else if comparison.BaseNoCapacity {
+			// There is no capacity to regress from
+			check.Passed = true
+			check.Message = fmt.Sprintf("baseline had no capacity, capacity now %d %s", comparison.CandidateCapacity, comparison.Unit)
+		} else {
+			// The gate decides on the capacity drop alone, so the message
+			// reports that rather than the comparison's verdict
+			check.Passed = comparison.CapacityChange >= -maxRegression
+			outcome := "within the limit"
+			if !check.Passed {
+				outcome = "regression"
+			}
+			check.Message = fmt.Sprintf("capacity %d -> %d %s (%+.1f%%, limit -%.1f%%): %s",
+				comparison.BaseCapacity, comparison.CandidateCapacity, comparison.Unit, comparison.CapacityChange,
+				maxRegression, outcome)
+		}
+		result.Checks = append(result.Checks, check)
+	}
+
+	result.Passed = true
+	for _, check := range result.Checks {
+		result.Passed = result.Passed && check.Passed
+	}
+}
+
+// Passed reports whether every result passed
+func Passed(results []Result) bool {
+	for _, result := range results {
+		if !result.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteJSON writes the results as a JSON document
+func WriteJSON(w io.Writer, results []Result) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Passed  bool     `json:"passed"`
+		Results []Result `json:"results"`
+	}{Passed(results), results})
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      float64         `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+	SystemOut string          `xml:"system-out,omitempty"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the results as JUnit XML, with a test suite per target
+// and a test case per assertion, so CI systems can show them like unit tests
+func WriteJUnit(w io.Writer, name string, results []Result) error {
+	suites := junitTestSuites{Name: name}
+	for _, result := range results {
+		suite := junitTestSuite{
+			Name: result.Target,
+			Time: result.Duration.Seconds(),
+		}
+		if result.Report != nil {
+			suite.SystemOut = fmt.Sprintf("Capacity: %d %s at %.2f RPS\nStop reason: %s: %s",
+				result.Report.Capacity(), result.Report.CapacityUnit(), result.Report.MaxRPS, result.Report.StopReason, result.Report.StopMessage)
+		}
+		for _, check := range result.Checks {
+			testCase := junitTestCase{Name: check.Name, ClassName: name + "." + result.Target}
+			switch {
+			case check.Skipped:
+				testCase.Skipped = &junitSkipped{Message: check.Message}
+				suite.Skipped++
+			case check.Passed:
+			case check.Name == "completed":
+				testCase.Error = &junitProblem{Message: check.Message, Type: "error", Text: check.Message}
+				suite.Errors++
+			default:
+				testCase.Failure = &junitProblem{Message: check.Message, Type: "assertion", Text: check.Message}
+				suite.Failures++
+			}
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+		suite.Tests = len(suite.TestCases)
+
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,152 @@
+package gate
+
+import (
+	"strings"
+	"testing"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// capacityReport returns a completed closed-model report with the given
+// capacity, measured at a single level
+func capacityReport(capacity int, rps float64) *types.LoadTestReport {
+	return &types.LoadTestReport{
+		Iterations:     []types.IterationResult{{VUs: capacity, Result: &types.LoadTestResult{RPS: rps}}},
+		StopReason:     types.StopLatencyThreshold,
+		MaxConcurrency: capacity,
+		MaxRPS:         rps,
+	}
+}
+
+func percent(value float64) *float64 {
+	return &value
+}
+
+func TestEvaluateMaxRegression(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxRegression *float64
+		baseline      int
+		candidate     int
+		wantCheck     bool
+		wantPassed    bool
+		wantMessage   string
+	}{
+		{name: "unset disables the check", baseline: 100, candidate: 50},
+		{name: "zero passes an unchanged capacity", maxRegression: percent(0), baseline: 100, candidate: 100, wantCheck: true, wantPassed: true, wantMessage: "within the limit"},
+		{name: "zero fails any drop", maxRegression: percent(0), baseline: 100, candidate: 99, wantCheck: true, wantMessage: "regression"},
+		{name: "drop within the limit", maxRegression: percent(10), baseline: 100, candidate: 95, wantCheck: true, wantPassed: true, wantMessage: "within the limit"},
+		// A single matched level is too few for the comparison to test
+		// throughput, but the gate still names its own outcome
+		{name: "drop past the limit", maxRegression: percent(10), baseline: 100, candidate: 80, wantCheck: true, wantMessage: "-20.0%, limit -10.0%): regression"},
+		// Nothing passed in the baseline, so there is nothing to regress from
+		{name: "baseline without capacity", maxRegression: percent(0), baseline: 0, candidate: 80, wantCheck: true, wantPassed: true, wantMessage: "baseline had no capacity, capacity now 80 VUs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &Result{Report: capacityReport(tt.candidate, float64(tt.candidate))}
+			Evaluate(result, nil, Criteria{MaxRegression: tt.maxRegression}, capacityReport(tt.baseline, float64(tt.baseline)))
+
+			var check *Check
+			for i := range result.Checks {
+				if result.Checks[i].Name == "max_regression" {
+					check = &result.Checks[i]
+				}
+			}
+			if !tt.wantCheck {
+				if check != nil {
+					t.Fatalf("expected no max_regression check, got %+v", *check)
+				}
+				return
+			}
+			if check == nil {
+				t.Fatal("expected a max_regression check")
+			}
+			if check.Passed != tt.wantPassed || result.Passed != tt.wantPassed {
+				t.Errorf("passed = %v (gate %v), want %v: %s", check.Passed, result.Passed, tt.wantPassed, check.Message)
+			}
+			if !strings.HasSuffix(check.Message, tt.wantMessage) {
+				t.Errorf("message = %q, want it to end with %q", check.Message, tt.wantMessage)
+			}
+			if strings.Contains(check.Message, "no regression") && !check.Passed {
+				t.Errorf("failing check reads as passing: %q", check.Message)
+			}
+		})
+	}
+}
+
+func TestEvaluateWithoutBaseline(t *testing.T) {
+	tests := []struct {
+		name       string
+		criteria   Criteria
+		wantPassed bool
+	}{
+		// The first run of a target has nothing to compare against yet
+		{name: "latest", criteria: Criteria{MaxRegression: percent(10)}, wantPassed: true},
+		{name: "explicit latest", criteria: Criteria{MaxRegression: percent(10), Baseline: BaselineLatest}, wantPassed: true},
+		{name: "required", criteria: Criteria{MaxRegression: percent(10), RequireBaseline: true}},
+		{name: "missing run ID", criteria: Criteria{MaxRegression: percent(10), Baseline: "20240101-000000-abcd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &Result{Target: "api", Report: capacityReport(100, 100)}
+			Evaluate(result, nil, tt.criteria, nil)
+			check := result.Checks[len(result.Checks)-1]
+			if check.Name != "max_regression" || check.Passed != tt.wantPassed || check.Skipped != tt.wantPassed || result.Passed != tt.wantPassed {
+				t.Fatalf("check = %+v (gate %v), want passed and skipped = %v", check, result.Passed, tt.wantPassed)
+			}
+
+			var junit strings.Builder
+			if err := WriteJUnit(&junit, "roomer", []Result{*result}); err != nil {
+				t.Fatalf("WriteJUnit: %v", err)
+			}
+			if skipped := strings.Contains(junit.String(), "<skipped message="); skipped != tt.wantPassed {
+				t.Errorf("JUnit skipped = %v, want %v:\n%s", skipped, tt.wantPassed, junit.String())
+			}
+			if failed := strings.Contains(junit.String(), "<failure"); failed == tt.wantPassed {
+				t.Errorf("JUnit failure = %v, want %v:\n%s", failed, !tt.wantPassed, junit.String())
+			}
+		})
+	}
+}
+
+func TestEvaluateMaxRegressionUnit(t *testing.T) {
+	openReport := func(rate int) *types.LoadTestReport {
+		report := capacityReport(0, float64(rate))
+		report.Model = types.ModelOpen
+		report.Iterations[0].Rate = rate
+		report.MaxArrivalRate = rate
+		return report
+	}
+	tests := []struct {
+		name      string
+		baseline  *types.LoadTestReport
+		candidate *types.LoadTestReport
+		want      string
+	}{
+		{name: "closed model", baseline: capacityReport(100, 100), candidate: capacityReport(90, 90), want: "capacity 100 -> 90 VUs"},
+		{name: "open model", baseline: openReport(400), candidate: openReport(360), want: "capacity 400 -> 360 requests/s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &Result{Report: tt.candidate}
+			Evaluate(result, nil, Criteria{MaxRegression: percent(20)}, tt.baseline)
+			check := result.Checks[len(result.Checks)-1]
+			if !strings.HasPrefix(check.Message, tt.want) {
+				t.Errorf("message = %q, want it to start with %q", check.Message, tt.want)
+			}
+		})
+	}
+}
+
+func TestCriteriaValidate(t *testing.T) {
+	if err := (Criteria{MaxRegression: percent(0)}).Validate(); err != nil {
+		t.Errorf("zero max regression: %v", err)
+	}
+	if err := (Criteria{MaxRegression: percent(-1)}).Validate(); err == nil {
+		t.Error("expected a negative max regression to be rejected")
+	}
+	if !(Criteria{MaxRegression: percent(0)}).Enabled() {
+		t.Error("expected a zero max regression to enable the gate")
+	}
+}
@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// ghzReport mirrors the fields we need from ghz's JSON report
+type ghzReport struct {
+	Count                  int64            `json:"count"`
+	RPS                    float64          `json:"rps"`
+	StatusCodeDistribution map[string]int64 `json:"statusCodeDistribution"`
+	ErrorDistribution      map[string]int64 `json:"errorDistribution"`
+	LatencyDistribution    []struct {
+		Percentage int   `json:"percentage"`
+		Latency    int64 `json:"latency"` // nanoseconds
+	} `json:"latencyDistribution"`
+}
+
+// ParseGHZOutput parses the JSON report from ghz into a LoadTestResult
+func ParseGHZOutput(output string) (*types.LoadTestResult, error) {
+	var report ghzReport
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		return nil, fmt.Errorf("failed to decode ghz report: %v", err)
+	}
+
+	result := &types.LoadTestResult{
+		RPS:           report.RPS,
+		TotalRequests: report.Count,
+		StatusCodes:   report.StatusCodeDistribution,
+		Timeouts:      report.StatusCodeDistribution["DeadlineExceeded"],
+	}
+
+	for _, count := range report.ErrorDistribution {
+		result.FailedRequests += count
+	}
+	if report.Count > 0 {
+		result.ErrorRate = float64(result.FailedRequests) / float64(report.Count) * 100
+	}
+
+	for _, bucket := range report.LatencyDistribution {
+		latency := float64(bucket.Latency) / 1e6
+		switch bucket.Percentage {
+		case 50:
+			result.P50 = latency
+		case 75:
+			result.P75 = latency
+		case 90:
+			result.P90 = latency
+		case 99:
+			result.P99 = latency
+		}
+	}
+
+	// Verify we got all values
+	if result.RPS == 0 {
+		return nil, fmt.Errorf("failed to parse RPS from output")
+	}
+	// ghz leaves the latency distribution out when every call failed, which
+	// the error rate threshold has to catch rather than a parse error
+	allFailed := report.Count > 0 && result.FailedRequests == report.Count
+	if !allFailed && (result.P50 == 0 || result.P75 == 0 || result.P90 == 0 || result.P99 == 0) {
+		return nil, fmt.Errorf("failed to parse latency percentiles from output")
+	}
+
+	return result, nil
+}
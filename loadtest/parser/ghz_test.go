@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func readTestdata(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestParseGHZOutput(t *testing.T) {
+	result, err := ParseGHZOutput(readTestdata(t, "ghz_report.json"))
+	if err != nil {
+		t.Fatalf("ParseGHZOutput: %v", err)
+	}
+
+	if result.RPS != 5925.362063280634 {
+		t.Errorf("RPS = %v, want 5925.362063280634", result.RPS)
+	}
+	if result.TotalRequests != 5942 || result.FailedRequests != 0 || result.ErrorRate != 0 {
+		t.Errorf("requests = %d, failed = %d, error rate = %v; want 5942, 0, 0",
+			result.TotalRequests, result.FailedRequests, result.ErrorRate)
+	}
+	// ghz reports latencies in nanoseconds, results are in milliseconds
+	want := [4]float64{0.536344, 0.659454, 0.812771, 3.596086}
+	if got := [4]float64{result.P50, result.P75, result.P90, result.P99}; got != want {
+		t.Errorf("P50-P99 = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(result.StatusCodes, map[string]int64{"OK": 5942}) {
+		t.Errorf("status codes = %v, want OK: 5942", result.StatusCodes)
+	}
+}
+
+func TestParseGHZOutputAllCallsFailed(t *testing.T) {
+	result, err := ParseGHZOutput(readTestdata(t, "ghz_report_errors.json"))
+	if err != nil {
+		t.Fatalf("ParseGHZOutput: %v", err)
+	}
+
+	if result.TotalRequests != 7309 || result.FailedRequests != 7309 || result.ErrorRate != 100 {
+		t.Errorf("requests = %d, failed = %d, error rate = %v; want 7309, 7309, 100",
+			result.TotalRequests, result.FailedRequests, result.ErrorRate)
+	}
+	if !reflect.DeepEqual(result.StatusCodes, map[string]int64{"NotFound": 7307, "Unavailable": 2}) {
+		t.Errorf("status codes = %v", result.StatusCodes)
+	}
+}
+
+func TestParseGHZOutputInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+	}{
+		{name: "not json", output: "Summary:\n  Count: 10"},
+		{name: "no rps", output: `{"count": 0, "rps": 0}`},
+		{name: "no latencies", output: `{"count": 10, "rps": 5, "statusCodeDistribution": {"OK": 10}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseGHZOutput(tt.output); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
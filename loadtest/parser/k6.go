@@ -1,90 +1,135 @@
 package parser
 
 import (
+	"encoding/json"
 	"fmt"
-	"regexp"
-	"strconv"
 	"strings"
+	"time"
 
 	"cursor-roomer/loadtest/types"
 )
 
-// ParseK6Output parses the output from k6 command into a LoadTestResult
-func ParseK6Output(output string) (*types.LoadTestResult, error) {
-	lines := strings.Split(output, "\n")
-	result := &types.LoadTestResult{}
+// k6Metric holds the values k6 writes for a metric in its summary export.
+// Trends fill the percentile fields, counters fill Count and Rate, and rates
+// fill Passes, Fails and Value.
+type k6Metric struct {
+	Count  float64 `json:"count"`
+	Rate   float64 `json:"rate"`
+	Passes float64 `json:"passes"`
+	Fails  float64 `json:"fails"`
+	Value  float64 `json:"value"`
+	P50    float64 `json:"p(50)"`
+	P75    float64 `json:"p(75)"`
+	P90    float64 `json:"p(90)"`
+	P99    float64 `json:"p(99)"`
+}
+
+// k6Summary mirrors the file written by `k6 run --summary-export`
+type k6Summary struct {
+	Metrics map[string]k6Metric `json:"metrics"`
+}
 
-	// Regular expressions for parsing k6 output
-	rpsRegex := regexp.MustCompile(`http_reqs\.*:\s*(\d+\.?\d*)\s*(\d+\.?\d*)/s`)
-	p50Regex := regexp.MustCompile(`http_req_duration.*p\(50\)\s*=\s*(\d+\.?\d*)(ms|s)`)
-	p75Regex := regexp.MustCompile(`http_req_duration.*p\(75\)\s*=\s*(\d+\.?\d*)(ms|s)`)
-	p90Regex := regexp.MustCompile(`http_req_duration.*p\(90\)\s*=\s*(\d+\.?\d*)(ms|s)`)
-	p99Regex := regexp.MustCompile(`http_req_duration.*p\(99\)\s*=\s*(\d+\.?\d*)(ms|s)`)
+// ParseK6Output parses the JSON summary exported by k6 into a LoadTestResult.
+// endpoints names the requests of a scenario, in order; their results come
+// from the submetrics of the endpoint tag the generated script sets.
+// measured is the length of the run after its warmup, or zero when it had
+// none; the results then come from the submetrics of the measured phase.
+func ParseK6Output(output string, endpoints []string, measured time.Duration) (*types.LoadTestResult, error) {
+	var summary k6Summary
+	if err := json.Unmarshal([]byte(output), &summary); err != nil {
+		return nil, fmt.Errorf("failed to decode k6 summary: %v", err)
+	}
 
-	// Try to find the summary section
-	summaryStart := -1
-	for i, line := range lines {
-		if strings.Contains(line, "http_req_duration") {
-			summaryStart = i
-			break
+	// metric looks up the submetric of the given tags, limited to the
+	// measured phase after a warmup
+	metric := func(name string, tags ...string) (k6Metric, bool) {
+		if measured > 0 {
+			tags = append(tags, "phase:measure")
+		}
+		if len(tags) > 0 {
+			name += "{" + strings.Join(tags, ",") + "}"
+		}
+		m, ok := summary.Metrics[name]
+		return m, ok
+	}
+	// rate is the per second rate of a counter; k6 divides by the whole run,
+	// warmup included
+	rate := func(m k6Metric) float64 {
+		if measured > 0 {
+			return m.Count / measured.Seconds()
 		}
+		return m.Rate
 	}
 
-	if summaryStart == -1 {
-		return nil, fmt.Errorf("could not find summary section in k6 output")
+	reqs, ok := metric("http_reqs")
+	if !ok {
+		return nil, fmt.Errorf("k6 summary has no http_reqs metric")
+	}
+	duration, ok := metric("http_req_duration")
+	if !ok {
+		return nil, fmt.Errorf("k6 summary has no http_req_duration metric")
 	}
 
-	// Parse the summary section
-	for i := summaryStart; i < len(lines); i++ {
-		line := lines[i]
-		// Parse RPS
-		if matches := rpsRegex.FindStringSubmatch(line); len(matches) > 2 {
-			fmt.Printf("Found RPS line: %s\n", line)
-			fmt.Printf("Matches: %v\n", matches)
-			rps, err := strconv.ParseFloat(matches[2], 64)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse RPS: %v", err)
-			}
-			result.RPS = rps
-		} else if strings.Contains(line, "http_reqs") {
-			fmt.Printf("Found http_reqs line but didn't match: %s\n", line)
-		}
+	// k6 durations are already in milliseconds
+	result := &types.LoadTestResult{
+		RPS:           rate(reqs),
+		P50:           duration.P50,
+		P75:           duration.P75,
+		P90:           duration.P90,
+		P99:           duration.P99,
+		TotalRequests: int64(reqs.Count),
+	}
 
-		// Parse P50
-		if matches := p50Regex.FindStringSubmatch(line); len(matches) > 2 {
-			val, err := parseK6Latency(matches[1], matches[2])
-			if err != nil {
-				return nil, err
-			}
-			result.P50 = val
-		}
+	// http_req_failed is a rate metric where a "pass" is a failed request
+	if failed, ok := metric("http_req_failed"); ok {
+		result.ErrorRate = failed.Value * 100
+		result.FailedRequests = int64(failed.Passes)
+	}
 
-		// Parse P75
-		if matches := p75Regex.FindStringSubmatch(line); len(matches) > 2 {
-			val, err := parseK6Latency(matches[1], matches[2])
-			if err != nil {
-				return nil, err
+	// The generated script counts responses per status class
+	for _, class := range []string{"1xx", "2xx", "3xx", "4xx", "5xx"} {
+		if counter, ok := metric("status_" + class); ok && counter.Count > 0 {
+			if result.StatusCodes == nil {
+				result.StatusCodes = make(map[string]int64)
 			}
-			result.P75 = val
+			result.StatusCodes[class] = int64(counter.Count)
 		}
+	}
+	timeouts, _ := metric("request_timeouts")
+	socketErrors, _ := metric("socket_errors")
+	result.Timeouts = int64(timeouts.Count)
+	result.SocketErrors = int64(socketErrors.Count)
 
-		// Parse P90
-		if matches := p90Regex.FindStringSubmatch(line); len(matches) > 2 {
-			val, err := parseK6Latency(matches[1], matches[2])
-			if err != nil {
-				return nil, err
-			}
-			result.P90 = val
-		}
+	// Set by the constant-arrival-rate executor of the open model when every
+	// VU was busy at an arrival. Dropped iterations run no code to tag, so
+	// after a warmup they come from the measure scenario instead.
+	if measured > 0 {
+		result.Dropped = int64(summary.Metrics["dropped_iterations{scenario:measure}"].Count)
+	} else {
+		result.Dropped = int64(summary.Metrics["dropped_iterations"].Count)
+	}
 
-		// Parse P99
-		if matches := p99Regex.FindStringSubmatch(line); len(matches) > 2 {
-			val, err := parseK6Latency(matches[1], matches[2])
-			if err != nil {
-				return nil, err
-			}
-			result.P99 = val
-		}
+	if checks, ok := metric("checks"); ok {
+		result.ChecksPassed = int64(checks.Passes)
+		result.ChecksFailed = int64(checks.Fails)
+	}
+
+	for i, name := range endpoints {
+		tag := fmt.Sprintf("endpoint:e%d", i)
+		reqs, _ := metric("http_reqs", tag)
+		duration, _ := metric("http_req_duration", tag)
+		failed, _ := metric("http_req_failed", tag)
+		result.Endpoints = append(result.Endpoints, types.EndpointResult{
+			Name:           name,
+			Requests:       int64(reqs.Count),
+			FailedRequests: int64(failed.Passes),
+			ErrorRate:      failed.Value * 100,
+			RPS:            rate(reqs),
+			P50:            duration.P50,
+			P75:            duration.P75,
+			P90:            duration.P90,
+			P99:            duration.P99,
+		})
 	}
 
 	// Verify we got all values
@@ -97,20 +142,3 @@ func ParseK6Output(output string) (*types.LoadTestResult, error) {
 
 	return result, nil
 }
-
-// parseK6Latency converts a k6 latency value to milliseconds
-func parseK6Latency(value, unit string) (float64, error) {
-	val, err := strconv.ParseFloat(value, 64)
-	if err != nil {
-		return 0, err
-	}
-
-	switch unit {
-	case "ms":
-		return val, nil
-	case "s":
-		return val * 1000, nil
-	default:
-		return 0, fmt.Errorf("unknown time unit in k6 latency value: %s", unit)
-	}
-}
@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cursor-roomer/loadtest/types"
+)
+
+func TestParseK6Output(t *testing.T) {
+	// A closed-model scenario of 20 VUs for 10s, sending GET /rooms three
+	// times as often as POST /rooms
+	result, err := ParseK6Output(readTestdata(t, "k6_summary.json"), []string{"GET /rooms", "POST /rooms"}, 0)
+	if err != nil {
+		t.Fatalf("ParseK6Output: %v", err)
+	}
+
+	if result.RPS != 479.6803 {
+		t.Errorf("RPS = %v, want 479.6803", result.RPS)
+	}
+	if result.TotalRequests != 4800 || result.FailedRequests != 62 || math.Abs(result.ErrorRate-1.2916666666666667) > 1e-9 {
+		t.Errorf("requests = %d, failed = %d, error rate = %v; want 4800, 62, 1.29",
+			result.TotalRequests, result.FailedRequests, result.ErrorRate)
+	}
+	want := [4]float64{35.62, 48.9, 71.35, 188.04}
+	if got := [4]float64{result.P50, result.P75, result.P90, result.P99}; got != want {
+		t.Errorf("P50-P99 = %v, want %v", got, want)
+	}
+	// Socket errors and timeouts have no status, so the classes don't add
+	// up to the requests
+	if !reflect.DeepEqual(result.StatusCodes, map[string]int64{"2xx": 4738, "4xx": 40, "5xx": 12}) {
+		t.Errorf("status codes = %v, want 2xx: 4738, 4xx: 40, 5xx: 12", result.StatusCodes)
+	}
+	if result.Timeouts != 3 || result.SocketErrors != 7 || result.Dropped != 0 {
+		t.Errorf("timeouts = %d, socket errors = %d, dropped = %d; want 3, 7, 0", result.Timeouts, result.SocketErrors, result.Dropped)
+	}
+	if result.ChecksPassed != 4738 || result.ChecksFailed != 62 {
+		t.Errorf("checks = %d passed, %d failed; want 4738, 62", result.ChecksPassed, result.ChecksFailed)
+	}
+
+	wantEndpoints := []types.EndpointResult{
+		{Name: "GET /rooms", Requests: 3600, FailedRequests: 14, ErrorRate: 0.3888888888888889, RPS: 359.7602,
+			P50: 30.11, P75: 41.02, P90: 59.77, P99: 140.3},
+		{Name: "POST /rooms", Requests: 1200, FailedRequests: 48, ErrorRate: 4, RPS: 119.9201,
+			P50: 58.2, P75: 77.46, P90: 104.9, P99: 301.72},
+	}
+	if !reflect.DeepEqual(result.Endpoints, wantEndpoints) {
+		t.Errorf("endpoints = %+v, want %+v", result.Endpoints, wantEndpoints)
+	}
+}
+
+func TestParseK6OutputInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		summary string
+		want    string
+	}{
+		{name: "not JSON", summary: "time=... level=error msg=...", want: "failed to decode k6 summary"},
+		{name: "no requests", summary: `{"metrics": {"http_req_duration": {"p(50)": 1}}}`, want: "no http_reqs metric"},
+		{name: "no durations", summary: `{"metrics": {"http_reqs": {"count": 10, "rate": 1}}}`, want: "no http_req_duration metric"},
+		// Every request failed before it was timed
+		{name: "no latencies", summary: `{"metrics": {"http_reqs": {"count": 10, "rate": 1}, "http_req_duration": {}}}`,
+			want: "failed to parse latency percentiles"},
+		{name: "no rate", summary: `{"metrics": {"http_reqs": {}, "http_req_duration": {"p(50)": 1}}}`, want: "failed to parse RPS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseK6Output(tt.summary, nil, 0)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseK6OutputAfterWarmup(t *testing.T) {
+	// An open-model run at 600 requests/s: 5s of warmup, then 5s measured
+	result, err := ParseK6Output(readTestdata(t, "k6_summary_warmup.json"), nil, 5*time.Second)
+	if err != nil {
+		t.Fatalf("ParseK6Output: %v", err)
+	}
+
+	// Only the measured phase counts, and its rate is over its own length
+	if result.TotalRequests != 3000 || result.FailedRequests != 15 || result.RPS != 600 {
+		t.Errorf("requests = %d, failed = %d, RPS = %v; want 3000, 15, 600", result.TotalRequests, result.FailedRequests, result.RPS)
+	}
+	want := [4]float64{7.51, 10.24, 14.87, 42.19}
+	if got := [4]float64{result.P50, result.P75, result.P90, result.P99}; got != want {
+		t.Errorf("P50-P99 = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(result.StatusCodes, map[string]int64{"2xx": 2985, "5xx": 15}) {
+		t.Errorf("status codes = %v, want 2xx: 2985, 5xx: 15", result.StatusCodes)
+	}
+	// The iterations dropped while the warmup filled the VU pool aren't
+	// part of the measured drop rate
+	if result.Dropped != 12 {
+		t.Errorf("dropped = %d, want the 12 of the measure scenario", result.Dropped)
+	}
+	if rate := result.DroppedRate(); rate > 0.4 {
+		t.Errorf("dropped rate = %.2f%%, want the measured phase's 0.40%%", rate)
+	}
+}
@@ -8,12 +8,67 @@ import (
 	"cursor-roomer/loadtest/types"
 )
 
-// ParseWRKOutput parses the output from wrk command into a LoadTestResult
-func ParseWRKOutput(output string) (*types.LoadTestResult, error) {
+// ParseWRKOutput parses the output from wrk command into a LoadTestResult.
+// endpoints names the requests of a scenario, in order. wrk can't attribute
+// responses to requests, so their results only hold the number sent.
+func ParseWRKOutput(output string, endpoints []string) (*types.LoadTestResult, error) {
 	lines := strings.Split(output, "\n")
 	result := &types.LoadTestResult{}
+	sent := make([]int64, len(endpoints))
 
+	var non2xx int64
 	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		// Parse total requests, e.g. "1234 requests in 10.01s, 1.2MB read"
+		if strings.Contains(trimmed, " requests in ") {
+			if total, err := strconv.ParseInt(strings.Fields(trimmed)[0], 10, 64); err == nil {
+				result.TotalRequests = total
+			}
+		}
+		// Parse socket errors, e.g. "Socket errors: connect 0, read 0, write 0, timeout 12"
+		if strings.HasPrefix(trimmed, "Socket errors:") {
+			socketErrors, timeouts, err := parseWRKSocketErrors(strings.TrimPrefix(trimmed, "Socket errors:"))
+			if err != nil {
+				return nil, err
+			}
+			result.SocketErrors = socketErrors
+			result.Timeouts = timeouts
+		}
+		// Parse error responses, e.g. "Non-2xx or 3xx responses: 34"
+		if strings.HasPrefix(trimmed, "Non-2xx or 3xx responses:") {
+			val, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(trimmed, "Non-2xx or 3xx responses:")), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse non-2xx responses: %v", err)
+			}
+			non2xx = val
+		}
+		// Parse per-status counts printed by our Lua script, e.g. "Status 200: 1200"
+		if strings.HasPrefix(trimmed, "Status ") {
+			parts := strings.Fields(strings.TrimPrefix(trimmed, "Status "))
+			if len(parts) == 2 {
+				if count, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+					if result.StatusCodes == nil {
+						result.StatusCodes = make(map[string]int64)
+					}
+					result.StatusCodes[strings.TrimSuffix(parts[0], ":")] = count
+				}
+			}
+		}
+
+		// Parse per-endpoint counts of a scenario, e.g. "Endpoint 2: 310"
+		if strings.HasPrefix(trimmed, "Endpoint ") {
+			parts := strings.Fields(strings.TrimPrefix(trimmed, "Endpoint "))
+			if len(parts) == 2 {
+				index, indexErr := strconv.Atoi(strings.TrimSuffix(parts[0], ":"))
+				count, countErr := strconv.ParseInt(parts[1], 10, 64)
+				// The Lua script numbers endpoints from 1
+				if indexErr == nil && countErr == nil && index >= 1 && index <= len(sent) {
+					sent[index-1] = count
+				}
+			}
+		}
+
 		// Parse RPS
 		if strings.HasPrefix(line, "Requests/sec:") {
 			parts := strings.Fields(line)
@@ -68,6 +123,25 @@ func ParseWRKOutput(output string) (*types.LoadTestResult, error) {
 		}
 	}
 
+	var totalSent int64
+	for _, count := range sent {
+		totalSent += count
+	}
+	for i, name := range endpoints {
+		endpoint := types.EndpointResult{Name: name, Requests: sent[i]}
+		if totalSent > 0 {
+			endpoint.RPS = result.RPS * float64(sent[i]) / float64(totalSent)
+		}
+		result.Endpoints = append(result.Endpoints, endpoint)
+	}
+
+	// wrk only counts completed responses, so requests that hit socket errors
+	// or timed out are added on top to get the number of attempts
+	result.FailedRequests = non2xx + result.SocketErrors + result.Timeouts
+	if attempts := result.TotalRequests + result.SocketErrors + result.Timeouts; attempts > 0 {
+		result.ErrorRate = float64(result.FailedRequests) / float64(attempts) * 100
+	}
+
 	// Verify we got all values
 	if result.RPS == 0 {
 		return nil, fmt.Errorf("failed to parse RPS from output")
@@ -79,21 +153,51 @@ func ParseWRKOutput(output string) (*types.LoadTestResult, error) {
 	return result, nil
 }
 
-// ParseLatency converts a latency string (e.g. "123.45ms" or "1.03s") to milliseconds
-func ParseLatency(s string) (float64, error) {
-	s = strings.TrimSpace(s)
-	if strings.HasSuffix(s, "ms") {
-		val, err := strconv.ParseFloat(strings.TrimSuffix(s, "ms"), 64)
-		if err != nil {
-			return 0, err
+// parseWRKSocketErrors parses "connect 0, read 0, write 0, timeout 12" into
+// the number of connect, read and write errors and the number of timeouts
+func parseWRKSocketErrors(s string) (int64, int64, error) {
+	var socketErrors, timeouts int64
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Fields(part)
+		if len(fields) != 2 {
+			continue
 		}
-		return val, nil
-	} else if strings.HasSuffix(s, "s") {
-		val, err := strconv.ParseFloat(strings.TrimSuffix(s, "s"), 64)
+		val, err := strconv.ParseInt(fields[1], 10, 64)
 		if err != nil {
-			return 0, err
+			return 0, 0, fmt.Errorf("failed to parse socket errors: %v", err)
+		}
+		if fields[0] == "timeout" {
+			timeouts = val
+		} else {
+			socketErrors += val
+		}
+	}
+	return socketErrors, timeouts, nil
+}
+
+// latencyUnits are checked in order, so "ms" and "us" aren't read as "s"
+var latencyUnits = []struct {
+	suffix string
+	millis float64
+}{
+	{"ms", 1},
+	{"us", 0.001},
+	{"µs", 0.001},
+	{"s", 1000},
+}
+
+// ParseLatency converts a latency string (e.g. "512.00us", "123.45ms" or
+// "1.03s") to milliseconds
+func ParseLatency(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	for _, unit := range latencyUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			val, err := strconv.ParseFloat(strings.TrimSuffix(s, unit.suffix), 64)
+			if err != nil {
+				return 0, err
+			}
+			return val * unit.millis, nil
 		}
-		return val * 1000, nil
 	}
 	return 0, fmt.Errorf("unknown time unit in latency value: %s", s)
-} 
\ No newline at end of file
+}
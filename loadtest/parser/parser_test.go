@@ -0,0 +1,129 @@
+package parser
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseWRKOutput(t *testing.T) {
+	result, err := ParseWRKOutput(readTestdata(t, "wrk_output.txt"), nil)
+	if err != nil {
+		t.Fatalf("ParseWRKOutput: %v", err)
+	}
+	if result.RPS != 8204.20 || result.TotalRequests != 82124 {
+		t.Errorf("RPS = %v, requests = %d; want 8204.20, 82124", result.RPS, result.TotalRequests)
+	}
+	want := [4]float64{11.21, 14.38, 18.93, 35.07}
+	if got := [4]float64{result.P50, result.P75, result.P90, result.P99}; got != want {
+		t.Errorf("P50-P99 = %v, want %v", got, want)
+	}
+	if result.FailedRequests != 0 || result.ErrorRate != 0 || result.SocketErrors != 0 || result.Timeouts != 0 {
+		t.Errorf("failed = %d, error rate = %v, socket errors = %d, timeouts = %d; want none",
+			result.FailedRequests, result.ErrorRate, result.SocketErrors, result.Timeouts)
+	}
+	if !reflect.DeepEqual(result.StatusCodes, map[string]int64{"200": 82124}) {
+		t.Errorf("status codes = %v, want 200: 82124", result.StatusCodes)
+	}
+}
+
+func TestParseWRKOutputWithErrors(t *testing.T) {
+	result, err := ParseWRKOutput(readTestdata(t, "wrk_output_errors.txt"), []string{"GET /rooms", "POST /rooms"})
+	if err != nil {
+		t.Fatalf("ParseWRKOutput: %v", err)
+	}
+
+	// wrk prints sub-millisecond latencies in microseconds
+	want := [4]float64{0.612, 0.9805, 1210, 1980}
+	for i, got := range [4]float64{result.P50, result.P75, result.P90, result.P99} {
+		if math.Abs(got-want[i]) > 1e-9 {
+			t.Errorf("P50-P99 = %v, want %v", [4]float64{result.P50, result.P75, result.P90, result.P99}, want)
+			break
+		}
+	}
+
+	// Connect, read and write errors are socket errors, timeouts are
+	// counted apart, and both failed on top of the non-2xx responses
+	if result.SocketErrors != 15 || result.Timeouts != 41 {
+		t.Errorf("socket errors = %d, timeouts = %d; want 15, 41", result.SocketErrors, result.Timeouts)
+	}
+	if result.TotalRequests != 28400 || result.FailedRequests != 1576 {
+		t.Errorf("requests = %d, failed = %d; want 28400, 1576", result.TotalRequests, result.FailedRequests)
+	}
+	if wantRate := 1576.0 / 28456 * 100; math.Abs(result.ErrorRate-wantRate) > 1e-9 {
+		t.Errorf("error rate = %v, want %v of every attempt", result.ErrorRate, wantRate)
+	}
+	if !reflect.DeepEqual(result.StatusCodes, map[string]int64{"200": 26880, "429": 1200, "503": 320}) {
+		t.Errorf("status codes = %v, want 200: 26880, 429: 1200, 503: 320", result.StatusCodes)
+	}
+
+	// The RPS is split between endpoints by the requests each was sent
+	if len(result.Endpoints) != 2 {
+		t.Fatalf("got %d endpoints, want 2", len(result.Endpoints))
+	}
+	for i, want := range []struct {
+		name     string
+		requests int64
+		rps      float64
+	}{{"GET /rooms", 21300, 2125.7475}, {"POST /rooms", 7100, 708.5825}} {
+		got := result.Endpoints[i]
+		if got.Name != want.name || got.Requests != want.requests || math.Abs(got.RPS-want.rps) > 1e-9 {
+			t.Errorf("endpoint %d = %s with %d requests at %v RPS, want %s with %d at %v",
+				i, got.Name, got.Requests, got.RPS, want.name, want.requests, want.rps)
+		}
+	}
+}
+
+func TestParseWRKOutputInvalid(t *testing.T) {
+	output := readTestdata(t, "wrk_output_errors.txt")
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{name: "bad socket errors", output: strings.Replace(output, "timeout 41", "timeout many", 1), want: "failed to parse socket errors"},
+		{name: "bad non-2xx count", output: strings.Replace(output, "responses: 1520", "responses: lots", 1), want: "failed to parse non-2xx responses"},
+		{name: "bad RPS", output: strings.Replace(output, "2834.33", "fast", 1), want: "failed to parse RPS"},
+		{name: "no RPS", output: strings.Replace(output, "Requests/sec:", "Req/s:", 1), want: "failed to parse RPS from output"},
+		{name: "no latency distribution", output: strings.Replace(output, "     99%", "     98%", 1), want: "failed to parse latency percentiles"},
+		// wrk couldn't connect at all
+		{name: "unable to connect", output: "unable to connect to 127.0.0.1:8080 Connection refused\n", want: "failed to parse RPS from output"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseWRKOutput(tt.output, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLatency(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{value: "123.45ms", want: 123.45},
+		{value: "1.03s", want: 1030},
+		{value: "512.00us", want: 0.512},
+		{value: "512µs", want: 0.512},
+		{value: " 2ms ", want: 2},
+		{value: "250", wantErr: true},
+		{value: "1.5m", wantErr: true},
+		{value: "fastms", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLatency(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLatency(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLatency(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
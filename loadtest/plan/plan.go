@@ -0,0 +1,461 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"cursor-roomer/loadtest/feeder"
+	"cursor-roomer/loadtest/gate"
+	"cursor-roomer/loadtest/runner"
+	"cursor-roomer/loadtest/types"
+)
+
+// Defaults used for settings a plan leaves out. They match the CLI flags.
+const (
+	DefaultClient             = "k6"
+	DefaultGoroutines         = 10
+	DefaultDuration           = 10 * time.Second
+	DefaultMaxLatencyIncrease = 15.0
+	DefaultMinRpsIncrease     = 4.0
+	DefaultArrivalRate        = 10
+	DefaultMaxDropped         = 1.0
+)
+
+// clients are the load test clients a plan can name
+var clients = map[string]bool{"k6": true, "wrk": true, "ghz": true, "native": true}
+
+// methods are the HTTP methods a target can use
+var methods = map[string]bool{"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true, "OPTIONS": true}
+
+// envReference matches a ${NAME} reference to an environment variable
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${NAME} references with the value of the environment
+// variable, empty when it is unset. Anything else, a bare $NAME included, is
+// left as it is, so tokens and cookies can hold a literal $.
+func expandEnv(s string) string {
+	return envReference.ReplaceAllStringFunc(s, func(reference string) string {
+		return os.Getenv(reference[2 : len(reference)-1])
+	})
+}
+
+// Plan declares one or more capacity tests. Everything but the targets is
+// shared by all of them; headers are merged with each target's own. Header,
+// cookie and auth values can reference environment variables as ${NAME}, so
+// credentials don't have to be checked in with the plan.
+type Plan struct {
+	Name        string              `yaml:"name" json:"name"`
+	Client      string              `yaml:"client" json:"client"`           // k6 (default), wrk, ghz or native
+	Goroutines  int                 `yaml:"goroutines" json:"goroutines"`   // VUs of the first step after the baseline
+	Model       string              `yaml:"model" json:"model"`             // closed (default) ramps VUs, open ramps the arrival rate
+	ArrivalRate int                 `yaml:"arrivalRate" json:"arrivalRate"` // Open model: requests/s of the first step after the baseline
+	MaxVUs      int                 `yaml:"maxVUs" json:"maxVUs"`           // Open model: most requests in flight before arrivals are dropped
+	Duration    string              `yaml:"duration" json:"duration"`       // Length of each iteration, e.g. "30s"
+	Warmup      string              `yaml:"warmup" json:"warmup"`           // Load applied at the start of every iteration and left out of its results, e.g. "10s"
+	Cooldown    string              `yaml:"cooldown" json:"cooldown"`       // Idle time before every iteration and trial after the baseline, e.g. "5s"
+	Trials      int                 `yaml:"trials" json:"trials"`           // Times each level is run, 1 when omitted
+	Headers     map[string]string   `yaml:"headers" json:"headers"`
+	Cookies     map[string]string   `yaml:"cookies" json:"cookies"`
+	BearerToken string              `yaml:"bearerToken" json:"bearerToken"`
+	BasicAuth   string              `yaml:"basicAuth" json:"basicAuth"` // user:password
+	Thresholds  Thresholds          `yaml:"thresholds" json:"thresholds"`
+	SLOs        []string            `yaml:"slos" json:"slos"` // Rules such as "p99<250ms"
+	Scaling     types.ScalingConfig `yaml:"scaling" json:"scaling"`
+	Debug       bool                `yaml:"debug" json:"debug"`
+	Assertions  gate.Criteria       `yaml:"assertions" json:"assertions"` // CI gate applied to every target
+	Feeder      *Feeder             `yaml:"feeder" json:"feeder"`         // Rows for the requests of every target
+	Targets     []Target            `yaml:"targets" json:"targets"`
+
+	dir string // Directory of the plan file, which relative feeder paths start from
+}
+
+// Feeder is a file of rows that fill the {{.field}} references in request
+// URLs, headers and bodies
+type Feeder struct {
+	File string `yaml:"file" json:"file"` // CSV with a header row, or JSON array of objects
+	Mode string `yaml:"mode" json:"mode"` // sequential (default), random or unique
+}
+
+// Thresholds are the relative stop conditions of the capacity search
+type Thresholds struct {
+	MaxLatencyIncrease *float64 `yaml:"maxLatencyIncrease" json:"maxLatencyIncrease"`
+	MinRpsIncrease     *float64 `yaml:"minRpsIncrease" json:"minRpsIncrease"`
+	MaxErrorRate       float64  `yaml:"maxErrorRate" json:"maxErrorRate"`
+	MaxDropped         *float64 `yaml:"maxDropped" json:"maxDropped"` // Open model: percentage of arrivals that may be dropped
+	// CorrectedLatency checks the latency threshold and SLOs against the
+	// percentiles corrected for coordinated omission
+	CorrectedLatency bool `yaml:"correctedLatency" json:"correctedLatency"`
+}
+
+// Target is one endpoint to find the capacity of
+type Target struct {
+	Name        string            `yaml:"name" json:"name"`
+	URL         string            `yaml:"url" json:"url"` // host:port for ghz
+	Method      string            `yaml:"method" json:"method"`
+	Headers     map[string]string `yaml:"headers" json:"headers"`
+	Body        string            `yaml:"body" json:"body"`
+	ContentType string            `yaml:"contentType" json:"contentType"`     // application/json when empty
+	Proto       string            `yaml:"proto" json:"proto"`                 // .proto file for ghz
+	Call        string            `yaml:"call" json:"call"`                   // gRPC method for ghz
+	TLS         bool              `yaml:"tls" json:"tls"`                     // ghz: TLS rather than plaintext, implied by a grpcs:// url
+	SkipVerify  bool              `yaml:"skipTlsVerify" json:"skipTlsVerify"` // ghz: accept any server certificate
+	// Requests make the target a weighted scenario instead of a single
+	// request. Their URLs can be paths relative to the target's url.
+	Requests []types.Request `yaml:"requests" json:"requests"`
+	// Assertions override the plan's for this target, field by field
+	Assertions gate.Criteria `yaml:"assertions" json:"assertions"`
+	// Feeder replaces the plan's feeder for this target
+	Feeder *Feeder `yaml:"feeder" json:"feeder"`
+}
+
+// Test is a validated target of a plan, ready to run
+type Test struct {
+	Name       string
+	Client     string
+	Config     types.LoadTestConfig
+	Assertions gate.Criteria
+}
+
+// Load reads a plan from a YAML or JSON file. Unknown fields are rejected so
+// typos don't silently fall back to defaults.
+func Load(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %v", err)
+	}
+
+	var plan Plan
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&plan)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&plan)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %v", path, err)
+	}
+	plan.dir = filepath.Dir(path)
+	return &plan, nil
+}
+
+// Tests validates the plan and returns a test for each target. Every problem
+// found is reported at once rather than stopping at the first.
+func (p *Plan) Tests() ([]Test, error) {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	client := p.Client
+	if client == "" {
+		client = DefaultClient
+	}
+	if !clients[client] {
+		problem("unsupported client %q (use k6, wrk, ghz or native)", client)
+	}
+
+	goroutines := p.Goroutines
+	if goroutines == 0 {
+		goroutines = DefaultGoroutines
+	}
+	if goroutines < 1 {
+		problem("goroutines must be at least 1, got %d", goroutines)
+	}
+
+	duration := DefaultDuration
+	if p.Duration != "" {
+		var err error
+		if duration, err = time.ParseDuration(p.Duration); err != nil {
+			problem("invalid duration %q: %v", p.Duration, err)
+		} else if duration <= 0 {
+			problem("duration must be positive, got %s", p.Duration)
+		}
+	}
+
+	var warmup time.Duration
+	if p.Warmup != "" {
+		var err error
+		if warmup, err = time.ParseDuration(p.Warmup); err != nil {
+			problem("invalid warmup %q: %v", p.Warmup, err)
+		} else if warmup < 0 {
+			problem("warmup must not be negative, got %s", p.Warmup)
+		}
+	}
+
+	var cooldown time.Duration
+	if p.Cooldown != "" {
+		var err error
+		if cooldown, err = time.ParseDuration(p.Cooldown); err != nil {
+			problem("invalid cooldown %q: %v", p.Cooldown, err)
+		} else if cooldown < 0 {
+			problem("cooldown must not be negative, got %s", p.Cooldown)
+		}
+	}
+	if p.Trials < 0 {
+		problem("trials must not be negative, got %d", p.Trials)
+	}
+
+	maxLatencyIncrease := DefaultMaxLatencyIncrease
+	if p.Thresholds.MaxLatencyIncrease != nil {
+		maxLatencyIncrease = *p.Thresholds.MaxLatencyIncrease
+	}
+	minRpsIncrease := DefaultMinRpsIncrease
+	if p.Thresholds.MinRpsIncrease != nil {
+		minRpsIncrease = *p.Thresholds.MinRpsIncrease
+	}
+	if p.Thresholds.MaxErrorRate < 0 || p.Thresholds.MaxErrorRate > 100 {
+		problem("maxErrorRate must be between 0 and 100, got %g", p.Thresholds.MaxErrorRate)
+	}
+
+	var slos []types.SLO
+	for _, rule := range p.SLOs {
+		slo, err := runner.ParseSLO(rule)
+		if err != nil {
+			problem("%v", err)
+			continue
+		}
+		slos = append(slos, slo)
+	}
+
+	bearerToken, basicAuth := expandEnv(p.BearerToken), expandEnv(p.BasicAuth)
+	if err := runner.ValidateAuth(bearerToken, basicAuth); err != nil {
+		problem("%v", err)
+	}
+	cookies := make(map[string]string, len(p.Cookies))
+	for name, value := range p.Cookies {
+		cookies[name] = expandEnv(value)
+		if err := runner.ValidateHeader("Cookie", cookies[name]); err != nil {
+			problem("cookie %s: %v", name, err)
+		}
+	}
+
+	model := types.LoadTestConfig{Model: p.Model}
+	if p.Model == types.ModelOpen {
+		model.ArrivalRate, model.MaxVUs, model.MaxDropped = p.ArrivalRate, p.MaxVUs, DefaultMaxDropped
+		if model.ArrivalRate == 0 {
+			model.ArrivalRate = DefaultArrivalRate
+		}
+		if p.Thresholds.MaxDropped != nil {
+			model.MaxDropped = *p.Thresholds.MaxDropped
+		}
+		if client != "k6" && client != "native" {
+			problem("%s has no arrival-rate mode; use k6 or native for the open model", client)
+		}
+	} else if p.Model == types.ModelClosed {
+		model.Model = ""
+	}
+	if err := runner.ValidateModel(model); err != nil {
+		problem("%v", err)
+	}
+
+	initial := goroutines
+	if model.Open() {
+		initial = model.ArrivalRate
+	}
+	if _, err := runner.NewScalingStrategy(p.Scaling, initial); err != nil {
+		problem("scaling: %v", err)
+	}
+
+	if len(p.Targets) == 0 {
+		problem("no targets")
+	}
+	// Targets sharing a feeder file share its rows
+	feeders := make(map[Feeder]*types.Feeder)
+	loadFeeder := func(spec Feeder) (*types.Feeder, error) {
+		if f, ok := feeders[spec]; ok {
+			return f, nil
+		}
+		path := spec.File
+		if !filepath.IsAbs(path) && p.dir != "" {
+			path = filepath.Join(p.dir, path)
+		}
+		f, err := feeder.Load(path, spec.Mode)
+		if err != nil {
+			return nil, err
+		}
+		feeders[spec] = f
+		return f, nil
+	}
+
+	names := make(map[string]bool)
+	var tests []Test
+	for i, target := range p.Targets {
+		name := target.Name
+		if name == "" {
+			name = fmt.Sprintf("target %d", i+1)
+		}
+		if names[name] {
+			problem("%s: duplicate target name", name)
+		}
+		names[name] = true
+
+		method := strings.ToUpper(target.Method)
+		if method == "" {
+			method = "GET"
+		}
+
+		var scenario []types.Request
+		if len(target.Requests) > 0 {
+			if client == "ghz" {
+				problem("%s: ghz doesn't support scenarios", name)
+			}
+			if target.Method != "" || target.Body != "" {
+				problem("%s: method and body are set per request in a scenario", name)
+			}
+			for _, request := range target.Requests {
+				requestURL, err := runner.ResolveURL(target.URL, request.URL)
+				if err != nil {
+					problem("%s: %v", name, err)
+					continue
+				}
+				request.URL = requestURL
+				if len(request.Headers) > 0 {
+					headers := make(map[string]string, len(request.Headers))
+					for key, value := range request.Headers {
+						headers[key] = expandEnv(value)
+					}
+					request.Headers = headers
+				}
+				scenario = append(scenario, request)
+			}
+			if err := runner.ValidateScenario(scenario); err != nil {
+				problem("%s: %v", name, err)
+			}
+			if target.URL == "" && len(scenario) > 0 {
+				// The history and baselines identify a run by its URL
+				target.URL = scenario[0].URL
+			}
+		} else if target.URL == "" {
+			problem("%s: url is required", name)
+		} else if client == "ghz" {
+			if target.Call == "" {
+				problem("%s: call is required for ghz", name)
+			}
+		} else {
+			if u, err := url.Parse(target.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				problem("%s: url %q is not an http(s) URL", name, target.URL)
+			}
+			if !methods[method] {
+				problem("%s: unsupported method %q", name, target.Method)
+			}
+		}
+
+		assertions := mergeAssertions(p.Assertions, target.Assertions)
+		if err := assertions.Validate(); err != nil {
+			problem("%s: assertions: %v", name, err)
+		}
+
+		headers := make(map[string]string, len(p.Headers)+len(target.Headers))
+		for key, value := range p.Headers {
+			headers[key] = expandEnv(value)
+		}
+		for key, value := range target.Headers {
+			headers[key] = expandEnv(value)
+		}
+		for key, value := range headers {
+			if err := runner.ValidateHeader(key, value); err != nil {
+				problem("%s: %v", name, err)
+			}
+		}
+
+		var requestFeeder *types.Feeder
+		spec := p.Feeder
+		if target.Feeder != nil {
+			spec = target.Feeder
+		}
+		if spec != nil {
+			if client == "ghz" {
+				problem("%s: ghz doesn't support feeders", name)
+			} else if spec.File == "" {
+				problem("%s: feeder file is required", name)
+			} else if f, err := loadFeeder(*spec); err != nil {
+				problem("%s: %v", name, err)
+			} else {
+				requestFeeder = f
+			}
+		}
+
+		test := Test{
+			Name:   name,
+			Client: client,
+			Config: types.LoadTestConfig{
+				URL:                target.URL,
+				Goroutines:         goroutines,
+				Duration:           duration,
+				Warmup:             warmup,
+				Cooldown:           cooldown,
+				Trials:             p.Trials,
+				MaxLatencyIncrease: maxLatencyIncrease,
+				MinRpsIncrease:     minRpsIncrease,
+				MaxErrorRate:       p.Thresholds.MaxErrorRate,
+				CorrectedLatency:   p.Thresholds.CorrectedLatency,
+				Debug:              p.Debug,
+				Method:             method,
+				Body:               target.Body,
+				Headers:            headers,
+				ContentType:        target.ContentType,
+				BearerToken:        bearerToken,
+				BasicAuth:          basicAuth,
+				Cookies:            cookies,
+				Proto:              target.Proto,
+				Call:               target.Call,
+				TLS:                target.TLS,
+				SkipTLSVerify:      target.SkipVerify,
+				Scenario:           scenario,
+				Feeder:             requestFeeder,
+				Scaling:            p.Scaling,
+				SLOs:               slos,
+				Model:              model.Model,
+				ArrivalRate:        model.ArrivalRate,
+				MaxVUs:             model.MaxVUs,
+				MaxDropped:         model.MaxDropped,
+			},
+			Assertions: assertions,
+		}
+		// ghz fills {{.field}} references in bodies with its own call data
+		if client != "ghz" && (spec == nil || requestFeeder != nil) {
+			if err := runner.ValidateTemplates(test.Config); err != nil {
+				problem("%s: %v", name, err)
+			}
+		}
+		tests = append(tests, test)
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid plan:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return tests, nil
+}
+
+// mergeAssertions returns the plan's assertions with those the target sets
+// taking precedence
+func mergeAssertions(shared, target gate.Criteria) gate.Criteria {
+	if target.MinCapacity != 0 {
+		shared.MinCapacity = target.MinCapacity
+	}
+	if target.MinRPS != 0 {
+		shared.MinRPS = target.MinRPS
+	}
+	if target.MaxRegression != nil {
+		shared.MaxRegression = target.MaxRegression
+	}
+	if target.Baseline != "" {
+		shared.Baseline = target.Baseline
+	}
+	if target.RequireBaseline {
+		shared.RequireBaseline = true
+	}
+	return shared
+}
@@ -0,0 +1,194 @@
+package plan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cursor-roomer/loadtest/types"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("PLAN_TEST_TOKEN", "s3cret")
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "${PLAN_TEST_TOKEN}", want: "s3cret"},
+		{value: "Bearer ${PLAN_TEST_TOKEN}!", want: "Bearer s3cret!"},
+		{value: "${PLAN_TEST_UNSET}", want: ""},
+		// Only ${NAME} is a reference, so a literal $ survives
+		{value: "pa$$word", want: "pa$$word"},
+		{value: "$PLAN_TEST_TOKEN", want: "$PLAN_TEST_TOKEN"},
+		{value: "price$5", want: "price$5"},
+		{value: "${not a name}", want: "${not a name}"},
+		{value: "${PLAN_TEST_TOKEN", want: "${PLAN_TEST_TOKEN"},
+		{value: "$${PLAN_TEST_TOKEN}", want: "$s3cret"},
+	}
+	for _, tt := range tests {
+		if got := expandEnv(tt.value); got != tt.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLoadValidPlan(t *testing.T) {
+	t.Setenv("PLAN_TEST_TOKEN", "s3cret")
+	for _, file := range []string{"testdata/valid.yaml", "testdata/valid.json"} {
+		t.Run(file, func(t *testing.T) {
+			p, err := Load(file)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			tests, err := p.Tests()
+			if err != nil {
+				t.Fatalf("Tests: %v", err)
+			}
+			if len(tests) != 3 {
+				t.Fatalf("got %d tests, want 3", len(tests))
+			}
+
+			list, create, browse := tests[0], tests[1], tests[2]
+			if list.Name != "list-products" || list.Client != "native" {
+				t.Errorf("first test = %s with %s, want list-products with native", list.Name, list.Client)
+			}
+			config := list.Config
+			if config.Goroutines != 5 || config.Duration != 30*time.Second || config.Warmup != 15*time.Second ||
+				config.Cooldown != 5*time.Second || config.Trials != 3 {
+				t.Errorf("goroutines %d, duration %v, warmup %v, cooldown %v, trials %d; want 5, 30s, 15s, 5s, 3",
+					config.Goroutines, config.Duration, config.Warmup, config.Cooldown, config.Trials)
+			}
+			// An explicit zero threshold is kept rather than replaced by the default
+			if config.MaxLatencyIncrease != 50 || config.MinRpsIncrease != 0 || config.MaxErrorRate != 1 || !config.CorrectedLatency {
+				t.Errorf("thresholds = %v, %v, %v, %v; want 50, 0, 1, true",
+					config.MaxLatencyIncrease, config.MinRpsIncrease, config.MaxErrorRate, config.CorrectedLatency)
+			}
+			if config.Method != "GET" {
+				t.Errorf("method = %q, want GET by default", config.Method)
+			}
+			wantSLOs := []types.SLO{{Metric: "p99", Operator: "<", Threshold: 250}, {Metric: "error_rate", Operator: "<", Threshold: 0.5}}
+			if !reflect.DeepEqual(config.SLOs, wantSLOs) {
+				t.Errorf("SLOs = %+v, want %+v", config.SLOs, wantSLOs)
+			}
+			if config.Scaling.Strategy != "geometric" || config.Scaling.Factor != 2 {
+				t.Errorf("scaling = %+v, want geometric by 2", config.Scaling)
+			}
+
+			// Only ${NAME} references are expanded
+			if config.BearerToken != "s3cret" {
+				t.Errorf("bearer token = %q, want it read from the environment", config.BearerToken)
+			}
+			if got := config.Cookies["session"]; got != "abc$def" {
+				t.Errorf("cookie = %q, want %q", got, "abc$def")
+			}
+			if got := config.Headers["X-Literal"]; got != "price$5 and $HOME" {
+				t.Errorf("header = %q, want it sent as written", got)
+			}
+
+			// The target's headers are merged over the plan's, and its
+			// assertions over the plan's field by field
+			if create.Config.Method != "POST" || create.Config.Headers["X-Load-Test"] != "create-order" || create.Config.Headers["X-Literal"] == "" {
+				t.Errorf("create-order sends %s with headers %v", create.Config.Method, create.Config.Headers)
+			}
+			if list.Assertions.MinCapacity != 20 || create.Assertions.MinCapacity != 10 {
+				t.Errorf("min capacity = %d and %d, want 20 and 10", list.Assertions.MinCapacity, create.Assertions.MinCapacity)
+			}
+			if create.Assertions.MaxRegression == nil || *create.Assertions.MaxRegression != 0 {
+				t.Errorf("max regression = %v, want the plan's 0", create.Assertions.MaxRegression)
+			}
+
+			// The feeder path is relative to the plan file
+			if config.Feeder == nil || !reflect.DeepEqual(config.Feeder.Rows, [][]string{{"alice"}, {"bob"}}) {
+				t.Errorf("feeder = %+v, want the rows of users.csv", config.Feeder)
+			}
+			if create.Config.Feeder != config.Feeder {
+				t.Error("targets sharing a feeder file should share its rows")
+			}
+
+			// Scenario URLs resolve against the target's
+			wantScenario := []types.Request{
+				{Name: "home", Method: "GET", URL: "https://staging.example.com/", Weight: 3},
+				{Name: "search", Method: "GET", URL: "https://staging.example.com/search?q=shoes",
+					Headers: map[string]string{"Authorization": "Bearer s3cret"}, Weight: 1},
+			}
+			if !reflect.DeepEqual(browse.Config.Scenario, wantScenario) {
+				t.Errorf("scenario = %+v, want %+v", browse.Config.Scenario, wantScenario)
+			}
+		})
+	}
+}
+
+func TestLoadOpenModelPlan(t *testing.T) {
+	p, err := Load("testdata/open.yaml")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	tests, err := p.Tests()
+	if err != nil {
+		t.Fatalf("Tests: %v", err)
+	}
+	config := tests[0].Config
+	if !config.Open() || config.ArrivalRate != 50 || config.MaxVUs != 200 || config.MaxDropped != 2 {
+		t.Errorf("model %q at %d requests/s, %d VUs, %v%% dropped; want open, 50, 200, 2",
+			config.Model, config.ArrivalRate, config.MaxVUs, config.MaxDropped)
+	}
+	if tests[0].Name != "target 1" {
+		t.Errorf("name = %q, want targets numbered when unnamed", tests[0].Name)
+	}
+}
+
+func TestLoadUnknownFields(t *testing.T) {
+	for _, file := range []string{"testdata/unknown_field.yaml", "testdata/unknown_field.json"} {
+		t.Run(file, func(t *testing.T) {
+			_, err := Load(file)
+			if err == nil || !strings.Contains(err.Error(), "failed to parse plan") {
+				t.Errorf("error = %v, want the unknown field rejected", err)
+			}
+		})
+	}
+	if _, err := Load("testdata/missing.yaml"); err == nil || !strings.Contains(err.Error(), "failed to read plan") {
+		t.Errorf("error = %v, want the missing file reported", err)
+	}
+}
+
+func TestInvalidPlan(t *testing.T) {
+	p, err := Load("testdata/invalid.yaml")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	_, err = p.Tests()
+	if err == nil {
+		t.Fatal("expected the plan to be rejected")
+	}
+	// Every problem is reported at once
+	for _, want := range []string{
+		`unsupported client "curl"`,
+		"goroutines must be at least 1, got -1",
+		`invalid duration "soon"`,
+		"warmup must not be negative, got -5s",
+		"trials must not be negative, got -2",
+		"maxErrorRate must be between 0 and 100, got 150",
+		"p99<<250ms",
+		"bearer",
+		"curl has no arrival-rate mode",
+		"scaling:",
+		"api: duplicate target name",
+		`api: url "ftp://example.com/" is not an http(s) URL`,
+		`api: unsupported method "FETCH"`,
+		"api: assertions:",
+		"missing.csv",
+		"no-url: url is required",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't mention %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestPlanWithoutTargets(t *testing.T) {
+	_, err := (&Plan{}).Tests()
+	if err == nil || !strings.Contains(err.Error(), "no targets") {
+		t.Errorf("error = %v, want a plan without targets rejected", err)
+	}
+}
@@ -0,0 +1,47 @@
+package runner
+
+import (
+	"fmt"
+
+	"cursor-roomer/loadtest/feeder"
+	"cursor-roomer/loadtest/types"
+)
+
+// ValidateTemplates checks that every {{.field}} reference in the requests
+// names a field of the feeder, and that the headers stay valid whatever row
+// fills them
+func ValidateTemplates(config types.LoadTestConfig) error {
+	requests, err := feeder.CompileRequests(config)
+	if err != nil {
+		return err
+	}
+	if config.Feeder == nil {
+		return nil
+	}
+	switch config.Feeder.Mode {
+	case "", feeder.ModeSequential, feeder.ModeRandom, feeder.ModeUnique:
+	default:
+		return fmt.Errorf("unsupported feeder mode %q (use sequential, random or unique)", config.Feeder.Mode)
+	}
+	if len(config.Feeder.Rows) == 0 {
+		return fmt.Errorf("feeder has no rows")
+	}
+	for i, row := range config.Feeder.Rows {
+		if len(row) != len(config.Feeder.Fields) {
+			return fmt.Errorf("feeder row %d has %d values for %d fields", i+1, len(row), len(config.Feeder.Fields))
+		}
+	}
+	for _, request := range requests {
+		for name, header := range request.Headers {
+			if header.Static() {
+				continue
+			}
+			for i, row := range config.Feeder.Rows {
+				if err := ValidateHeader(name, header.Execute(row, nil)); err != nil {
+					return fmt.Errorf("%s: feeder row %d: %v", request.Name, i+1, err)
+				}
+			}
+		}
+	}
+	return nil
+}
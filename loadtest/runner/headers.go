@@ -0,0 +1,82 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseHeaders parses "Name: value" lines into a header map. Blank lines are
+// skipped, so the text of a form field can be passed line by line.
+func ParseHeaders(lines []string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q, expected \"Name: value\"", line)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if err := ValidateHeader(name, value); err != nil {
+			return nil, err
+		}
+		headers[name] = value
+	}
+	return headers, nil
+}
+
+// ValidateHeader checks that a header can be sent as is. Control characters
+// are rejected because a line break would let a value inject its own headers
+// into the request.
+func ValidateHeader(name, value string) error {
+	if name == "" {
+		return fmt.Errorf("header name is empty")
+	}
+	for _, ch := range name {
+		if ch <= ' ' || ch >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", ch) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	for _, ch := range value {
+		if (ch < ' ' && ch != '\t') || ch == 0x7f {
+			return fmt.Errorf("header %s contains a control character", name)
+		}
+	}
+	return nil
+}
+
+// ParseCookies parses cookies in Cookie header form, e.g. "session=abc; theme=dark"
+func ParseCookies(s string) (map[string]string, error) {
+	cookies := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid cookie %q, expected name=value", part)
+		}
+		value = strings.TrimSpace(value)
+		if err := ValidateHeader("Cookie", value); err != nil {
+			return nil, err
+		}
+		cookies[name] = value
+	}
+	return cookies, nil
+}
+
+// ValidateAuth checks the bearer token and basic auth settings, which both
+// set the Authorization header and so can't be combined
+func ValidateAuth(bearerToken, basicAuth string) error {
+	if bearerToken != "" && basicAuth != "" {
+		return fmt.Errorf("a bearer token and basic auth can't be used together")
+	}
+	if basicAuth != "" && !strings.Contains(basicAuth, ":") {
+		return fmt.Errorf("basic auth must be given as user:password")
+	}
+	return ValidateHeader("Authorization", bearerToken)
+}
@@ -0,0 +1,29 @@
+package runner
+
+import (
+	"fmt"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// ValidateModel checks the load model settings: the open model needs an
+// arrival rate to start the search from
+func ValidateModel(config types.LoadTestConfig) error {
+	switch config.Model {
+	case "", types.ModelClosed:
+		return nil
+	case types.ModelOpen:
+	default:
+		return fmt.Errorf("unsupported model %q (use closed or open)", config.Model)
+	}
+	if config.ArrivalRate < 1 {
+		return fmt.Errorf("the open model needs an arrival rate of at least 1 request/s")
+	}
+	if config.MaxVUs < 0 {
+		return fmt.Errorf("max VUs must not be negative")
+	}
+	if config.MaxDropped < 0 || config.MaxDropped > 100 {
+		return fmt.Errorf("max dropped must be between 0 and 100 percent")
+	}
+	return nil
+}
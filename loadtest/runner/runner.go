@@ -1,87 +1,320 @@
 package runner
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"cursor-roomer/loadtest/client"
+	"cursor-roomer/loadtest/feeder"
 	"cursor-roomer/loadtest/parser"
 	"cursor-roomer/loadtest/types"
+	"cursor-roomer/loadtest/usl"
 )
 
 // TestRunner handles the load test execution
 type TestRunner struct {
-	config     types.LoadTestConfig
-	output     types.OutputHandler
-	client     types.LoadTestClient
-	initialP90 float64
-	lastRPS    float64
+	config        types.LoadTestConfig
+	output        types.OutputHandler
+	client        types.LoadTestClient
+	strategy      ScalingStrategy
+	initialP90    float64
+	initialSpread *types.Interval // P90 interval of the baseline over repeated trials
+	lastRPS       float64
+	lastSpread    *types.Interval // RPS interval of lastLevel over repeated trials
+	lastLevel     int             // VU count, or arrival rate in the open model, lastRPS was measured at
+	refining      bool
+	trials        []*types.LoadTestResult // Trials of the level just run, nil for a single run
 }
 
-func NewTestRunner(config types.LoadTestConfig, output types.OutputHandler, client types.LoadTestClient) *TestRunner {
+// breachError reports that an iteration crossed one of the stop thresholds,
+// as opposed to the test itself failing to run
+type breachError struct {
+	reason  types.StopReason
+	message string
+	slo     *types.SLO // Set when an absolute SLO rule tripped
+}
+
+func (e *breachError) Error() string {
+	return e.message
+}
+
+func NewTestRunner(config types.LoadTestConfig, output types.OutputHandler, client types.LoadTestClient, strategy ScalingStrategy) *TestRunner {
 	return &TestRunner{
-		config: config,
-		output: output,
-		client: client,
+		config:   config,
+		output:   output,
+		client:   client,
+		strategy: strategy,
 	}
 }
 
 func (r *TestRunner) printResults(result *types.LoadTestResult, prefix string) {
 	r.output.WriteLine(fmt.Sprintf("%s results:", prefix))
-	r.output.WriteLine(fmt.Sprintf("RPS: %.2f", result.RPS))
+	if result.RPSInterval != nil {
+		r.output.WriteLine(fmt.Sprintf("RPS: %.2f %s", result.RPS, describeInterval(result.RPSInterval, result.Trials)))
+	} else {
+		r.output.WriteLine(fmt.Sprintf("RPS: %.2f", result.RPS))
+	}
 	r.output.WriteLine(fmt.Sprintf("P50: %.2fms", result.P50))
 	r.output.WriteLine(fmt.Sprintf("P75: %.2fms", result.P75))
-	r.output.WriteLine(fmt.Sprintf("P90: %.2fms", result.P90))
+	if result.P90Interval != nil && !r.config.CorrectedLatency {
+		r.output.WriteLine(fmt.Sprintf("P90: %.2fms %s", result.P90, describeInterval(result.P90Interval, result.Trials)))
+	} else {
+		r.output.WriteLine(fmt.Sprintf("P90: %.2fms", result.P90))
+	}
 	r.output.WriteLine(fmt.Sprintf("P99: %.2fms", result.P99))
+	if result.Corrected() {
+		r.output.WriteLine(fmt.Sprintf("Corrected for coordinated omission: P50 %.2fms, P75 %.2fms, P90 %.2fms, P99 %.2fms",
+			result.CorrectedP50, result.CorrectedP75, result.CorrectedP90, result.CorrectedP99))
+		if result.P90Interval != nil && r.config.CorrectedLatency {
+			r.output.WriteLine(fmt.Sprintf("Corrected P90: %.2fms %s", result.CorrectedP90, describeInterval(result.P90Interval, result.Trials)))
+		}
+	}
+	if result.TotalRequests > 0 {
+		r.output.WriteLine(fmt.Sprintf("Requests: %d, failed: %d (error rate: %.2f%%)",
+			result.TotalRequests, result.FailedRequests, result.ErrorRate))
+	}
+	if result.Timeouts+result.SocketErrors > 0 {
+		r.output.WriteLine(fmt.Sprintf("Timeouts: %d, socket errors: %d", result.Timeouts, result.SocketErrors))
+	}
+	if result.ClampedLatencies > 0 {
+		r.output.WriteLine(fmt.Sprintf("Latencies past the histogram range: %d (recorded at its maximum, so the tail percentiles are a lower bound)",
+			result.ClampedLatencies))
+	}
+	if result.Dropped+result.Late > 0 {
+		r.output.WriteLine(fmt.Sprintf("Dropped: %d (%.2f%% of arrivals), late: %d", result.Dropped, result.DroppedRate(), result.Late))
+	}
+	if len(result.StatusCodes) > 0 {
+		codes := make([]string, 0, len(result.StatusCodes))
+		for code := range result.StatusCodes {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for i, code := range codes {
+			codes[i] = fmt.Sprintf("%s: %d", code, result.StatusCodes[code])
+		}
+		r.output.WriteLine(fmt.Sprintf("Status codes: %s", strings.Join(codes, ", ")))
+	}
+	if result.ChecksPassed+result.ChecksFailed > 0 {
+		r.output.WriteLine(fmt.Sprintf("Checks: %d passed, %d failed", result.ChecksPassed, result.ChecksFailed))
+	}
+	for _, endpoint := range result.Endpoints {
+		if endpoint.P50 == 0 && endpoint.P99 == 0 {
+			r.output.WriteLine(fmt.Sprintf("  %s: %d requests, %.2f RPS", endpoint.Name, endpoint.Requests, endpoint.RPS))
+			continue
+		}
+		r.output.WriteLine(fmt.Sprintf("  %s: %d requests, %.2f RPS, P50 %.2fms, P90 %.2fms, P99 %.2fms, error rate %.2f%%",
+			endpoint.Name, endpoint.Requests, endpoint.RPS, endpoint.P50, endpoint.P90, endpoint.P99, endpoint.ErrorRate))
+	}
 }
 
-func (r *TestRunner) runInitialTest() (*types.LoadTestResult, error) {
-	// Force 1 virtual user for initial test
-	r.config.Goroutines = 1
+// emit sends a structured event to the output handler if it accepts them
+func (r *TestRunner) emit(event types.Event) {
+	if handler, ok := r.output.(types.EventHandler); ok {
+		handler.HandleEvent(event)
+	}
+}
+
+// emitBreach reports a threshold breach at the given level
+func (r *TestRunner) emitBreach(level int, breach *breachError) {
+	event := r.event(types.EventThresholdBreached, level)
+	event.Baseline = level == 1 && r.lastLevel == 0
+	event.Reason = breach.reason
+	event.Message = breach.message
+	event.SLO = breach.slo
+	r.emit(event)
+}
+
+// event starts an event about a test at the given level
+func (r *TestRunner) event(eventType types.EventType, level int) types.Event {
+	event := types.Event{Type: eventType}
+	if r.config.Open() {
+		event.Rate = level
+	} else {
+		event.VUs = level
+	}
+	return event
+}
+
+// iteration records the result of a test at the given level
+func (r *TestRunner) iteration(level int, result *types.LoadTestResult) types.IterationResult {
+	if r.config.Open() {
+		return types.IterationResult{Rate: level, Result: result}
+	}
+	return types.IterationResult{VUs: level, Result: result}
+}
+
+// level returns the load the next test runs at: the VU count in the closed
+// model, the arrival rate in the open one
+func (r *TestRunner) level() int {
+	if r.config.Open() {
+		return r.config.ArrivalRate
+	}
+	return r.config.Goroutines
+}
+
+func (r *TestRunner) setLevel(level int) {
+	if r.config.Open() {
+		r.config.ArrivalRate = level
+	} else {
+		r.config.Goroutines = level
+	}
+}
+
+// describe names a level, e.g. "10 virtual users" or "50 requests/s"
+func (r *TestRunner) describe(level int) string {
+	switch {
+	case r.config.Open():
+		return fmt.Sprintf("%d requests/s", level)
+	case level == 1:
+		return "1 virtual user"
+	default:
+		return fmt.Sprintf("%d virtual users", level)
+	}
+}
 
-	r.output.WriteLine(fmt.Sprintf("Running initial test with 1 virtual user for %v...", r.config.Duration))
-	r.output.WriteLine(fmt.Sprintf("Will stop if P90 latency increases by more than %.1f%% or RPS increase is less than %.1f%%\n",
-		r.config.MaxLatencyIncrease, r.config.MinRpsIncrease))
+// runTest runs the client once with the current config and returns its parsed results
+func (r *TestRunner) runTest() (*types.LoadTestResult, error) {
+	if err := feeder.CheckVUs(r.config.Feeder, r.config.VUs()); err != nil {
+		return nil, err
+	}
+
+	// In-process clients hand back their measurements directly
+	if resultClient, ok := r.client.(types.ResultClient); ok {
+		return resultClient.RunTestResult(r.config)
+	}
 
 	output, err := r.client.RunTest(r.config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to run initial test: %v", err)
+		return nil, err
+	}
+
+	// Scenarios break the results down per request, in scenario order
+	var endpoints []string
+	if len(r.config.Scenario) > 0 {
+		for _, request := range r.config.Requests() {
+			endpoints = append(endpoints, request.Name)
+		}
 	}
 
-	var result *types.LoadTestResult
 	switch r.client.Name() {
 	case "k6":
-		result, err = parser.ParseK6Output(output)
+		// The generated script tags what it measures after a warmup apart
+		var measured time.Duration
+		if r.config.Warmup > 0 {
+			measured = r.config.Duration
+		}
+		result, err := parser.ParseK6Output(output, endpoints, measured)
+		// The constant-arrival-rate executor starts every iteration on
+		// schedule or drops it, so its latencies already start from the
+		// intended send time
+		if err == nil && r.config.Open() {
+			result.CorrectedP50, result.CorrectedP75, result.CorrectedP90, result.CorrectedP99 = result.P50, result.P75, result.P90, result.P99
+		}
+		return result, err
 	case "wrk":
-		result, err = parser.ParseWRKOutput(output)
+		return parser.ParseWRKOutput(output, endpoints)
 	case "ghz":
-		return nil, fmt.Errorf("ghz client not implemented yet")
+		return parser.ParseGHZOutput(output)
 	default:
 		return nil, fmt.Errorf("unsupported client type: %s", r.client.Name())
 	}
+}
+
+// cooldown lets the system under test settle after an iteration, so queues
+// and connections left over from it don't skew the next one. It returns early
+// when the test is cancelled.
+func (r *TestRunner) cooldown() {
+	if r.config.Cooldown <= 0 {
+		return
+	}
+	r.output.WriteLine(fmt.Sprintf("Cooling down for %v...", r.config.Cooldown))
+	select {
+	case <-time.After(r.config.Cooldown):
+	case <-r.config.Ctx.Done():
+	}
+}
+
+func (r *TestRunner) runInitialTest() (*types.LoadTestResult, error) {
+	// Force 1 virtual user, or 1 request/s, for initial test
+	r.setLevel(1)
 
+	r.output.WriteLine(fmt.Sprintf("Running initial test with %s for %v...", r.describe(1), r.config.Duration))
+	if r.config.Warmup > 0 {
+		r.output.WriteLine(fmt.Sprintf("Every iteration starts with a %v warmup that is left out of its results", r.config.Warmup))
+	}
+	if r.config.Cooldown > 0 {
+		r.output.WriteLine(fmt.Sprintf("Will cool down for %v between iterations", r.config.Cooldown))
+	}
+	if r.config.Trials > 1 {
+		r.output.WriteLine(fmt.Sprintf("Every level runs %d trials; thresholds and SLOs only stop the search when the whole 95%% confidence interval is past them",
+			r.config.Trials))
+	}
+	p90 := "P90"
+	if r.config.CorrectedLatency {
+		p90 = "corrected P90"
+	}
+	r.output.WriteLine(fmt.Sprintf("Will stop if %s latency increases by more than %.1f%% or RPS increase is less than %.1f%%",
+		p90, r.config.MaxLatencyIncrease, r.config.MinRpsIncrease))
+	if r.config.MaxErrorRate > 0 {
+		r.output.WriteLine(fmt.Sprintf("Will also stop if more than %.2f%% of requests fail", r.config.MaxErrorRate))
+	}
+	if r.config.Open() && r.config.MaxDropped > 0 {
+		r.output.WriteLine(fmt.Sprintf("Will also stop if more than %.2f%% of arrivals are dropped", r.config.MaxDropped))
+	}
+	if len(r.config.SLOs) > 0 {
+		rules := make([]string, len(r.config.SLOs))
+		for i, slo := range r.config.SLOs {
+			rules[i] = slo.String()
+		}
+		r.output.WriteLine(fmt.Sprintf("Will also stop if any SLO is violated: %s", strings.Join(rules, ", ")))
+	}
+	r.output.WriteLine("")
+
+	started := r.event(types.EventIterationStarted, 1)
+	started.Baseline = true
+	r.emit(started)
+	result, err := r.runTrials()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to run initial test: %v", err)
 	}
 
 	r.printResults(result, "Initial")
-	r.initialP90 = result.P90
-	r.lastRPS = result.RPS
+	finished := r.event(types.EventIterationResult, 1)
+	finished.Baseline = true
+	finished.Result = result
+	r.emit(finished)
 
-	return result, nil
-}
+	if r.config.CorrectedLatency && !result.Corrected() {
+		return nil, fmt.Errorf("the %s client didn't measure latencies corrected for coordinated omission; only the native client and k6's open model do",
+			r.client.Name())
+	}
 
-func (r *TestRunner) calculateNextThreads(currentThreads int, originalThreads int) int {
-	if currentThreads == 1 && originalThreads > 1 {
-		// Second test: use configured goroutines
-		return originalThreads
+	// A baseline that already violates an SLO leaves no capacity to find
+	if breach := checkSLOs(result, r.trials, r.config.SLOs, r.config.CorrectedLatency); breach != nil {
+		return result, breach
+	}
+	if breach := r.checkErrorRate(result); breach != nil {
+		return result, breach
+	}
+	if breach := r.checkDropped(result); breach != nil {
+		return result, breach
 	}
-	// Subsequent tests: increase by 50% with round up
-	return int(float64(currentThreads)*1.5 + 0.5)
+	r.initialP90 = result.Percentile(90, r.config.CorrectedLatency)
+	r.initialSpread = result.P90Interval
+	r.lastRPS = result.RPS
+	r.lastSpread = result.RPSInterval
+	r.lastLevel = 1
+
+	return result, nil
 }
 
-func (r *TestRunner) runIteration(currentThreads int) (*types.LoadTestResult, error) {
-	r.config.Goroutines = currentThreads
-	output, err := r.client.RunTest(r.config)
+func (r *TestRunner) runIteration(level int) (*types.LoadTestResult, error) {
+	r.setLevel(level)
+	r.emit(r.event(types.EventIterationStarted, level))
+	result, err := r.runTrials()
 	if err != nil {
 		if r.config.Ctx.Err() != nil {
 			return nil, fmt.Errorf("test cancelled")
@@ -89,46 +322,198 @@ func (r *TestRunner) runIteration(currentThreads int) (*types.LoadTestResult, er
 		return nil, fmt.Errorf("failed to run test: %v", err)
 	}
 
-	var result *types.LoadTestResult
-	switch r.client.Name() {
-	case "k6":
-		result, err = parser.ParseK6Output(output)
-	case "wrk":
-		result, err = parser.ParseWRKOutput(output)
-	case "ghz":
-		return nil, fmt.Errorf("ghz client not implemented yet")
-	default:
-		return nil, fmt.Errorf("unsupported client type: %s", r.client.Name())
+	r.printResults(result, "Current")
+	finished := r.event(types.EventIterationResult, level)
+	finished.Result = result
+	r.emit(finished)
+
+	latencyIncrease := (result.Percentile(90, r.config.CorrectedLatency) - r.initialP90) / r.initialP90 * 100
+	p90 := "P90"
+	if r.config.CorrectedLatency {
+		p90 = "Corrected P90"
 	}
+	rpsIncrease := (result.RPS - r.lastRPS) / r.lastRPS * 100
 
-	if err != nil {
-		return nil, err
+	minRpsIncrease := r.config.MinRpsIncrease
+	if scaler, ok := r.strategy.(thresholdScaler); ok {
+		minRpsIncrease = scaler.scaleRpsThreshold(minRpsIncrease, r.lastLevel, level)
 	}
 
-	r.printResults(result, "Current")
+	// With repeated trials a noisy level only breaches when the intervals of
+	// this level and the one it is compared with don't overlap enough to
+	// pass: the increases are taken between their favourable ends
+	latencyBound, rpsBound := latencyIncrease, rpsIncrease
+	if result.P90Interval != nil {
+		var latencyHigh float64
+		latencyBound, latencyHigh = intervalChange(r.initialP90, r.initialSpread, result.P90Interval)
+		r.output.WriteLine(fmt.Sprintf("%s latency increase: %.1f%% (at least %.1f%%, at most %.1f%% at 95%% confidence)", p90, latencyIncrease,
+			latencyBound, latencyHigh))
+	} else {
+		r.output.WriteLine(fmt.Sprintf("%s latency increase: %.1f%%", p90, latencyIncrease))
+	}
+	if result.RPSInterval != nil {
+		var rpsLow float64
+		rpsLow, rpsBound = intervalChange(r.lastRPS, r.lastSpread, result.RPSInterval)
+		r.output.WriteLine(fmt.Sprintf("RPS increase: %.1f%% (at least %.1f%%, at most %.1f%% at 95%% confidence)\n", rpsIncrease,
+			rpsLow, rpsBound))
+	} else {
+		r.output.WriteLine(fmt.Sprintf("RPS increase: %.1f%%\n", rpsIncrease))
+	}
 
-	latencyIncrease := (result.P90 - r.initialP90) / r.initialP90 * 100
-	rpsIncrease := (result.RPS - r.lastRPS) / r.lastRPS * 100
+	if breach := checkSLOs(result, r.trials, r.config.SLOs, r.config.CorrectedLatency); breach != nil {
+		return result, breach
+	}
 
-	r.output.WriteLine(fmt.Sprintf("P90 Latency increase: %.1f%%", latencyIncrease))
-	r.output.WriteLine(fmt.Sprintf("RPS increase: %.1f%%\n", rpsIncrease))
+	if breach := r.checkErrorRate(result); breach != nil {
+		return result, breach
+	}
 
-	if latencyIncrease > r.config.MaxLatencyIncrease {
-		return nil, fmt.Errorf("stopping: P90 latency increased by %.1f%% (threshold: %.1f%%)",
-			latencyIncrease, r.config.MaxLatencyIncrease)
+	if breach := r.checkDropped(result); breach != nil {
+		return result, breach
 	}
 
-	if rpsIncrease < r.config.MinRpsIncrease {
-		return nil, fmt.Errorf("stopping: RPS increased by only %.1f%% (threshold: %.1f%%)",
-			rpsIncrease, r.config.MinRpsIncrease)
+	if latencyBound > r.config.MaxLatencyIncrease {
+		message := fmt.Sprintf("%s latency increased by %.1f%% (threshold: %.1f%%)",
+			p90, latencyIncrease, r.config.MaxLatencyIncrease)
+		if result.P90Interval != nil {
+			message = fmt.Sprintf("%s latency increased by at least %.1f%% at 95%% confidence (threshold: %.1f%%)",
+				p90, latencyBound, r.config.MaxLatencyIncrease)
+		}
+		return result, &breachError{reason: types.StopLatencyThreshold, message: message}
+	}
+
+	if rpsBound < minRpsIncrease {
+		message := fmt.Sprintf("RPS increased by only %.1f%% (threshold: %.1f%%)",
+			rpsIncrease, minRpsIncrease)
+		if result.RPSInterval != nil {
+			message = fmt.Sprintf("RPS increased by at most %.1f%% at 95%% confidence (threshold: %.1f%%)",
+				rpsBound, minRpsIncrease)
+		}
+		return result, &breachError{reason: types.StopRpsThreshold, message: message}
 	}
 
 	r.lastRPS = result.RPS
+	r.lastSpread = result.RPSInterval
+	r.lastLevel = level
 	return result, nil
 }
 
-// RunLoadTest executes the load test with the given configuration
-func RunLoadTest(config types.LoadTestConfig, output types.OutputHandler, clientType string) error {
+// checkErrorRate returns a breach when more requests failed than MaxErrorRate
+// allows. With repeated trials the whole confidence interval has to be past it.
+func (r *TestRunner) checkErrorRate(result *types.LoadTestResult) *breachError {
+	if r.config.MaxErrorRate <= 0 {
+		return nil
+	}
+	interval := trialInterval(r.trials, func(trial *types.LoadTestResult) float64 { return trial.ErrorRate })
+	if interval == nil {
+		if result.ErrorRate <= r.config.MaxErrorRate {
+			return nil
+		}
+		return &breachError{
+			reason: types.StopErrorRate,
+			message: fmt.Sprintf("error rate is %.2f%% (threshold: %.2f%%)",
+				result.ErrorRate, r.config.MaxErrorRate),
+		}
+	}
+	if interval.Low <= r.config.MaxErrorRate {
+		return nil
+	}
+	return &breachError{
+		reason: types.StopErrorRate,
+		message: fmt.Sprintf("error rate is at least %.2f%% at 95%% confidence (threshold: %.2f%%)",
+			interval.Low, r.config.MaxErrorRate),
+	}
+}
+
+// checkDropped returns a breach when more arrivals were dropped than
+// MaxDropped allows. With repeated trials the whole confidence interval has
+// to be past it.
+func (r *TestRunner) checkDropped(result *types.LoadTestResult) *breachError {
+	if r.config.MaxDropped <= 0 {
+		return nil
+	}
+	interval := trialInterval(r.trials, func(trial *types.LoadTestResult) float64 { return trial.DroppedRate() })
+	if interval == nil {
+		if result.DroppedRate() <= r.config.MaxDropped {
+			return nil
+		}
+		return &breachError{
+			reason: types.StopDropped,
+			message: fmt.Sprintf("%.2f%% of arrivals were dropped (threshold: %.2f%%)",
+				result.DroppedRate(), r.config.MaxDropped),
+		}
+	}
+	if interval.Low <= r.config.MaxDropped {
+		return nil
+	}
+	return &breachError{
+		reason: types.StopDropped,
+		message: fmt.Sprintf("at least %.2f%% of arrivals were dropped at 95%% confidence (threshold: %.2f%%)",
+			interval.Low, r.config.MaxDropped),
+	}
+}
+
+// startRefinement switches to bisecting between the last passing and the
+// first failing level once a stepping strategy breaches a threshold
+func (r *TestRunner) startRefinement(failedLevel int) bool {
+	if !r.config.Scaling.Refine || r.refining {
+		return false
+	}
+	if _, ok := r.strategy.(*binarySearch); ok {
+		return false
+	}
+
+	search, err := newBinarySearch(r.lastLevel, failedLevel, r.config.Scaling.Resolution)
+	if err != nil || failedLevel-r.lastLevel <= search.resolution {
+		return false
+	}
+
+	r.strategy = search
+	r.refining = true
+	return true
+}
+
+// stop fills in the final fields of the report once the search is over
+func (r *TestRunner) stop(report *types.LoadTestReport, reason types.StopReason, message string, slo *types.SLO) {
+	report.StopReason = reason
+	report.StopMessage = message
+	report.BreachedSLO = slo
+	// Without a measured passing level there is no capacity to report
+	if reason == types.StopNoCapacity {
+		r.lastLevel, r.lastRPS, r.lastSpread = 0, 0, nil
+	}
+	report.CorrectedLatency = r.config.CorrectedLatency
+	if r.config.Open() {
+		report.Model = types.ModelOpen
+		report.MaxArrivalRate = r.lastLevel
+	} else {
+		report.MaxConcurrency = r.lastLevel
+	}
+	report.MaxRPS = r.lastRPS
+	report.MaxRPSInterval = r.lastSpread
+	report.Scalability = usl.Analyze(report)
+
+	r.output.WriteLine(message)
+	if reason == types.StopError || reason == types.StopNoCapacity {
+		return
+	}
+	if r.lastSpread != nil {
+		r.output.WriteLine(fmt.Sprintf("Capacity: %s at %.2f RPS %s (highest passing level)",
+			r.describe(r.lastLevel), report.MaxRPS, describeInterval(r.lastSpread, r.config.Trials)))
+	} else {
+		r.output.WriteLine(fmt.Sprintf("Capacity: %s at %.2f RPS (highest passing level)",
+			r.describe(r.lastLevel), report.MaxRPS))
+	}
+	if report.Scalability != nil {
+		r.printScalability(report.Scalability)
+	}
+}
+
+// RunLoadTest executes the load test with the given configuration and
+// returns a report of every iteration and the capacity that was found. The
+// error is only set when the test could not be run; a partial report is still
+// returned when a client fails part way through.
+func RunLoadTest(config types.LoadTestConfig, output types.OutputHandler, clientType string) (*types.LoadTestReport, error) {
 	var testClient types.LoadTestClient
 	switch clientType {
 	case "k6":
@@ -137,34 +522,130 @@ func RunLoadTest(config types.LoadTestConfig, output types.OutputHandler, client
 		testClient = client.NewWRKClient(output)
 	case "ghz":
 		testClient = client.NewGHZClient(output)
+	case "native":
+		testClient = client.NewNativeClient(output)
 	default:
-		return fmt.Errorf("unsupported client type: %s", clientType)
+		return nil, fmt.Errorf("unsupported client type: %s", clientType)
+	}
+	return runLoadTest(config, output, testClient)
+}
+
+// runLoadTest validates the config and searches for the capacity with the
+// given client
+func runLoadTest(config types.LoadTestConfig, output types.OutputHandler, testClient types.LoadTestClient) (*types.LoadTestReport, error) {
+	if len(config.Scenario) > 0 {
+		if err := ValidateScenario(config.Scenario); err != nil {
+			return nil, fmt.Errorf("invalid scenario: %v", err)
+		}
+	}
+	// ghz fills {{.field}} references in bodies with its own call data
+	if testClient.Name() != "ghz" {
+		if err := ValidateTemplates(config); err != nil {
+			return nil, fmt.Errorf("invalid request template: %v", err)
+		}
+	}
+
+	if err := ValidateModel(config); err != nil {
+		return nil, err
+	}
+	if config.Trials < 0 {
+		return nil, fmt.Errorf("trials must not be negative, got %d", config.Trials)
 	}
 
-	runner := NewTestRunner(config, output, testClient)
-	originalThreads := config.Goroutines
+	initial := config.Goroutines
+	if config.Open() {
+		initial = config.ArrivalRate
+	}
+	strategy, err := NewScalingStrategy(config.Scaling, initial)
+	if err != nil {
+		return nil, err
+	}
+
+	runner := NewTestRunner(config, output, testClient, strategy)
+	report := &types.LoadTestReport{}
 
 	// Run initial test with 1 thread
-	if _, err := runner.runInitialTest(); err != nil {
-		return err
+	baseline, err := runner.runInitialTest()
+	var breach *breachError
+	if errors.As(err, &breach) {
+		report.Baseline = baseline
+		runner.emitBreach(1, breach)
+		runner.stop(report, breach.reason, fmt.Sprintf("stopping: baseline already breached a threshold: %s", breach), breach.slo)
+		return report, nil
 	}
+	if err != nil {
+		if config.Ctx.Err() != nil {
+			runner.stop(report, types.StopCancelled, "Test terminated by user", nil)
+			return report, nil
+		}
+		runner.stop(report, types.StopError, err.Error(), nil)
+		return report, err
+	}
+	report.Baseline = baseline
 
-	// Start with 1 thread for the initial test
-	currentThreads := 1
+	// Start with 1 VU, or 1 request/s, for the initial test
+	level := 1
 
 	for {
 		select {
 		case <-config.Ctx.Done():
-			output.WriteLine("\nTest terminated by user")
-			return nil
+			runner.stop(report, types.StopCancelled, "Test terminated by user", nil)
+			return report, nil
 		default:
 			// Calculate next thread count
-			currentThreads = runner.calculateNextThreads(currentThreads, originalThreads)
+			next, ok := runner.strategy.Next(level, breach != nil)
+			if !ok {
+				search, bisecting := runner.strategy.(*binarySearch)
+				switch {
+				case bisecting && search.low > runner.lastLevel:
+					// The known-good bound was never measured, and every
+					// level tried above it breached
+					runner.stop(report, types.StopNoCapacity,
+						fmt.Sprintf("Every level tried above the known-good %s breached a threshold; capacity could not be established",
+							runner.describe(search.low)), nil)
+				case bisecting:
+					runner.stop(report, types.StopSearchConverged,
+						fmt.Sprintf("Search converged to within %s", runner.describe(search.resolution)), nil)
+				case breach != nil:
+					runner.stop(report, breach.reason, fmt.Sprintf("stopping: %s", breach), breach.slo)
+				default:
+					runner.stop(report, types.StopStrategyExhausted, "Scaling strategy has no more levels to test", nil)
+				}
+				return report, nil
+			}
+			level = next
+
+			runner.cooldown()
+			if config.Ctx.Err() != nil {
+				runner.stop(report, types.StopCancelled, "Test terminated by user", nil)
+				return report, nil
+			}
 
-			if _, err := runner.runIteration(currentThreads); err != nil {
-				output.WriteLine(err.Error())
-				return nil
+			result, err := runner.runIteration(level)
+			breach = nil
+			if errors.As(err, &breach) {
+				iteration := runner.iteration(level, result)
+				iteration.Breach = breach.reason
+				iteration.BreachedSLO = breach.slo
+				report.Iterations = append(report.Iterations, iteration)
+				runner.emitBreach(level, breach)
+				if _, bisecting := runner.strategy.(*binarySearch); bisecting {
+					output.WriteLine(fmt.Sprintf("Threshold breached at %s: %s\n", runner.describe(level), breach))
+				} else if runner.startRefinement(level) {
+					output.WriteLine(fmt.Sprintf("Threshold breached at %s: %s", runner.describe(level), breach))
+					output.WriteLine(fmt.Sprintf("Refining capacity between %s and %s...\n", runner.describe(runner.lastLevel), runner.describe(level)))
+				}
+				continue
+			}
+			if err != nil {
+				if config.Ctx.Err() != nil {
+					runner.stop(report, types.StopCancelled, "Test terminated by user", nil)
+					return report, nil
+				}
+				runner.stop(report, types.StopError, err.Error(), nil)
+				return report, err
 			}
+			report.Iterations = append(report.Iterations, runner.iteration(level, result))
 		}
 	}
 }
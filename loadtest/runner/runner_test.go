@@ -0,0 +1,364 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// discardOutput drops everything the runner writes
+type discardOutput struct{}
+
+func (discardOutput) WriteLine(string) {}
+
+// fakeClient returns results computed from the VU count instead of running a
+// test: throughput grows linearly until breakAt, where P90 latency jumps.
+// With correctedBreakAt set, results also carry corrected latencies, which
+// jump from that VU count on.
+type fakeClient struct {
+	breakAt          int
+	correctedBreakAt int
+}
+
+func (c *fakeClient) Name() string {
+	return "fake"
+}
+
+func (c *fakeClient) RunTest(config types.LoadTestConfig) (string, error) {
+	return "", fmt.Errorf("fake client only returns results")
+}
+
+func (c *fakeClient) RunTestResult(config types.LoadTestConfig) (*types.LoadTestResult, error) {
+	p90 := 10.0
+	if config.Goroutines >= c.breakAt {
+		p90 = 100
+	}
+	result := &types.LoadTestResult{RPS: 100 * float64(config.Goroutines), P50: p90 / 2, P75: p90 / 2, P90: p90, P99: p90}
+	if c.correctedBreakAt > 0 {
+		corrected := 10.0
+		if config.Goroutines >= c.correctedBreakAt {
+			corrected = 100
+		}
+		result.CorrectedP50, result.CorrectedP75, result.CorrectedP90, result.CorrectedP99 = corrected/2, corrected/2, corrected, corrected
+	}
+	return result, nil
+}
+
+func binaryConfig(low, high int) types.LoadTestConfig {
+	return types.LoadTestConfig{
+		URL:                "http://example.test/",
+		Goroutines:         low,
+		Duration:           time.Second,
+		MaxLatencyIncrease: 15,
+		MinRpsIncrease:     4,
+		Scaling:            types.ScalingConfig{Strategy: "binary", Low: low, High: high, Resolution: 1},
+		Ctx:                context.Background(),
+	}
+}
+
+func TestBinarySearchEveryProbeFails(t *testing.T) {
+	client := &fakeClient{breakAt: 2}
+	report, err := runLoadTest(binaryConfig(10, 20), discardOutput{}, client)
+	if err != nil {
+		t.Fatalf("runLoadTest: %v", err)
+	}
+
+	if report.StopReason != types.StopNoCapacity {
+		t.Errorf("stop reason = %s, want %s", report.StopReason, types.StopNoCapacity)
+	}
+	// Neither the unmeasured known-good bound nor the baseline is a capacity
+	if report.Capacity() != 0 || report.MaxRPS != 0 {
+		t.Errorf("capacity = %d at %.2f RPS, want none", report.Capacity(), report.MaxRPS)
+	}
+	if len(report.Iterations) == 0 {
+		t.Fatal("expected the probes to be reported")
+	}
+	for _, iteration := range report.Iterations {
+		if iteration.Breach == "" {
+			t.Errorf("probe at %d VUs passed, want every probe to breach", iteration.VUs)
+		}
+		if iteration.VUs <= 10 || iteration.VUs >= 20 {
+			t.Errorf("probe at %d VUs is outside the bracket (10, 20)", iteration.VUs)
+		}
+	}
+}
+
+func TestBinarySearchConverges(t *testing.T) {
+	client := &fakeClient{breakAt: 15}
+	report, err := runLoadTest(binaryConfig(10, 20), discardOutput{}, client)
+	if err != nil {
+		t.Fatalf("runLoadTest: %v", err)
+	}
+
+	if report.StopReason != types.StopSearchConverged {
+		t.Errorf("stop reason = %s, want %s", report.StopReason, types.StopSearchConverged)
+	}
+	if report.Capacity() != 14 || report.MaxRPS != 1400 {
+		t.Errorf("capacity = %d at %.2f RPS, want 14 at 1400", report.Capacity(), report.MaxRPS)
+	}
+}
+
+func TestLatencySeries(t *testing.T) {
+	tests := []struct {
+		name             string
+		client           *fakeClient
+		correctedLatency bool
+		slos             []types.SLO
+		wantReason       types.StopReason
+		wantCapacity     int
+	}{
+		{name: "measured by default", client: &fakeClient{breakAt: 15, correctedBreakAt: 12}, wantReason: types.StopSearchConverged, wantCapacity: 14},
+		{name: "corrected when chosen", client: &fakeClient{breakAt: 15, correctedBreakAt: 12}, correctedLatency: true, wantReason: types.StopSearchConverged, wantCapacity: 11},
+		{name: "slo on the measured series", client: &fakeClient{breakAt: 15, correctedBreakAt: 12}, slos: []types.SLO{{Metric: "p99", Operator: "<", Threshold: 50}},
+			wantReason: types.StopSearchConverged, wantCapacity: 14},
+		{name: "slo on the corrected series", client: &fakeClient{breakAt: 15, correctedBreakAt: 12}, correctedLatency: true, slos: []types.SLO{{Metric: "p99", Operator: "<", Threshold: 50}},
+			wantReason: types.StopSearchConverged, wantCapacity: 11},
+		{name: "corrected without corrected latencies", client: &fakeClient{breakAt: 15}, correctedLatency: true, wantReason: types.StopError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := binaryConfig(10, 20)
+			config.CorrectedLatency = tt.correctedLatency
+			config.SLOs = tt.slos
+			if len(tt.slos) > 0 {
+				// Only the SLO stops the search
+				config.MaxLatencyIncrease = 1000
+			}
+			report, err := runLoadTest(config, discardOutput{}, tt.client)
+			if tt.wantReason == types.StopError {
+				if err == nil || report.StopReason != types.StopError {
+					t.Fatalf("expected the run to fail, got %v stopping with %s", err, report.StopReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("runLoadTest: %v", err)
+			}
+			if report.StopReason != tt.wantReason || report.Capacity() != tt.wantCapacity {
+				t.Errorf("stopped with %s at %d VUs, want %s at %d: %s",
+					report.StopReason, report.Capacity(), tt.wantReason, tt.wantCapacity, report.StopMessage)
+			}
+			if report.CorrectedLatency != tt.correctedLatency {
+				t.Errorf("report says corrected latency = %v, want %v", report.CorrectedLatency, tt.correctedLatency)
+			}
+		})
+	}
+}
+
+// trialClient returns the results of a level's trials in turn, by VU count
+type trialClient struct {
+	trials map[int][]types.LoadTestResult
+	runs   map[int]int
+}
+
+func (c *trialClient) Name() string {
+	return "fake"
+}
+
+func (c *trialClient) RunTest(config types.LoadTestConfig) (string, error) {
+	return "", fmt.Errorf("fake client only returns results")
+}
+
+func (c *trialClient) RunTestResult(config types.LoadTestConfig) (*types.LoadTestResult, error) {
+	trials := c.trials[config.Goroutines]
+	result := trials[c.runs[config.Goroutines]%len(trials)]
+	c.runs[config.Goroutines]++
+	return &result, nil
+}
+
+func TestTrialsBreachOnConfidenceIntervals(t *testing.T) {
+	trial := func(rps, p99, errorRate float64) types.LoadTestResult {
+		return types.LoadTestResult{RPS: rps, P50: 5, P75: 5, P90: 10, P99: p99, ErrorRate: errorRate}
+	}
+	// The baseline's RPS is noisy, so its interval is wide
+	baseline := []types.LoadTestResult{trial(150, 10, 0), trial(200, 10, 0), trial(250, 10, 0)}
+
+	tests := []struct {
+		name       string
+		trials     []types.LoadTestResult // At 2 VUs
+		slos       []types.SLO
+		wantReason types.StopReason
+	}{
+		// 195 RPS against a mean of 200 misses the 4% increase, but the
+		// baseline's interval allows it
+		{name: "rps within the previous interval", trials: []types.LoadTestResult{trial(190, 10, 0), trial(195, 10, 0), trial(200, 10, 0)},
+			wantReason: types.StopStrategyExhausted},
+		{name: "rps below the previous interval", trials: []types.LoadTestResult{trial(20, 10, 0), trial(21, 10, 0), trial(22, 10, 0)},
+			wantReason: types.StopRpsThreshold},
+		// A mean error rate of 6% is past the 5% limit, but one bad trial
+		// doesn't put the whole interval past it
+		{name: "error rate from one noisy trial", trials: []types.LoadTestResult{trial(400, 10, 0), trial(400, 10, 0), trial(400, 10, 18)},
+			wantReason: types.StopStrategyExhausted},
+		{name: "error rate in every trial", trials: []types.LoadTestResult{trial(400, 10, 9), trial(400, 10, 10), trial(400, 10, 11)},
+			wantReason: types.StopErrorRate},
+		{name: "slo from one noisy trial", trials: []types.LoadTestResult{trial(400, 10, 0), trial(400, 10, 0), trial(400, 130, 0)},
+			slos: []types.SLO{{Metric: "p99", Operator: "<", Threshold: 50}}, wantReason: types.StopStrategyExhausted},
+		{name: "slo in every trial", trials: []types.LoadTestResult{trial(400, 59, 0), trial(400, 60, 0), trial(400, 61, 0)},
+			slos: []types.SLO{{Metric: "p99", Operator: "<", Threshold: 50}}, wantReason: types.StopSLOBreach},
+		{name: "lower limit slo from one noisy trial", trials: []types.LoadTestResult{trial(400, 10, 0), trial(400, 10, 0), trial(50, 10, 0)},
+			slos: []types.SLO{{Metric: "rps", Operator: ">=", Threshold: 300}}, wantReason: types.StopStrategyExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := types.LoadTestConfig{
+				URL:                "http://example.test/",
+				Goroutines:         2,
+				Duration:           time.Second,
+				Trials:             3,
+				MaxLatencyIncrease: 15,
+				MinRpsIncrease:     4,
+				MaxErrorRate:       5,
+				SLOs:               tt.slos,
+				Scaling:            types.ScalingConfig{Strategy: "list", Levels: []int{2}},
+				Ctx:                context.Background(),
+			}
+			client := &trialClient{trials: map[int][]types.LoadTestResult{1: baseline, 2: tt.trials}, runs: make(map[int]int)}
+			report, err := runLoadTest(config, discardOutput{}, client)
+			if err != nil {
+				t.Fatalf("runLoadTest: %v", err)
+			}
+			if report.StopReason != tt.wantReason {
+				t.Errorf("stopped with %s, want %s: %s", report.StopReason, tt.wantReason, report.StopMessage)
+			}
+		})
+	}
+}
+
+// openClient serves arrivals up to a fixed rate and drops the rest, the way
+// a service that can't keep up looks in the open model
+type openClient struct {
+	capacity int
+	levels   []types.LoadTestConfig
+}
+
+func (c *openClient) Name() string {
+	return "open"
+}
+
+func (c *openClient) RunTest(config types.LoadTestConfig) (string, error) {
+	return "", fmt.Errorf("open client only returns results")
+}
+
+func (c *openClient) RunTestResult(config types.LoadTestConfig) (*types.LoadTestResult, error) {
+	c.levels = append(c.levels, config)
+	served := config.ArrivalRate
+	if served > c.capacity {
+		served = c.capacity
+	}
+	seconds := int64(config.Duration / time.Second)
+	return &types.LoadTestResult{
+		RPS:           float64(served),
+		TotalRequests: int64(served) * seconds,
+		Dropped:       int64(config.ArrivalRate-served) * seconds,
+		P50:           5,
+		P75:           8,
+		P90:           10,
+		P99:           20,
+	}, nil
+}
+
+func TestOpenModelRampsArrivalRate(t *testing.T) {
+	client := &openClient{capacity: 500}
+	config := types.LoadTestConfig{
+		URL:                "http://example.test/",
+		Model:              types.ModelOpen,
+		ArrivalRate:        100,
+		MaxVUs:             50,
+		Goroutines:         7,
+		Duration:           time.Second,
+		MaxLatencyIncrease: 50,
+		MaxDropped:         1,
+		Scaling:            types.ScalingConfig{Strategy: "geometric", Factor: 2},
+		Ctx:                context.Background(),
+	}
+	report, err := runLoadTest(config, discardOutput{}, client)
+	if err != nil {
+		t.Fatalf("runLoadTest: %v", err)
+	}
+
+	// The rate doubles from the baseline's 1 request/s and the initial
+	// rate until arrivals are dropped
+	var rates []int
+	for _, level := range client.levels {
+		rates = append(rates, level.ArrivalRate)
+		if level.Goroutines != 7 || level.VUs() != 50 {
+			t.Errorf("at %d requests/s the client got %d goroutines and %d VUs, want the config's 7 and 50",
+				level.ArrivalRate, level.Goroutines, level.VUs())
+		}
+	}
+	if fmt.Sprint(rates) != "[1 100 200 400 800]" {
+		t.Errorf("rates = %v, want [1 100 200 400 800]", rates)
+	}
+
+	if report.StopReason != types.StopDropped {
+		t.Errorf("stop reason = %s, want %s", report.StopReason, types.StopDropped)
+	}
+	if report.Model != types.ModelOpen || report.Capacity() != 400 || report.MaxArrivalRate != 400 || report.MaxConcurrency != 0 {
+		t.Errorf("model %q, capacity %d, max arrival rate %d, max concurrency %d; want open, 400, 400, 0",
+			report.Model, report.Capacity(), report.MaxArrivalRate, report.MaxConcurrency)
+	}
+	if report.CapacityUnit() != "requests/s" || report.MaxRPS != 400 {
+		t.Errorf("capacity unit %q at %.2f RPS, want requests/s at 400", report.CapacityUnit(), report.MaxRPS)
+	}
+	for _, iteration := range report.Iterations {
+		if iteration.Rate == 0 || iteration.VUs != 0 {
+			t.Errorf("iteration = %d requests/s and %d VUs, want it recorded by rate", iteration.Rate, iteration.VUs)
+		}
+	}
+	if last := report.Iterations[len(report.Iterations)-1]; last.Rate != 800 || last.Result.DroppedRate() != 37.5 {
+		t.Errorf("last iteration at %d requests/s dropped %.2f%%, want 800 dropping 37.5%%", last.Rate, last.Result.DroppedRate())
+	}
+}
+
+// recordingOutput keeps everything the runner writes
+type recordingOutput struct {
+	lines []string
+}
+
+func (o *recordingOutput) WriteLine(line string) {
+	o.lines = append(o.lines, line)
+}
+
+func TestRefinementNamesTheUnit(t *testing.T) {
+	closed := binaryConfig(10, 0)
+	closed.Scaling = types.ScalingConfig{Strategy: "geometric", Factor: 2, Refine: true}
+	open := types.LoadTestConfig{
+		URL:                "http://example.test/",
+		Model:              types.ModelOpen,
+		ArrivalRate:        100,
+		Duration:           time.Second,
+		MaxLatencyIncrease: 50,
+		MaxDropped:         1,
+		Scaling:            types.ScalingConfig{Strategy: "geometric", Factor: 2, Refine: true},
+		Ctx:                context.Background(),
+	}
+	tests := []struct {
+		name   string
+		config types.LoadTestConfig
+		client types.LoadTestClient
+		want   string
+	}{
+		{name: "closed", config: closed, client: &fakeClient{breakAt: 15},
+			want: "Refining capacity between 10 virtual users and 20 virtual users...\n"},
+		{name: "open", config: open, client: &openClient{capacity: 500},
+			want: "Refining capacity between 400 requests/s and 800 requests/s...\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := &recordingOutput{}
+			if _, err := runLoadTest(tt.config, output, tt.client); err != nil {
+				t.Fatalf("runLoadTest: %v", err)
+			}
+			for _, line := range output.lines {
+				if line == tt.want {
+					return
+				}
+			}
+			t.Errorf("output doesn't contain %q:\n%s", tt.want, strings.Join(output.lines, "\n"))
+		})
+	}
+}
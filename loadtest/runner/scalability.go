@@ -0,0 +1,38 @@
+package runner
+
+import (
+	"fmt"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// printScalability explains the model fitted to the levels of a run and,
+// at the capacity that was found, which of its two costs limits scaling most
+func (r *TestRunner) printScalability(scalability *types.Scalability) {
+	if scalability.Fitted {
+		r.output.WriteLine(fmt.Sprintf("Universal Scalability Law fit: λ %.2f RPS per VU, contention σ %.4f, coherency κ %.6f (R² %.3f)",
+			scalability.Lambda, scalability.Sigma, scalability.Kappa, scalability.RSquared))
+		switch {
+		case scalability.PeakConcurrency > 0:
+			r.output.WriteLine(fmt.Sprintf("Throughput is predicted to peak at %.0f virtual users with %.2f RPS and fall beyond",
+				scalability.PeakConcurrency, scalability.PeakRPS))
+		case scalability.PeakRPS > 0:
+			r.output.WriteLine(fmt.Sprintf("Throughput is predicted to level off at %.2f RPS", scalability.PeakRPS))
+		default:
+			r.output.WriteLine("Throughput is predicted to keep scaling linearly")
+		}
+
+		// The penalties each coefficient adds to the denominator of the law
+		n := float64(r.lastLevel)
+		contention, coherency := scalability.Sigma*(n-1), scalability.Kappa*n*(n-1)
+		switch {
+		case contention > coherency:
+			r.output.WriteLine("Scaling is limited mostly by contention: work serialized on locks, queues or pools")
+		case coherency > 0:
+			r.output.WriteLine("Scaling is limited mostly by coherency: crosstalk such as cache invalidation or data synchronization")
+		}
+	}
+	if scalability.KneeLevel > 0 {
+		r.output.WriteLine(fmt.Sprintf("P90 latency knee: %s (P90 %.2fms)", r.describe(scalability.KneeLevel), scalability.KneeP90))
+	}
+}
@@ -0,0 +1,195 @@
+package runner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// ScalingStrategy decides the concurrency of each iteration after the baseline
+type ScalingStrategy interface {
+	// Next returns the VU count for the next iteration given the VU count of
+	// the previous one and whether it breached a threshold. It returns false
+	// when the search is over.
+	Next(current int, breached bool) (int, bool)
+}
+
+// thresholdScaler is implemented by strategies whose steps shrink as the search
+// narrows. A fixed MinRpsIncrease would fail every small step, so it is scaled
+// to the size of the step being taken.
+type thresholdScaler interface {
+	scaleRpsThreshold(threshold float64, from, to int) float64
+}
+
+// NewScalingStrategy builds the strategy selected in the scaling config.
+// initial is the configured number of goroutines, or arrival rate in the open
+// model, used as the first step
+// after the baseline by the stepping strategies.
+func NewScalingStrategy(config types.ScalingConfig, initial int) (ScalingStrategy, error) {
+	switch config.Strategy {
+	case "", "geometric":
+		factor := config.Factor
+		if factor == 0 {
+			factor = 1.5
+		}
+		if factor <= 1 {
+			return nil, fmt.Errorf("geometric scaling factor must be greater than 1, got %.2f", factor)
+		}
+		return &geometricScaling{initial: initial, factor: factor}, nil
+	case "linear":
+		step := config.Step
+		if step == 0 {
+			step = initial
+		}
+		if step < 1 {
+			return nil, fmt.Errorf("linear scaling step must be at least 1, got %d", step)
+		}
+		return &linearScaling{initial: initial, step: step}, nil
+	case "list":
+		if len(config.Levels) == 0 {
+			return nil, fmt.Errorf("list scaling needs at least one VU level")
+		}
+		for _, level := range config.Levels {
+			if level < 1 {
+				return nil, fmt.Errorf("list scaling levels must be at least 1, got %d", level)
+			}
+		}
+		return &listScaling{levels: config.Levels}, nil
+	case "binary":
+		return newBinarySearch(config.Low, config.High, config.Resolution)
+	default:
+		return nil, fmt.Errorf("unsupported scaling strategy: %s", config.Strategy)
+	}
+}
+
+// ParseLevels parses a comma-separated list of VU counts such as "10,20,50"
+func ParseLevels(s string) ([]int, error) {
+	var levels []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		level, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VU level %q: %v", part, err)
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+// geometricScaling jumps from the baseline to the configured goroutines and
+// then multiplies the VU count by a fixed factor on every step
+type geometricScaling struct {
+	initial int
+	factor  float64
+}
+
+func (s *geometricScaling) Next(current int, breached bool) (int, bool) {
+	if breached {
+		return 0, false
+	}
+	if current == 1 && s.initial > 1 {
+		// Second test: use configured goroutines
+		return s.initial, true
+	}
+	// Round up, and always move by at least one VU
+	next := int(float64(current)*s.factor + 0.5)
+	if next <= current {
+		next = current + 1
+	}
+	return next, true
+}
+
+// linearScaling jumps from the baseline to the configured goroutines and then
+// adds a fixed number of VUs on every step
+type linearScaling struct {
+	initial int
+	step    int
+}
+
+func (s *linearScaling) Next(current int, breached bool) (int, bool) {
+	if breached {
+		return 0, false
+	}
+	if current == 1 && s.initial > 1 {
+		return s.initial, true
+	}
+	return current + s.step, true
+}
+
+// listScaling walks through an explicit list of VU counts
+type listScaling struct {
+	levels []int
+	index  int
+}
+
+func (s *listScaling) Next(current int, breached bool) (int, bool) {
+	if breached || s.index >= len(s.levels) {
+		return 0, false
+	}
+	next := s.levels[s.index]
+	s.index++
+	return next, true
+}
+
+// binarySearch bisects between a known-good and a known-bad VU count until
+// the gap between them is no larger than the resolution
+type binarySearch struct {
+	low        int
+	high       int
+	resolution int
+	// bracketGrowth is the relative VU growth across the initial bracket
+	bracketGrowth float64
+	started       bool
+}
+
+func newBinarySearch(low, high, resolution int) (*binarySearch, error) {
+	if resolution == 0 {
+		resolution = 1
+	}
+	if low < 1 {
+		return nil, fmt.Errorf("binary search needs a known-good VU count of at least 1, got %d", low)
+	}
+	if high <= low {
+		return nil, fmt.Errorf("binary search needs a known-bad VU count above the known-good one (%d), got %d", low, high)
+	}
+	if resolution < 1 {
+		return nil, fmt.Errorf("binary search resolution must be at least 1, got %d", resolution)
+	}
+	return &binarySearch{
+		low:           low,
+		high:          high,
+		resolution:    resolution,
+		bracketGrowth: float64(high-low) / float64(low),
+	}, nil
+}
+
+func (s *binarySearch) Next(current int, breached bool) (int, bool) {
+	// The first call reports the iteration before the search started, which
+	// tells us nothing about the bracket
+	if s.started {
+		if breached {
+			s.high = current
+		} else {
+			s.low = current
+		}
+	}
+	s.started = true
+
+	if s.high-s.low <= s.resolution {
+		return 0, false
+	}
+	return s.low + (s.high-s.low)/2, true
+}
+
+func (s *binarySearch) scaleRpsThreshold(threshold float64, from, to int) float64 {
+	growth := float64(to-from) / float64(from)
+	if growth >= s.bracketGrowth {
+		return threshold
+	}
+	return threshold * growth / s.bracketGrowth
+}
@@ -0,0 +1,126 @@
+package runner
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// walk runs a strategy from the baseline, with every level from breachAt up
+// breaching, and returns the levels it steps through
+func walk(t *testing.T, strategy ScalingStrategy, breachAt int) []int {
+	t.Helper()
+	var levels []int
+	current := 1
+	for i := 0; i < 50; i++ {
+		next, ok := strategy.Next(current, current >= breachAt)
+		if !ok {
+			return levels
+		}
+		levels = append(levels, next)
+		current = next
+	}
+	t.Fatalf("search didn't end, stepped through %v", levels)
+	return nil
+}
+
+func TestScalingSequences(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   types.ScalingConfig
+		initial  int
+		breachAt int
+		want     []int
+	}{
+		// Geometric is the default, by 1.5 rounded, jumping to the initial
+		// level after the baseline
+		{name: "default", initial: 10, breachAt: 40, want: []int{10, 15, 23, 35, 53}},
+		{name: "geometric", config: types.ScalingConfig{Strategy: "geometric", Factor: 2}, initial: 5, breachAt: 40, want: []int{5, 10, 20, 40}},
+		{name: "geometric from 1", config: types.ScalingConfig{Strategy: "geometric"}, initial: 1, breachAt: 10, want: []int{2, 3, 5, 8, 12}},
+		// A small factor still moves by at least one VU
+		{name: "geometric small factor", config: types.ScalingConfig{Strategy: "geometric", Factor: 1.01}, initial: 10, breachAt: 13, want: []int{10, 11, 12, 13}},
+		{name: "geometric breach at initial", config: types.ScalingConfig{Strategy: "geometric"}, initial: 10, breachAt: 10, want: []int{10}},
+		// The step defaults to the initial level
+		{name: "linear", config: types.ScalingConfig{Strategy: "linear"}, initial: 10, breachAt: 35, want: []int{10, 20, 30, 40}},
+		{name: "linear step", config: types.ScalingConfig{Strategy: "linear", Step: 5}, initial: 1, breachAt: 12, want: []int{6, 11, 16}},
+		{name: "list", config: types.ScalingConfig{Strategy: "list", Levels: []int{5, 10, 20}}, initial: 1, breachAt: 100, want: []int{5, 10, 20}},
+		{name: "list breach", config: types.ScalingConfig{Strategy: "list", Levels: []int{5, 10, 20, 40}}, initial: 1, breachAt: 10, want: []int{5, 10}},
+		// Bisects to within the resolution of the first breaching level
+		{name: "binary", config: types.ScalingConfig{Strategy: "binary", Low: 10, High: 20}, breachAt: 16, want: []int{15, 17, 16}},
+		{name: "binary resolution", config: types.ScalingConfig{Strategy: "binary", Low: 100, High: 200, Resolution: 10}, breachAt: 130,
+			want: []int{150, 125, 137, 131}},
+		{name: "binary bracket within resolution", config: types.ScalingConfig{Strategy: "binary", Low: 10, High: 11}, breachAt: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := NewScalingStrategy(tt.config, tt.initial)
+			if err != nil {
+				t.Fatalf("NewScalingStrategy: %v", err)
+			}
+			if got := walk(t, strategy, tt.breachAt); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("levels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewScalingStrategyErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config types.ScalingConfig
+		want   string
+	}{
+		{name: "factor of 1", config: types.ScalingConfig{Strategy: "geometric", Factor: 1}, want: "factor must be greater than 1"},
+		{name: "negative step", config: types.ScalingConfig{Strategy: "linear", Step: -5}, want: "step must be at least 1"},
+		{name: "empty list", config: types.ScalingConfig{Strategy: "list"}, want: "at least one VU level"},
+		{name: "zero level", config: types.ScalingConfig{Strategy: "list", Levels: []int{10, 0}}, want: "levels must be at least 1"},
+		{name: "binary without low", config: types.ScalingConfig{Strategy: "binary", High: 20}, want: "known-good VU count of at least 1"},
+		{name: "binary inverted", config: types.ScalingConfig{Strategy: "binary", Low: 20, High: 10}, want: "above the known-good one"},
+		{name: "binary negative resolution", config: types.ScalingConfig{Strategy: "binary", Low: 10, High: 20, Resolution: -1}, want: "resolution must be at least 1"},
+		{name: "unknown", config: types.ScalingConfig{Strategy: "random"}, want: "unsupported scaling strategy: random"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewScalingStrategy(tt.config, 10)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels(" 10, 20,,50 ")
+	if err != nil || !reflect.DeepEqual(levels, []int{10, 20, 50}) {
+		t.Errorf("ParseLevels = %v, %v; want [10 20 50]", levels, err)
+	}
+	if _, err := ParseLevels("10,lots"); err == nil || !strings.Contains(err.Error(), `invalid VU level "lots"`) {
+		t.Errorf("error = %v, want the bad level reported", err)
+	}
+}
+
+func TestBinarySearchScalesRpsThreshold(t *testing.T) {
+	search, err := newBinarySearch(100, 200, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A step as large as the bracket keeps the whole threshold, smaller
+	// steps a share of it in proportion
+	tests := []struct {
+		from, to int
+		want     float64
+	}{
+		{from: 100, to: 200, want: 10},
+		{from: 100, to: 300, want: 10},
+		{from: 100, to: 150, want: 5},
+		{from: 150, to: 165, want: 1},
+	}
+	for _, tt := range tests {
+		if got := search.scaleRpsThreshold(10, tt.from, tt.to); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("scaleRpsThreshold(10, %d, %d) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// ParseScenario parses scenario lines of the form "weight METHOD url [body]",
+// e.g. "70 GET /rooms". URLs are resolved against base, so requests to the
+// tested service can be given as paths. Each request is named after the
+// method and URL as written. Blank lines are skipped.
+func ParseScenario(lines []string, base string) ([]types.Request, error) {
+	var requests []types.Request
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid scenario request %q, expected \"weight METHOD url [body]\"", line)
+		}
+		weight, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight in scenario request %q: %v", line, err)
+		}
+		requestURL, err := ResolveURL(base, fields[2])
+		if err != nil {
+			return nil, err
+		}
+		request := types.Request{
+			Name:   strings.ToUpper(fields[1]) + " " + fields[2],
+			Method: strings.ToUpper(fields[1]),
+			URL:    requestURL,
+			Weight: weight,
+		}
+		if len(fields) == 4 {
+			request.Body = strings.TrimSpace(fields[3])
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+// ResolveURL resolves a request URL, which may be just a path, against base
+func ResolveURL(base, ref string) (string, error) {
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %v", ref, err)
+	}
+	if refURL.IsAbs() || base == "" {
+		return ref, nil
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %v", base, err)
+	}
+	return baseURL.ResolveReference(refURL).String(), nil
+}
+
+// ValidateScenario checks that every request of a scenario can be sent and
+// told apart in the results
+func ValidateScenario(scenario []types.Request) error {
+	// An empty scenario would fall back to the config's single request
+	if len(scenario) == 0 {
+		return fmt.Errorf("scenario has no requests")
+	}
+	requests := types.LoadTestConfig{Scenario: scenario}.Requests()
+	names := make(map[string]bool, len(requests))
+	for _, request := range requests {
+		if names[request.Name] {
+			return fmt.Errorf("scenario has more than one request named %q; give them distinct names", request.Name)
+		}
+		names[request.Name] = true
+
+		// k6 uses "::" to separate nested group names
+		if strings.Contains(request.Name, "::") {
+			return fmt.Errorf("scenario request name %q must not contain \"::\"", request.Name)
+		}
+		if request.Weight <= 0 || math.IsInf(request.Weight, 0) || math.IsNaN(request.Weight) {
+			return fmt.Errorf("%s: weight must be a positive number, got %g", request.Name, request.Weight)
+		}
+		if u, err := url.Parse(request.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s: url %q is not an http(s) URL", request.Name, request.URL)
+		}
+		for _, ch := range request.Method {
+			if ch < 'A' || ch > 'Z' {
+				return fmt.Errorf("%s: invalid method %q", request.Name, request.Method)
+			}
+		}
+		for name, value := range request.Headers {
+			if err := ValidateHeader(name, value); err != nil {
+				return fmt.Errorf("%s: %v", request.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cursor-roomer/loadtest/types"
+)
+
+func TestParseScenario(t *testing.T) {
+	lines := []string{
+		"70 GET /rooms",
+		"",
+		"  20 post /rooms {\"name\": \"lobby\", \"size\": 4}  ",
+		"0.5 DELETE https://other.example.com/rooms/1",
+	}
+	requests, err := ParseScenario(lines, "http://example.com/api/")
+	if err != nil {
+		t.Fatalf("ParseScenario: %v", err)
+	}
+	want := []types.Request{
+		{Name: "GET /rooms", Method: "GET", URL: "http://example.com/rooms", Weight: 70},
+		{Name: "POST /rooms", Method: "POST", URL: "http://example.com/rooms", Body: `{"name": "lobby", "size": 4}`, Weight: 20},
+		{Name: "DELETE https://other.example.com/rooms/1", Method: "DELETE", URL: "https://other.example.com/rooms/1", Weight: 0.5},
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("ParseScenario = %+v, want %+v", requests, want)
+	}
+
+	// Blank lines alone are no scenario at all
+	if requests, err := ParseScenario([]string{"", "  "}, "http://example.com"); err != nil || requests != nil {
+		t.Errorf("ParseScenario of blank lines = %v, %v; want no requests", requests, err)
+	}
+}
+
+func TestParseScenarioErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "70 GET", want: `expected "weight METHOD url [body]"`},
+		{line: "GET /rooms", want: `expected "weight METHOD url [body]"`},
+		{line: "heavy GET /rooms", want: "invalid weight"},
+		{line: "70% GET /rooms", want: "invalid weight"},
+		{line: "70 GET http://[::1/rooms", want: "invalid URL"},
+	}
+	for _, tt := range tests {
+		_, err := ParseScenario([]string{"1 GET /", tt.line}, "http://example.com")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseScenario(%q) error = %v, want it to contain %q", tt.line, err, tt.want)
+		}
+	}
+}
+
+func TestResolveURL(t *testing.T) {
+	tests := []struct {
+		base, ref string
+		want      string
+	}{
+		{base: "http://example.com/api/v1", ref: "/rooms", want: "http://example.com/rooms"},
+		{base: "http://example.com/api/", ref: "rooms?size=4", want: "http://example.com/api/rooms?size=4"},
+		{base: "http://example.com/", ref: "https://other.example.com/", want: "https://other.example.com/"},
+		// Without a base the reference is left for validation to reject
+		{base: "", ref: "/rooms", want: "/rooms"},
+	}
+	for _, tt := range tests {
+		got, err := ResolveURL(tt.base, tt.ref)
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveURL(%q, %q) = %q, %v; want %q", tt.base, tt.ref, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidateScenario(t *testing.T) {
+	valid := func(change func(*types.Request)) []types.Request {
+		scenario := []types.Request{
+			{Name: "list", Method: "GET", URL: "http://example.com/rooms", Weight: 3},
+			{Name: "create", Method: "POST", URL: "https://example.com/rooms", Weight: 1},
+		}
+		if change != nil {
+			change(&scenario[1])
+		}
+		return scenario
+	}
+	tests := []struct {
+		name     string
+		scenario []types.Request
+		want     string
+	}{
+		{name: "valid", scenario: valid(nil)},
+		// The method defaults to GET and the name to the method and URL
+		{name: "defaults", scenario: valid(func(r *types.Request) { r.Name, r.Method = "", "" })},
+		{name: "lowercase method", scenario: valid(func(r *types.Request) { r.Method = "patch" })},
+		{name: "empty", want: "scenario has no requests"},
+		{name: "zero weight", scenario: valid(func(r *types.Request) { r.Weight = 0 }), want: "create: weight must be a positive number, got 0"},
+		{name: "negative weight", scenario: valid(func(r *types.Request) { r.Weight = -2 }), want: "weight must be a positive number"},
+		{name: "NaN weight", scenario: valid(func(r *types.Request) { r.Weight = math.NaN() }), want: "weight must be a positive number"},
+		{name: "infinite weight", scenario: valid(func(r *types.Request) { r.Weight = math.Inf(1) }), want: "weight must be a positive number"},
+		{name: "method with a digit", scenario: valid(func(r *types.Request) { r.Method = "POST2" }), want: `create: invalid method "POST2"`},
+		{name: "method with a space", scenario: valid(func(r *types.Request) { r.Method = "GET /" }), want: "invalid method"},
+		{name: "method with a dash", scenario: valid(func(r *types.Request) { r.Method = "M-SEARCH" }), want: "invalid method"},
+		{name: "relative URL", scenario: valid(func(r *types.Request) { r.URL = "/rooms" }), want: `url "/rooms" is not an http(s) URL`},
+		{name: "other scheme", scenario: valid(func(r *types.Request) { r.URL = "ftp://example.com/" }), want: "is not an http(s) URL"},
+		{name: "duplicate name", scenario: valid(func(r *types.Request) { r.Name = "list" }), want: `more than one request named "list"`},
+		// Requests named after the same method and URL clash too
+		{name: "duplicate default name", scenario: []types.Request{
+			{Method: "GET", URL: "http://example.com/", Weight: 1},
+			{Method: "get", URL: "http://example.com/", Weight: 2},
+		}, want: `more than one request named "GET http://example.com/"`},
+		{name: "group separator", scenario: valid(func(r *types.Request) { r.Name = "rooms::create" }), want: `must not contain "::"`},
+		{name: "bad header", scenario: valid(func(r *types.Request) { r.Headers = map[string]string{"X-Room": "a\nb"} }), want: "create:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateScenario(tt.scenario)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("ValidateScenario: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package runner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cursor-roomer/loadtest/parser"
+	"cursor-roomer/loadtest/types"
+)
+
+// sloOperators are checked longest first so "<=" isn't read as "<"
+var sloOperators = []string{"<=", ">=", "<", ">"}
+
+// ParseSLOs parses a comma-separated list of SLO rules such as
+// "p99<250ms, error_rate<0.5%, rps>=1000"
+func ParseSLOs(s string) ([]types.SLO, error) {
+	var slos []types.SLO
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		slo, err := ParseSLO(part)
+		if err != nil {
+			return nil, err
+		}
+		slos = append(slos, slo)
+	}
+	return slos, nil
+}
+
+// ParseSLO parses a single SLO rule such as "p99<250ms". Percentiles accept
+// us, ms or s units, error_rate accepts an optional % sign.
+func ParseSLO(s string) (types.SLO, error) {
+	for _, op := range sloOperators {
+		idx := strings.Index(s, op)
+		if idx == -1 {
+			continue
+		}
+
+		slo := types.SLO{
+			Metric:   strings.ToLower(strings.TrimSpace(s[:idx])),
+			Operator: op,
+		}
+		value := strings.TrimSpace(s[idx+len(op):])
+
+		var err error
+		switch slo.Metric {
+		case "p50", "p75", "p90", "p99":
+			slo.Threshold, err = parser.ParseLatency(value)
+			if err != nil {
+				// Bare numbers are taken as milliseconds
+				slo.Threshold, err = strconv.ParseFloat(value, 64)
+			}
+		case "error_rate":
+			slo.Threshold, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		case "rps":
+			slo.Threshold, err = strconv.ParseFloat(value, 64)
+		default:
+			return types.SLO{}, fmt.Errorf("unknown SLO metric %q in %q", slo.Metric, s)
+		}
+		if err != nil {
+			return types.SLO{}, fmt.Errorf("invalid SLO threshold in %q: %v", s, err)
+		}
+		return slo, nil
+	}
+	return types.SLO{}, fmt.Errorf("SLO %q has no comparison operator (<, <=, >, >=)", s)
+}
+
+// sloValue returns the value of the metric an SLO is defined over. Latencies
+// come from the corrected series when corrected is set.
+func sloValue(result *types.LoadTestResult, metric string, corrected bool) (float64, error) {
+	switch metric {
+	case "p50":
+		return result.Percentile(50, corrected), nil
+	case "p75":
+		return result.Percentile(75, corrected), nil
+	case "p90":
+		return result.Percentile(90, corrected), nil
+	case "p99":
+		return result.Percentile(99, corrected), nil
+	case "rps":
+		return result.RPS, nil
+	case "error_rate":
+		return result.ErrorRate, nil
+	default:
+		return 0, fmt.Errorf("unknown SLO metric %q", metric)
+	}
+}
+
+// checkSLOs returns a breach for the first SLO the result violates. With
+// repeated trials an SLO is only violated when the whole 95% confidence
+// interval of its metric over the trials is past the threshold.
+func checkSLOs(result *types.LoadTestResult, trials []*types.LoadTestResult, slos []types.SLO, corrected bool) *breachError {
+	for i, slo := range slos {
+		value, err := sloValue(result, slo.Metric, corrected)
+		if err != nil {
+			return &breachError{reason: types.StopSLOBreach, message: err.Error(), slo: &slos[i]}
+		}
+		interval := trialInterval(trials, func(trial *types.LoadTestResult) float64 {
+			value, _ := sloValue(trial, slo.Metric, corrected)
+			return value
+		})
+
+		// The favourable end of the interval is checked: the low end
+		// against an upper limit, the high end against a lower one
+		var ok bool
+		bound := value
+		switch slo.Operator {
+		case "<", "<=":
+			if interval != nil {
+				bound = interval.Low
+			}
+			ok = bound < slo.Threshold || (slo.Operator == "<=" && bound == slo.Threshold)
+		case ">", ">=":
+			if interval != nil {
+				bound = interval.High
+			}
+			ok = bound > slo.Threshold || (slo.Operator == ">=" && bound == slo.Threshold)
+		default:
+			return &breachError{reason: types.StopSLOBreach, message: fmt.Sprintf("unsupported SLO operator %q", slo.Operator), slo: &slos[i]}
+		}
+		if !ok {
+			message := fmt.Sprintf("SLO %s violated (measured %.2f)", slo, value)
+			if interval != nil {
+				message = fmt.Sprintf("SLO %s violated at 95%% confidence (measured %.2f, 95%% CI %.2f to %.2f)",
+					slo, value, interval.Low, interval.High)
+			}
+			return &breachError{reason: types.StopSLOBreach, message: message, slo: &slos[i]}
+		}
+	}
+	return nil
+}
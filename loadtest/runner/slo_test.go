@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"cursor-roomer/loadtest/types"
+)
+
+func TestParseSLO(t *testing.T) {
+	tests := []struct {
+		rule string
+		want types.SLO
+	}{
+		{rule: "p99<250ms", want: types.SLO{Metric: "p99", Operator: "<", Threshold: 250}},
+		{rule: " P90 <= 1.5s ", want: types.SLO{Metric: "p90", Operator: "<=", Threshold: 1500}},
+		{rule: "p50<800us", want: types.SLO{Metric: "p50", Operator: "<", Threshold: 0.8}},
+		{rule: "p75<800µs", want: types.SLO{Metric: "p75", Operator: "<", Threshold: 0.8}},
+		// Bare latencies are milliseconds
+		{rule: "p99<250", want: types.SLO{Metric: "p99", Operator: "<", Threshold: 250}},
+		{rule: "error_rate<0.5%", want: types.SLO{Metric: "error_rate", Operator: "<", Threshold: 0.5}},
+		{rule: "error_rate<=1", want: types.SLO{Metric: "error_rate", Operator: "<=", Threshold: 1}},
+		{rule: "rps>=1000", want: types.SLO{Metric: "rps", Operator: ">=", Threshold: 1000}},
+		{rule: "rps>99.5", want: types.SLO{Metric: "rps", Operator: ">", Threshold: 99.5}},
+	}
+	for _, tt := range tests {
+		got, err := ParseSLO(tt.rule)
+		if err != nil {
+			t.Errorf("ParseSLO(%q): %v", tt.rule, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSLO(%q) = %+v, want %+v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestParseSLOErrors(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{rule: "p99", want: "has no comparison operator"},
+		{rule: "p99=250ms", want: "has no comparison operator"},
+		{rule: "p99==250ms", want: "has no comparison operator"},
+		{rule: "p99=<250ms", want: `unknown SLO metric "p99="`},
+		{rule: "p99<<250ms", want: "invalid SLO threshold"},
+		{rule: "p99< >250ms", want: "invalid SLO threshold"},
+		{rule: "p99<250xs", want: "invalid SLO threshold"},
+		{rule: "p99<250m", want: "invalid SLO threshold"},
+		{rule: "p99<ms", want: "invalid SLO threshold"},
+		{rule: "p99<", want: "invalid SLO threshold"},
+		{rule: "error_rate<0.5%%", want: "invalid SLO threshold"},
+		{rule: "rps>=1k", want: "invalid SLO threshold"},
+		{rule: "p95<250ms", want: `unknown SLO metric "p95"`},
+		{rule: "<250ms", want: `unknown SLO metric ""`},
+	}
+	for _, tt := range tests {
+		_, err := ParseSLO(tt.rule)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseSLO(%q) error = %v, want it to contain %q", tt.rule, err, tt.want)
+		}
+	}
+}
+
+func TestParseSLOs(t *testing.T) {
+	slos, err := ParseSLOs(" p99<250ms, error_rate<0.5%,, rps>=1000 ")
+	if err != nil {
+		t.Fatalf("ParseSLOs: %v", err)
+	}
+	want := []types.SLO{
+		{Metric: "p99", Operator: "<", Threshold: 250},
+		{Metric: "error_rate", Operator: "<", Threshold: 0.5},
+		{Metric: "rps", Operator: ">=", Threshold: 1000},
+	}
+	if !reflect.DeepEqual(slos, want) {
+		t.Errorf("ParseSLOs = %+v, want %+v", slos, want)
+	}
+
+	if slos, err := ParseSLOs(""); err != nil || slos != nil {
+		t.Errorf("ParseSLOs(\"\") = %v, %v; want no SLOs", slos, err)
+	}
+	// One bad rule rejects the list
+	if _, err := ParseSLOs("p99<250ms,p99>"); err == nil || !strings.Contains(err.Error(), `"p99>"`) {
+		t.Errorf("error = %v, want the bad rule named", err)
+	}
+}
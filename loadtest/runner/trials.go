@@ -0,0 +1,152 @@
+package runner
+
+import (
+	"fmt"
+	"math"
+
+	"cursor-roomer/loadtest/stats"
+	"cursor-roomer/loadtest/types"
+)
+
+// runTrials runs the client Trials times at the current level, cooling down
+// between trials, and aggregates the results into one. A single trial is
+// returned as is.
+func (r *TestRunner) runTrials() (*types.LoadTestResult, error) {
+	r.trials = nil
+	if r.config.Trials <= 1 {
+		return r.runTest()
+	}
+
+	results := make([]*types.LoadTestResult, 0, r.config.Trials)
+	for i := 1; i <= r.config.Trials; i++ {
+		if i > 1 {
+			r.cooldown()
+			if r.config.Ctx.Err() != nil {
+				return nil, fmt.Errorf("test cancelled")
+			}
+		}
+		r.output.WriteLine(fmt.Sprintf("Trial %d of %d", i, r.config.Trials))
+		result, err := r.runTest()
+		if err != nil {
+			return nil, err
+		}
+		r.output.WriteLine(fmt.Sprintf("Trial %d: %.2f RPS, P90 %.2fms", i, result.RPS, result.Percentile(90, r.config.CorrectedLatency)))
+		results = append(results, result)
+	}
+	r.trials = results
+	return aggregateTrials(results, r.config.CorrectedLatency), nil
+}
+
+// aggregateTrials combines the results of repeated trials at one level:
+// counts are summed, rates and latencies averaged. The P90 interval is taken
+// over the corrected series when corrected is set.
+func aggregateTrials(results []*types.LoadTestResult, corrected bool) *types.LoadTestResult {
+	n := float64(len(results))
+	aggregate := &types.LoadTestResult{Trials: len(results)}
+	rps := make([]float64, len(results))
+	p90 := make([]float64, len(results))
+
+	for i, result := range results {
+		rps[i] = result.RPS
+		p90[i] = result.Percentile(90, corrected)
+
+		aggregate.RPS += result.RPS / n
+		aggregate.P50 += result.P50 / n
+		aggregate.P75 += result.P75 / n
+		aggregate.P90 += result.P90 / n
+		aggregate.P99 += result.P99 / n
+		aggregate.CorrectedP50 += result.CorrectedP50 / n
+		aggregate.CorrectedP75 += result.CorrectedP75 / n
+		aggregate.CorrectedP90 += result.CorrectedP90 / n
+		aggregate.CorrectedP99 += result.CorrectedP99 / n
+		aggregate.ErrorRate += result.ErrorRate / n
+
+		aggregate.TotalRequests += result.TotalRequests
+		aggregate.FailedRequests += result.FailedRequests
+		aggregate.Timeouts += result.Timeouts
+		aggregate.SocketErrors += result.SocketErrors
+		aggregate.ChecksPassed += result.ChecksPassed
+		aggregate.ChecksFailed += result.ChecksFailed
+		aggregate.Dropped += result.Dropped
+		aggregate.Late += result.Late
+		aggregate.ClampedLatencies += result.ClampedLatencies
+
+		for code, count := range result.StatusCodes {
+			if aggregate.StatusCodes == nil {
+				aggregate.StatusCodes = make(map[string]int64)
+			}
+			aggregate.StatusCodes[code] += count
+		}
+
+		// Every trial runs the same scenario, so endpoints line up
+		if aggregate.Endpoints == nil && len(result.Endpoints) > 0 {
+			aggregate.Endpoints = make([]types.EndpointResult, len(result.Endpoints))
+		}
+		for j, endpoint := range result.Endpoints {
+			if j >= len(aggregate.Endpoints) {
+				break
+			}
+			sum := &aggregate.Endpoints[j]
+			sum.Name = endpoint.Name
+			sum.Requests += endpoint.Requests
+			sum.FailedRequests += endpoint.FailedRequests
+			sum.ErrorRate += endpoint.ErrorRate / n
+			sum.RPS += endpoint.RPS / n
+			sum.P50 += endpoint.P50 / n
+			sum.P75 += endpoint.P75 / n
+			sum.P90 += endpoint.P90 / n
+			sum.P99 += endpoint.P99 / n
+		}
+	}
+
+	aggregate.RPSInterval = newInterval(rps)
+	aggregate.P90Interval = newInterval(p90)
+	return aggregate
+}
+
+// newInterval summarises the values of a metric over repeated trials
+func newInterval(values []float64) *types.Interval {
+	mean, halfWidth := stats.ConfidenceInterval95(values)
+	return &types.Interval{
+		Mean:   mean,
+		StdDev: stats.StdDev(values),
+		Low:    mean - halfWidth,
+		High:   mean + halfWidth,
+	}
+}
+
+// trialInterval returns the 95% confidence interval of a metric over
+// repeated trials, nil for a single run
+func trialInterval(trials []*types.LoadTestResult, metric func(*types.LoadTestResult) float64) *types.Interval {
+	if len(trials) < 2 {
+		return nil
+	}
+	values := make([]float64, len(trials))
+	for i, trial := range trials {
+		values[i] = metric(trial)
+	}
+	return newInterval(values)
+}
+
+// intervalChange returns the smallest and largest change, in percent, from a
+// reference value to a level's interval that both intervals allow. The
+// reference has an interval of its own when it was measured over repeated
+// trials too; an interval reaching down to zero allows any increase.
+func intervalChange(reference float64, referenceSpread, current *types.Interval) (low, high float64) {
+	referenceLow, referenceHigh := reference, reference
+	if referenceSpread != nil {
+		referenceLow, referenceHigh = referenceSpread.Low, referenceSpread.High
+	}
+	low = (current.Low - referenceHigh) / referenceHigh * 100
+	if referenceLow <= 0 {
+		return low, math.Inf(1)
+	}
+	return low, (current.High - referenceLow) / referenceLow * 100
+}
+
+// describeInterval formats the spread of a metric over repeated trials,
+// e.g. "± 3.20 (95% CI over 3 trials, std dev 1.29)"
+func describeInterval(interval *types.Interval, trials int) string {
+	return fmt.Sprintf("± %.2f (95%% CI over %d trials, std dev %.2f)",
+		(interval.High-interval.Low)/2, trials, interval.StdDev)
+}
@@ -0,0 +1,69 @@
+package stats
+
+import "math"
+
+// tCritical95 holds the two-sided 95% critical values of Student's t
+// distribution, indexed by degrees of freedom minus one
+var tCritical95 = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// Mean returns the arithmetic mean of values, or 0 when there are none
+func Mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// StdDev returns the sample standard deviation of values, or 0 when there are
+// fewer than two
+func StdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	mean := Mean(values)
+	var sum float64
+	for _, v := range values {
+		sum += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sum / float64(len(values)-1))
+}
+
+// ConfidenceInterval95 returns the mean of values and the half-width of its
+// two-sided 95% confidence interval, which is infinite for fewer than two
+func ConfidenceInterval95(values []float64) (mean, halfWidth float64) {
+	mean = Mean(values)
+	if len(values) < 2 {
+		return mean, math.Inf(1)
+	}
+	return mean, TCritical95(len(values)-1) * StdDev(values) / math.Sqrt(float64(len(values)))
+}
+
+// TCritical95 returns the two-sided 95% critical value of Student's t
+// distribution for the given degrees of freedom. Beyond the table the normal
+// approximation is close enough.
+func TCritical95(df int) float64 {
+	if df < 1 {
+		return math.Inf(1)
+	}
+	if df <= len(tCritical95) {
+		return tCritical95[df-1]
+	}
+	if df <= 40 {
+		return 2.021
+	}
+	if df <= 60 {
+		return 2.000
+	}
+	if df <= 120 {
+		return 1.980
+	}
+	return 1.960
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// ErrNotFound is returned when no run with the requested ID exists
+var ErrNotFound = errors.New("run not found")
+
+// validID keeps run IDs from escaping the store directory
+var validID = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+
+// Run is a single capacity search as recorded in the store
+type Run struct {
+	ID         string                `json:"id"`
+	Client     string                `json:"client"`
+	Config     types.LoadTestConfig  `json:"config"`
+	Report     *types.LoadTestReport `json:"report"`
+	Error      string                `json:"error,omitempty"` // Set when the run failed before it could finish
+	StartedAt  time.Time             `json:"startedAt"`
+	FinishedAt time.Time             `json:"finishedAt"`
+}
+
+// Store keeps runs as one JSON file each in a directory, so the history can
+// be inspected, backed up or shared with ordinary file tools
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// DefaultDir returns the directory runs are stored in when none is configured
+func DefaultDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "roomer-runs"
+	}
+	return filepath.Join(home, ".roomer", "runs")
+}
+
+// New opens the store in dir, creating the directory if needed
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %v", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// NewID returns a new run ID that sorts by creation time
+func NewID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102-150405"), hex.EncodeToString(suffix))
+}
+
+func (s *Store) path(id string) (string, error) {
+	if !validID.MatchString(id) {
+		return "", fmt.Errorf("invalid run ID: %q", id)
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+// Save writes the run to the store, assigning it an ID if it has none
+func (s *Store) Save(run *Run) error {
+	if run.ID == "" {
+		run.ID = NewID()
+	}
+	path, err := s.path(run.ID)
+	if err != nil {
+		return err
+	}
+
+	// Credentials are never written to disk
+	recorded := *run
+	recorded.Config = run.Config.Redacted()
+	data, err := json.MarshalIndent(&recorded, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Write to a temporary file first so readers never see a partial run
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write run: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write run: %v", err)
+	}
+	return nil
+}
+
+// Get loads a single run by ID
+func (s *Store) Get(id string) (*Run, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run: %v", err)
+	}
+
+	var run Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("failed to decode run %s: %v", id, err)
+	}
+	return &run, nil
+}
+
+// List returns every stored run, newest first. Files that can't be read as a
+// run are logged and skipped, so one corrupt file doesn't hide the history.
+func (s *Store) List() ([]*Run, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %v", err)
+	}
+
+	var runs []*Run
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		run, err := s.Get(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			log.Printf("skipping %s: %v", filepath.Join(s.dir, entry.Name()), err)
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	return runs, nil
+}
+
+// Delete removes a run from the store
+func (s *Store) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete run: %v", err)
+	}
+	return nil
+}
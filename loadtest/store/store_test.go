@@ -0,0 +1,187 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cursor-roomer/loadtest/types"
+)
+
+func newTestStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "runs")
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s, dir
+}
+
+func TestSaveAndGet(t *testing.T) {
+	s, dir := newTestStore(t)
+	started := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	run := &Run{
+		Client:     "native",
+		Config:     types.LoadTestConfig{URL: "http://example.com/", Goroutines: 5},
+		Report:     &types.LoadTestReport{MaxConcurrency: 40, MaxRPS: 812.5, StopReason: types.StopLatencyThreshold},
+		StartedAt:  started,
+		FinishedAt: started.Add(time.Minute),
+	}
+	if err := s.Save(run); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if run.ID == "" {
+		t.Fatal("Save didn't assign an ID")
+	}
+	if _, err := os.Stat(filepath.Join(dir, run.ID+".json")); err != nil {
+		t.Errorf("run file: %v", err)
+	}
+
+	got, err := s.Get(run.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Client != "native" || got.Config.URL != "http://example.com/" || got.Config.Goroutines != 5 ||
+		!got.StartedAt.Equal(started) || !got.FinishedAt.Equal(started.Add(time.Minute)) {
+		t.Errorf("run = %+v, want it as saved", got)
+	}
+	if got.Report == nil || got.Report.MaxConcurrency != 40 || got.Report.MaxRPS != 812.5 || got.Report.StopReason != types.StopLatencyThreshold {
+		t.Errorf("report = %+v, want it as saved", got.Report)
+	}
+
+	// Saving again with the same ID replaces the run
+	run.Error = "interrupted"
+	if err := s.Save(run); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if got, err := s.Get(run.ID); err != nil || got.Error != "interrupted" {
+		t.Errorf("Get = %+v, %v; want the run replaced", got, err)
+	}
+}
+
+func TestSaveRedactsCredentials(t *testing.T) {
+	s, dir := newTestStore(t)
+	run := &Run{Config: types.LoadTestConfig{
+		URL:         "http://example.com/",
+		BearerToken: "s3cret-token",
+		BasicAuth:   "alice:s3cret-password",
+		Cookies:     map[string]string{"session": "s3cret-cookie"},
+		Headers:     map[string]string{"X-Api-Key": "s3cret-key", "X-Tenant": "acme"},
+		Scenario:    []types.Request{{URL: "http://example.com/", Headers: map[string]string{"authorization": "Bearer s3cret-scenario"}}},
+		Feeder:      &types.Feeder{Mode: "sequential", Fields: []string{"token"}, Rows: [][]string{{"s3cret-row"}}},
+	}}
+	if err := s.Save(run); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, run.ID+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cret") {
+		t.Errorf("the run file holds a credential:\n%s", data)
+	}
+	if !strings.Contains(string(data), `"alice:[redacted]"`) || !strings.Contains(string(data), `"acme"`) {
+		t.Errorf("the run file lost what isn't secret:\n%s", data)
+	}
+	// The caller's run keeps its credentials
+	if run.Config.BearerToken != "s3cret-token" || run.Config.Headers["X-Api-Key"] != "s3cret-key" {
+		t.Errorf("Save redacted the caller's config: %+v", run.Config)
+	}
+}
+
+func TestList(t *testing.T) {
+	s, dir := newTestStore(t)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// Saved out of order, as IDs needn't sort by start time
+	for _, run := range []*Run{
+		{ID: "middle", StartedAt: base.Add(time.Hour)},
+		{ID: "oldest", StartedAt: base},
+		{ID: "newest", StartedAt: base.Add(2 * time.Hour)},
+	} {
+		if err := s.Save(run); err != nil {
+			t.Fatalf("Save %s: %v", run.ID, err)
+		}
+	}
+	// Files that aren't runs are ignored, and corrupt ones skipped
+	for name, content := range map[string]string{
+		"notes.txt":        "not a run",
+		"corrupt.json":     "{",
+		"bad name.json":    "{}",
+		"partial.json.tmp": "{",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "archive.json"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var ids []string
+	for _, run := range runs {
+		ids = append(ids, run.ID)
+	}
+	if strings.Join(ids, ",") != "newest,middle,oldest" {
+		t.Errorf("List = %v, want the three runs newest first", ids)
+	}
+}
+
+func TestListEmpty(t *testing.T) {
+	s, _ := newTestStore(t)
+	runs, err := s.List()
+	if err != nil || len(runs) != 0 {
+		t.Errorf("List = %v, %v; want no runs", runs, err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	s, _ := newTestStore(t)
+	run := &Run{Client: "native"}
+	if err := s.Save(run); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := s.Delete(run.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(run.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(run.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestInvalidID(t *testing.T) {
+	s, _ := newTestStore(t)
+	// IDs can't reach outside the store directory
+	for _, id := range []string{"../escape", "a/b", "", "run.json"} {
+		if _, err := s.Get(id); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want the ID rejected", id, err)
+		}
+		if err := s.Delete(id); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Delete(%q) = %v, want the ID rejected", id, err)
+		}
+		if err := s.Save(&Run{ID: id}); id != "" && err == nil {
+			t.Errorf("Save(%q) succeeded, want the ID rejected", id)
+		}
+	}
+}
+
+func TestNewID(t *testing.T) {
+	id := NewID()
+	if !validID.MatchString(id) || len(id) != len("20060102-150405-abcdef") {
+		t.Errorf("NewID = %q, want a timestamp and a random suffix", id)
+	}
+	if other := NewID(); other == id {
+		t.Errorf("NewID returned %q twice", id)
+	}
+}
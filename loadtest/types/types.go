@@ -2,29 +2,438 @@ package types
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
 	"time"
 )
 
 // LoadTestConfig contains the configuration for a load test
 type LoadTestConfig struct {
-	URL                string
-	Goroutines         int
-	Duration           time.Duration
-	MaxLatencyIncrease float64
-	MinRpsIncrease     float64
-	Debug              bool
-	Ctx                context.Context
-	Method             string    // HTTP method (GET, POST, etc.)
-	Body               string    // Request body for POST requests
+	URL                string            `json:"url"`
+	Goroutines         int               `json:"goroutines"`
+	Duration           time.Duration     `json:"duration"`
+	MaxLatencyIncrease float64           `json:"maxLatencyIncrease"`
+	MinRpsIncrease     float64           `json:"minRpsIncrease"`
+	MaxErrorRate       float64           `json:"maxErrorRate"` // Stop when more than this percentage of requests fail (0 disables the check)
+	Debug              bool              `json:"debug"`
+	Ctx                context.Context   `json:"-"`
+	Method             string            `json:"method"`                     // HTTP method (GET, POST, etc.)
+	Body               string            `json:"body"`                       // Request body for POST requests (JSON request message for ghz)
+	Headers            map[string]string `json:"headers"`                    // Extra request headers; Content-Type defaults to application/json
+	ContentType        string            `json:"contentType,omitempty"`      // Content-Type of the request body, application/json when empty
+	BearerToken        string            `json:"bearerToken,omitempty"`      // Sent as "Authorization: Bearer <token>"
+	BasicAuth          string            `json:"basicAuth,omitempty"`        // user:password for HTTP basic auth
+	Cookies            map[string]string `json:"cookies,omitempty"`          // Sent in the Cookie header
+	Proto              string            `json:"proto"`                      // Path to the .proto file for ghz; server reflection is used when empty
+	Call               string            `json:"call"`                       // Fully-qualified gRPC method for ghz (package.Service/Method)
+	TLS                bool              `json:"tls,omitempty"`              // Connect to the gRPC server over TLS rather than plaintext; implied by a grpcs:// URL
+	SkipTLSVerify      bool              `json:"skipTlsVerify,omitempty"`    // Accept any gRPC server certificate, e.g. a self-signed one
+	Warmup             time.Duration     `json:"warmup"`                     // Load applied at the start of every iteration and left out of its results
+	Cooldown           time.Duration     `json:"cooldown,omitempty"`         // Idle time before every iteration and trial after the baseline, letting the system settle
+	Trials             int               `json:"trials,omitempty"`           // Times each level is run; every threshold and SLO then needs the whole 95% CI to breach
+	CorrectedLatency   bool              `json:"correctedLatency,omitempty"` // Check the P90 threshold and latency SLOs against the percentiles corrected for coordinated omission
+	Scaling            ScalingConfig     `json:"scaling"`
+	SLOs               []SLO             `json:"slos"`                  // Absolute limits checked on every iteration alongside the relative thresholds
+	Scenario           []Request         `json:"scenario,omitempty"`    // Weighted mix of requests sent instead of URL, Method and Body
+	Feeder             *Feeder           `json:"feeder,omitempty"`      // Rows filling the {{.field}} templates in URLs, headers and bodies
+	Model              string            `json:"model,omitempty"`       // closed (default) ramps Goroutines, open ramps ArrivalRate whether or not the service keeps up
+	ArrivalRate        int               `json:"arrivalRate,omitempty"` // Requests started per second in the open model
+	MaxVUs             int               `json:"maxVUs,omitempty"`      // Most requests in flight in the open model, further arrivals are dropped (default DefaultMaxVUs)
+	MaxDropped         float64           `json:"maxDropped,omitempty"`  // Stop when more than this percentage of arrivals are dropped (0 disables the check)
+}
+
+// Load models of a test
+const (
+	ModelClosed = "closed"
+	ModelOpen   = "open"
+)
+
+// DefaultMaxVUs caps the requests in flight in the open model when MaxVUs is not set
+const DefaultMaxVUs = 1000
+
+// Open reports whether the test runs the open model
+func (c LoadTestConfig) Open() bool {
+	return c.Model == ModelOpen
+}
+
+// VUs returns the most virtual users the test runs at once: Goroutines in
+// the closed model, MaxVUs in the open one
+func (c LoadTestConfig) VUs() int {
+	if !c.Open() {
+		return c.Goroutines
+	}
+	if c.MaxVUs > 0 {
+		return c.MaxVUs
+	}
+	return DefaultMaxVUs
+}
+
+// Feeder holds the rows of a CSV or JSON file. Every request takes a row and
+// fills the {{.field}} references in its URL, headers and body from it, so
+// requests differ the way real traffic does.
+type Feeder struct {
+	File   string     `json:"file,omitempty"` // Where the rows were read from, empty when given inline
+	Mode   string     `json:"mode"`           // sequential, random or unique
+	Fields []string   `json:"fields"`
+	Rows   [][]string `json:"-"` // Not recorded, the rows can hold credentials such as user tokens
+}
+
+// Request is one request of a scenario. Each virtual user picks the next
+// request to send at random, in proportion to the weights.
+type Request struct {
+	Name    string            `json:"name"` // Label of the request in the per-endpoint results
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Body    string            `json:"body,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"` // Added to, and override, the config's headers
+	Weight  float64           `json:"weight"`            // Relative share of the traffic
+}
+
+// redacted replaces secrets when a config is recorded or shown
+const redacted = "[redacted]"
+
+// secretHeaders are headers whose values are credentials
+var secretHeaders = map[string]bool{"Authorization": true, "Proxy-Authorization": true, "Cookie": true, "X-Api-Key": true}
+
+// RequestHeaders returns the headers every request of the test carries: the
+// content type, the configured headers, then the auth and cookie settings.
+// Names are canonicalized so settings for the same header don't clash.
+func (c LoadTestConfig) RequestHeaders() map[string]string {
+	headers := map[string]string{"Content-Type": "application/json"}
+	if c.ContentType != "" {
+		headers["Content-Type"] = c.ContentType
+	}
+	for name, value := range c.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(name)] = value
+	}
+
+	if c.BearerToken != "" {
+		headers["Authorization"] = "Bearer " + c.BearerToken
+	} else if c.BasicAuth != "" {
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.BasicAuth))
+	}
+
+	if len(c.Cookies) > 0 {
+		names := make([]string, 0, len(c.Cookies))
+		for name := range c.Cookies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		cookies := make([]string, 0, len(names)+1)
+		if existing := headers["Cookie"]; existing != "" {
+			cookies = append(cookies, existing)
+		}
+		for _, name := range names {
+			cookies = append(cookies, name+"="+c.Cookies[name])
+		}
+		headers["Cookie"] = strings.Join(cookies, "; ")
+	}
+	return headers
+}
+
+// Requests returns the requests the test sends: the scenario, or else the
+// single request described by URL, Method and Body. Methods are uppercased
+// and each request's headers are merged over the config's.
+func (c LoadTestConfig) Requests() []Request {
+	scenario := c.Scenario
+	if len(scenario) == 0 {
+		scenario = []Request{{Method: c.Method, URL: c.URL, Body: c.Body, Weight: 1}}
+	}
+
+	shared := c.RequestHeaders()
+	requests := make([]Request, len(scenario))
+	for i, request := range scenario {
+		request.Method = strings.ToUpper(request.Method)
+		if request.Method == "" {
+			request.Method = "GET"
+		}
+		if request.Name == "" {
+			request.Name = request.Method + " " + request.URL
+		}
+		headers := make(map[string]string, len(shared)+len(request.Headers))
+		for name, value := range shared {
+			headers[name] = value
+		}
+		for name, value := range request.Headers {
+			headers[textproto.CanonicalMIMEHeaderKey(name)] = value
+		}
+		request.Headers = headers
+		requests[i] = request
+	}
+	return requests
+}
+
+// Redacted returns a copy of the config with credentials replaced, for
+// recording runs and showing them to other users
+func (c LoadTestConfig) Redacted() LoadTestConfig {
+	if c.BearerToken != "" {
+		c.BearerToken = redacted
+	}
+	if c.BasicAuth != "" {
+		user, _, _ := strings.Cut(c.BasicAuth, ":")
+		c.BasicAuth = user + ":" + redacted
+	}
+	if len(c.Cookies) > 0 {
+		cookies := make(map[string]string, len(c.Cookies))
+		for name := range c.Cookies {
+			cookies[name] = redacted
+		}
+		c.Cookies = cookies
+	}
+	c.Headers = redactHeaders(c.Headers)
+	if len(c.Scenario) > 0 {
+		scenario := make([]Request, len(c.Scenario))
+		for i, request := range c.Scenario {
+			request.Headers = redactHeaders(request.Headers)
+			scenario[i] = request
+		}
+		c.Scenario = scenario
+	}
+	return c
+}
+
+func redactHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return headers
+	}
+	redactedHeaders := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if secretHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+			value = redacted
+		}
+		redactedHeaders[name] = value
+	}
+	return redactedHeaders
+}
+
+// ScalingConfig selects how the runner picks the concurrency of each iteration
+type ScalingConfig struct {
+	Strategy   string  `json:"strategy"`   // geometric (default), linear, list or binary
+	Factor     float64 `json:"factor"`     // Growth factor for geometric scaling (default 1.5)
+	Step       int     `json:"step"`       // VUs added per step for linear scaling
+	Levels     []int   `json:"levels"`     // Explicit VU counts for list scaling
+	Low        int     `json:"low"`        // Known-good VU count for binary search
+	High       int     `json:"high"`       // Known-bad VU count for binary search
+	Resolution int     `json:"resolution"` // Binary search stops once the gap is no larger than this (default 1)
+	Refine     bool    `json:"refine"`     // Bisect between the last passing and first failing level after a breach
+}
+
+// SLO is an absolute limit on one metric of an iteration, e.g. p99 < 250ms
+type SLO struct {
+	Metric    string  `json:"metric"`    // p50, p75, p90, p99, rps or error_rate
+	Operator  string  `json:"operator"`  // <, <=, > or >=
+	Threshold float64 `json:"threshold"` // Milliseconds for percentiles, requests/sec for rps, percent for error_rate
+}
+
+func (s SLO) String() string {
+	switch s.Metric {
+	case "p50", "p75", "p90", "p99":
+		return fmt.Sprintf("%s %s %gms", s.Metric, s.Operator, s.Threshold)
+	case "error_rate":
+		return fmt.Sprintf("%s %s %g%%", s.Metric, s.Operator, s.Threshold)
+	default:
+		return fmt.Sprintf("%s %s %g", s.Metric, s.Operator, s.Threshold)
+	}
 }
 
 // LoadTestResult contains the results of a load test
 type LoadTestResult struct {
-	RPS    float64
-	P50    float64
-	P75    float64
-	P90    float64
-	P99    float64
+	RPS              float64          `json:"rps"`
+	P50              float64          `json:"p50"`
+	P75              float64          `json:"p75"`
+	P90              float64          `json:"p90"`
+	P99              float64          `json:"p99"`
+	TotalRequests    int64            `json:"totalRequests,omitempty"`  // Number of requests sent, when the client reports it
+	FailedRequests   int64            `json:"failedRequests,omitempty"` // Requests that got an error status, timed out or hit a socket error
+	ErrorRate        float64          `json:"errorRate"`                // Percentage of failed requests
+	StatusCodes      map[string]int64 `json:"statusCodes,omitempty"`    // Responses by exact code (native, wrk), class such as "5xx" (k6) or gRPC status (ghz)
+	Timeouts         int64            `json:"timeouts,omitempty"`       // Requests that timed out
+	SocketErrors     int64            `json:"socketErrors,omitempty"`   // Connect, read and write errors
+	ChecksPassed     int64            `json:"checksPassed,omitempty"`   // Number of passed response checks (k6 only)
+	ChecksFailed     int64            `json:"checksFailed,omitempty"`   // Number of failed response checks (k6 only)
+	Endpoints        []EndpointResult `json:"endpoints,omitempty"`      // Per-request breakdown of a scenario, in scenario order
+	Dropped          int64            `json:"dropped,omitempty"`        // Open model: arrivals never sent because every VU was busy
+	Late             int64            `json:"late,omitempty"`           // Open model: requests sent well after they were due (native only)
+	CorrectedP50     float64          `json:"correctedP50,omitempty"`   // Measured from when the request was due, so stalls count (coordinated omission); 0 if unknown
+	CorrectedP75     float64          `json:"correctedP75,omitempty"`
+	CorrectedP90     float64          `json:"correctedP90,omitempty"`
+	CorrectedP99     float64          `json:"correctedP99,omitempty"`
+	ClampedLatencies int64            `json:"clampedLatencies,omitempty"` // Native latencies past the histogram range, recorded at its maximum
+	Trials           int              `json:"trials,omitempty"`           // Trials summed (counts) or averaged (rates, latencies) into this result
+	RPSInterval      *Interval        `json:"rpsInterval,omitempty"`      // Spread of the RPS over the trials
+	P90Interval      *Interval        `json:"p90Interval,omitempty"`      // Spread of the P90 the thresholds are checked against
+}
+
+// Interval is the mean of a metric over repeated trials with its sample
+// standard deviation and 95% confidence interval
+type Interval struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stdDev"`
+	Low    float64 `json:"low"`
+	High   float64 `json:"high"`
+}
+
+// Corrected reports whether the result has latencies corrected for
+// coordinated omission
+func (r *LoadTestResult) Corrected() bool {
+	return r.CorrectedP99 > 0
+}
+
+// Percentile returns the 50th, 75th, 90th or 99th percentile latency, from
+// the corrected series when corrected is set and the measured one otherwise
+func (r *LoadTestResult) Percentile(p int, corrected bool) float64 {
+	switch {
+	case p == 50 && corrected:
+		return r.CorrectedP50
+	case p == 50:
+		return r.P50
+	case p == 75 && corrected:
+		return r.CorrectedP75
+	case p == 75:
+		return r.P75
+	case p == 90 && corrected:
+		return r.CorrectedP90
+	case p == 90:
+		return r.P90
+	case p == 99 && corrected:
+		return r.CorrectedP99
+	default:
+		return r.P99
+	}
+}
+
+// DroppedRate returns the percentage of arrivals that were dropped
+func (r *LoadTestResult) DroppedRate() float64 {
+	if r.Dropped == 0 {
+		return 0
+	}
+	return float64(r.Dropped) / float64(r.TotalRequests+r.Dropped) * 100
+}
+
+// EndpointResult is the part of a result for one request of a scenario.
+// Latencies are zero when the client can't attribute them to a request (wrk).
+type EndpointResult struct {
+	Name           string  `json:"name"`
+	Requests       int64   `json:"requests"`
+	FailedRequests int64   `json:"failedRequests,omitempty"`
+	ErrorRate      float64 `json:"errorRate"`
+	RPS            float64 `json:"rps"`
+	P50            float64 `json:"p50"`
+	P75            float64 `json:"p75"`
+	P90            float64 `json:"p90"`
+	P99            float64 `json:"p99"`
+}
+
+// StopReason describes why the runner stopped searching for more capacity
+type StopReason string
+
+const (
+	StopLatencyThreshold  StopReason = "latency_threshold"  // P90 latency grew past MaxLatencyIncrease
+	StopRpsThreshold      StopReason = "rps_threshold"      // RPS grew by less than MinRpsIncrease
+	StopSLOBreach         StopReason = "slo_breach"         // An absolute SLO rule was violated
+	StopErrorRate         StopReason = "error_rate"         // The error rate exceeded MaxErrorRate
+	StopDropped           StopReason = "dropped"            // More arrivals were dropped than MaxDropped allows
+	StopSearchConverged   StopReason = "search_converged"   // A binary search narrowed to its resolution
+	StopNoCapacity        StopReason = "no_capacity"        // Every level a binary search tried breached, so no capacity above the baseline was measured
+	StopStrategyExhausted StopReason = "strategy_exhausted" // The scaling strategy ran out of levels
+	StopCancelled         StopReason = "cancelled"          // The test was cancelled by the user
+	StopError             StopReason = "error"              // The load test client failed
+)
+
+// IterationResult is the outcome of a single step of the capacity search
+type IterationResult struct {
+	VUs         int             `json:"vus"`
+	Rate        int             `json:"rate,omitempty"` // Arrival rate of an open-model iteration, whose VUs is 0
+	Result      *LoadTestResult `json:"result"`
+	Breach      StopReason      `json:"breach,omitempty"`      // Threshold this iteration crossed, empty if it passed
+	BreachedSLO *SLO            `json:"breachedSlo,omitempty"` // The rule that tripped when Breach is StopSLOBreach
+}
+
+// LoadTestReport summarises a capacity search run by the runner
+type LoadTestReport struct {
+	Baseline         *LoadTestResult   `json:"baseline"`
+	Iterations       []IterationResult `json:"iterations"`
+	StopReason       StopReason        `json:"stopReason"`
+	StopMessage      string            `json:"stopMessage"`
+	BreachedSLO      *SLO              `json:"breachedSlo,omitempty"`      // The rule that stopped the search, if any
+	MaxConcurrency   int               `json:"maxConcurrency"`             // Highest VU count that passed every threshold
+	MaxArrivalRate   int               `json:"maxArrivalRate,omitempty"`   // Open model: highest arrival rate that passed every threshold
+	MaxRPS           float64           `json:"maxRps"`                     // RPS measured at the capacity
+	MaxRPSInterval   *Interval         `json:"maxRpsInterval,omitempty"`   // Spread of MaxRPS over repeated trials
+	Model            string            `json:"model,omitempty"`            // ModelOpen when the search ramped the arrival rate
+	CorrectedLatency bool              `json:"correctedLatency,omitempty"` // The thresholds, SLOs and knee used the corrected percentiles
+	Scalability      *Scalability      `json:"scalability,omitempty"`      // Model fitted to every level measured, when there are enough
+}
+
+// Scalability explains how a service scales. Throughput is fitted to the
+// Universal Scalability Law, X(N) = λN / (1 + σ(N-1) + κN(N-1)), over the VU
+// counts of the closed model; the open model has no concurrency to fit and
+// only gets the knee.
+type Scalability struct {
+	Fitted          bool    `json:"fitted"`
+	Lambda          float64 `json:"lambda,omitempty"`          // RPS of a single VU
+	Sigma           float64 `json:"sigma,omitempty"`           // Contention: share of the work serialized on locks, queues and pools
+	Kappa           float64 `json:"kappa,omitempty"`           // Coherency: cost of keeping VUs consistent with each other, which makes throughput fall
+	PeakConcurrency float64 `json:"peakConcurrency,omitempty"` // Where throughput peaks, sqrt((1-σ)/κ); 0 when κ = 0 and it levels off at λ/σ
+	PeakRPS         float64 `json:"peakRps,omitempty"`         // Throughput at PeakConcurrency
+	RSquared        float64 `json:"rSquared,omitempty"`        // Share of the RPS variance the fit explains
+	KneeLevel       int     `json:"kneeLevel,omitempty"`       // Level after which P90 latency climbs fastest (Kneedle), 0 without a knee
+	KneeP90         float64 `json:"kneeP90,omitempty"`         // P90 latency at KneeLevel
+}
+
+// Predict returns the throughput the fitted model expects at n VUs
+func (s *Scalability) Predict(n float64) float64 {
+	return s.Lambda * n / (1 + s.Sigma*(n-1) + s.Kappa*n*(n-1))
+}
+
+// Level returns the load an iteration ran at: its arrival rate in the open
+// model, else its VU count
+func (i IterationResult) Level() int {
+	if i.Rate > 0 {
+		return i.Rate
+	}
+	return i.VUs
+}
+
+// Capacity returns the highest level that passed every threshold:
+// MaxArrivalRate in the open model, else MaxConcurrency
+func (r *LoadTestReport) Capacity() int {
+	if r.Model == ModelOpen {
+		return r.MaxArrivalRate
+	}
+	return r.MaxConcurrency
+}
+
+// CapacityUnit names the unit of Capacity
+func (r *LoadTestReport) CapacityUnit() string {
+	if r.Model == ModelOpen {
+		return "requests/s"
+	}
+	return "VUs"
+}
+
+// EventType names a structured event emitted by the runner
+type EventType string
+
+const (
+	EventIterationStarted  EventType = "iteration_started"  // A test at a new VU count is starting
+	EventIterationResult   EventType = "iteration_result"   // A test finished and its results are in
+	EventThresholdBreached EventType = "threshold_breached" // A test crossed one of the stop thresholds
+)
+
+// Event is a structured progress update from the runner
+type Event struct {
+	Type     EventType       `json:"type"`
+	VUs      int             `json:"vus"`
+	Rate     int             `json:"rate,omitempty"`     // Arrival rate of an open-model iteration, whose VUs is 0
+	Baseline bool            `json:"baseline,omitempty"` // The event is about the baseline test at 1 VU or 1 request/s
+	Result   *LoadTestResult `json:"result,omitempty"`   // Set for iteration_result
+	Reason   StopReason      `json:"reason,omitempty"`   // Set for threshold_breached
+	Message  string          `json:"message,omitempty"`  // Set for threshold_breached
+	SLO      *SLO            `json:"slo,omitempty"`      // The rule that tripped, for SLO breaches
 }
 
 // LoadTestClient interface for different load testing tools
@@ -33,7 +442,20 @@ type LoadTestClient interface {
 	Name() string
 }
 
+// ResultClient is implemented by clients that measure in-process and can
+// return a LoadTestResult directly instead of text output to be parsed
+type ResultClient interface {
+	LoadTestClient
+	RunTestResult(config LoadTestConfig) (*LoadTestResult, error)
+}
+
 // OutputHandler interface for handling output
 type OutputHandler interface {
 	WriteLine(line string)
-} 
\ No newline at end of file
+}
+
+// EventHandler is implemented by output handlers that also want structured
+// events alongside the text output
+type EventHandler interface {
+	HandleEvent(event Event)
+}
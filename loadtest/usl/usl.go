@@ -0,0 +1,176 @@
+package usl
+
+import (
+	"math"
+	"sort"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// MinLevels is the fewest distinct levels a report needs to be analyzed
+const MinLevels = 3
+
+// point is what a report measured at one level
+type point struct {
+	level float64
+	rps   float64
+	p90   float64
+}
+
+// Analyze fits the Universal Scalability Law to the throughput of every
+// level the report measured, breached ones included, and finds the knee of
+// its latency curve. It returns nil when there are fewer than MinLevels
+// levels or nothing could be fitted.
+func Analyze(report *types.LoadTestReport) *types.Scalability {
+	points := collect(report)
+	if len(points) < MinLevels {
+		return nil
+	}
+
+	scalability := &types.Scalability{}
+	if report.Model != types.ModelOpen {
+		fit(scalability, points)
+	}
+	scalability.KneeLevel, scalability.KneeP90 = knee(points)
+	if !scalability.Fitted && scalability.KneeLevel == 0 {
+		return nil
+	}
+	return scalability
+}
+
+// collect returns the baseline and iterations of a report in ascending
+// level order. A level measured more than once keeps its last result.
+func collect(report *types.LoadTestReport) []point {
+	byLevel := make(map[int]point)
+	add := func(level int, result *types.LoadTestResult) {
+		if result == nil || result.RPS <= 0 {
+			return
+		}
+		byLevel[level] = point{level: float64(level), rps: result.RPS, p90: result.Percentile(90, report.CorrectedLatency)}
+	}
+	add(1, report.Baseline)
+	for _, iteration := range report.Iterations {
+		add(iteration.Level(), iteration.Result)
+	}
+
+	points := make([]point, 0, len(byLevel))
+	for _, p := range byLevel {
+		points = append(points, p)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].level < points[j].level })
+	return points
+}
+
+// fit sets λ to the single-VU throughput and finds σ and κ by least squares
+// on the linearised law, N·λ/X(N) - 1 = σ(N-1) + κN(N-1). Negative
+// coefficients have no physical meaning, so the unconstrained fit competes
+// with the Amdahl (κ = 0) and coherency-only (σ = 0) fits and the one that
+// predicts the measured throughput best wins.
+func fit(scalability *types.Scalability, points []point) {
+	if points[0].level != 1 {
+		return
+	}
+	lambda := points[0].rps
+
+	// x = N-1 and y = N·λ/X - 1, so that y = (σ+κ)x + κx²
+	var sxx, sxxx, sxxxx, sxy, sxxy float64
+	var xs, ys []float64
+	for _, p := range points[1:] {
+		x := p.level - 1
+		y := p.level*lambda/p.rps - 1
+		xs, ys = append(xs, x), append(ys, y)
+		sxx += x * x
+		sxxx += x * x * x
+		sxxxx += x * x * x * x
+		sxy += x * y
+		sxxy += x * x * y
+	}
+	if sxx == 0 {
+		return
+	}
+
+	var candidates [][2]float64 // σ, κ
+	if det := sxx*sxxxx - sxxx*sxxx; det != 0 {
+		a := (sxy*sxxxx - sxxy*sxxx) / det
+		b := (sxx*sxxy - sxxx*sxy) / det
+		if sigma, kappa := a-b, b; sigma >= 0 && kappa >= 0 {
+			candidates = append(candidates, [2]float64{sigma, kappa})
+		}
+	}
+	candidates = append(candidates, [2]float64{math.Max(0, sxy/sxx), 0})
+	var szz, szy float64 // z = x² + x, so that y = κz when σ = 0
+	for i, x := range xs {
+		z := x*x + x
+		szz += z * z
+		szy += z * ys[i]
+	}
+	candidates = append(candidates, [2]float64{0, math.Max(0, szy/szz)})
+
+	var mean float64
+	for _, p := range points {
+		mean += p.rps
+	}
+	mean /= float64(len(points))
+	best := math.Inf(1)
+	for _, candidate := range candidates {
+		model := types.Scalability{Lambda: lambda, Sigma: candidate[0], Kappa: candidate[1]}
+		var sse float64
+		for _, p := range points {
+			residual := p.rps - model.Predict(p.level)
+			sse += residual * residual
+		}
+		if sse < best {
+			best = sse
+			scalability.Sigma, scalability.Kappa = model.Sigma, model.Kappa
+		}
+	}
+
+	var sst float64
+	for _, p := range points {
+		sst += (p.rps - mean) * (p.rps - mean)
+	}
+	scalability.Fitted = true
+	scalability.Lambda = lambda
+	if sst > 0 {
+		scalability.RSquared = 1 - best/sst
+	}
+
+	switch {
+	case scalability.Sigma >= 1:
+		// Adding VUs never helped
+		scalability.PeakConcurrency, scalability.PeakRPS = 1, lambda
+	case scalability.Kappa > 0:
+		scalability.PeakConcurrency = math.Sqrt((1 - scalability.Sigma) / scalability.Kappa)
+		scalability.PeakRPS = scalability.Predict(scalability.PeakConcurrency)
+	case scalability.Sigma > 0:
+		scalability.PeakRPS = lambda / scalability.Sigma
+	}
+}
+
+// knee finds where P90 latency starts climbing with the Kneedle algorithm:
+// with both axes scaled to [0, 1], the knee of an increasing convex curve is
+// the point furthest below the diagonal. It returns zeros when the curve is
+// flat or bends nowhere in between its ends.
+func knee(points []point) (int, float64) {
+	first, last := points[0], points[len(points)-1]
+	minP90, maxP90 := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		minP90, maxP90 = math.Min(minP90, p.p90), math.Max(maxP90, p.p90)
+	}
+	if maxP90 <= minP90 || last.level <= first.level {
+		return 0, 0
+	}
+
+	index, distance := -1, 0.0
+	for i, p := range points {
+		x := (p.level - first.level) / (last.level - first.level)
+		y := (p.p90 - minP90) / (maxP90 - minP90)
+		if d := x - y; d > distance {
+			index, distance = i, d
+		}
+	}
+	if index <= 0 || index == len(points)-1 {
+		return 0, 0
+	}
+	return int(points[index].level), points[index].p90
+}
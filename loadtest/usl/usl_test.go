@@ -0,0 +1,180 @@
+package usl
+
+import (
+	"math"
+	"testing"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// report returns a closed-model report with the baseline at the first level
+// and an iteration at every other one
+func report(levels []int, rps, p90 func(n float64) float64) *types.LoadTestReport {
+	report := &types.LoadTestReport{}
+	for _, level := range levels {
+		n := float64(level)
+		result := &types.LoadTestResult{RPS: rps(n), P90: p90(n)}
+		if level == 1 {
+			report.Baseline = result
+			continue
+		}
+		report.Iterations = append(report.Iterations, types.IterationResult{VUs: level, Result: result})
+	}
+	return report
+}
+
+// law returns the throughput the Universal Scalability Law predicts
+func law(lambda, sigma, kappa float64) func(n float64) float64 {
+	model := types.Scalability{Lambda: lambda, Sigma: sigma, Kappa: kappa}
+	return model.Predict
+}
+
+func flat(value float64) func(n float64) float64 {
+	return func(float64) float64 { return value }
+}
+
+func near(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance
+}
+
+func TestAnalyzeFit(t *testing.T) {
+	levels := []int{1, 2, 4, 8, 16, 32, 64}
+	tests := []struct {
+		name      string
+		rps       func(n float64) float64
+		sigma     float64
+		kappa     float64
+		peak      float64 // PeakConcurrency
+		peakRPS   float64
+		rSquared  float64
+		tolerance float64
+	}{
+		{name: "contention and coherency", rps: law(100, 0.05, 0.001), sigma: 0.05, kappa: 0.001,
+			peak: math.Sqrt(0.95 / 0.001), peakRPS: law(100, 0.05, 0.001)(math.Sqrt(0.95 / 0.001)), rSquared: 1, tolerance: 1e-6},
+		// Without coherency costs throughput levels off at λ/σ
+		{name: "contention only", rps: law(100, 0.1, 0), sigma: 0.1, peakRPS: 1000, rSquared: 1, tolerance: 1e-6},
+		{name: "coherency only", rps: law(100, 0, 0.002), kappa: 0.002,
+			peak: math.Sqrt(1 / 0.002), peakRPS: law(100, 0, 0.002)(math.Sqrt(1 / 0.002)), rSquared: 1, tolerance: 1e-6},
+		// Linear scaling has nothing to fit but λ
+		{name: "linear", rps: func(n float64) float64 { return 100 * n }, rSquared: 1, tolerance: 1e-9},
+		// Adding VUs never helped, so the peak is the baseline
+		{name: "flat throughput", rps: flat(100), sigma: 1, peak: 1, peakRPS: 100, tolerance: 1e-9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scalability := Analyze(report(levels, tt.rps, flat(10)))
+			if scalability == nil || !scalability.Fitted {
+				t.Fatalf("expected a fit, got %+v", scalability)
+			}
+			if scalability.Lambda != tt.rps(1) {
+				t.Errorf("λ = %v, want the baseline's %v", scalability.Lambda, tt.rps(1))
+			}
+			if !near(scalability.Sigma, tt.sigma, tt.tolerance) || !near(scalability.Kappa, tt.kappa, tt.tolerance) {
+				t.Errorf("σ = %v, κ = %v; want %v, %v", scalability.Sigma, scalability.Kappa, tt.sigma, tt.kappa)
+			}
+			if !near(scalability.PeakConcurrency, tt.peak, 1e-3) || !near(scalability.PeakRPS, tt.peakRPS, 1e-3) {
+				t.Errorf("peak = %v VUs at %v RPS, want %v at %v", scalability.PeakConcurrency, scalability.PeakRPS, tt.peak, tt.peakRPS)
+			}
+			if !near(scalability.RSquared, tt.rSquared, 1e-6) {
+				t.Errorf("R² = %v, want %v", scalability.RSquared, tt.rSquared)
+			}
+		})
+	}
+}
+
+func TestAnalyzeFitNoisy(t *testing.T) {
+	// Throughput that rises, falls and rises again fits no law exactly, but
+	// the coefficients stay physical and the fit explains some of it
+	rps := map[float64]float64{1: 100, 2: 190, 4: 330, 8: 300, 16: 520, 32: 410}
+	scalability := Analyze(report([]int{1, 2, 4, 8, 16, 32}, func(n float64) float64 { return rps[n] }, flat(10)))
+	if scalability == nil || !scalability.Fitted {
+		t.Fatalf("expected a fit, got %+v", scalability)
+	}
+	if scalability.Sigma < 0 || scalability.Kappa < 0 {
+		t.Errorf("σ = %v, κ = %v; want neither negative", scalability.Sigma, scalability.Kappa)
+	}
+	if scalability.RSquared <= 0 || scalability.RSquared >= 1 {
+		t.Errorf("R² = %v, want a partial fit", scalability.RSquared)
+	}
+}
+
+func TestAnalyzeWithoutFit(t *testing.T) {
+	linear := func(n float64) float64 { return 100 * n }
+	tests := []struct {
+		name   string
+		report *types.LoadTestReport
+	}{
+		{name: "no levels", report: &types.LoadTestReport{}},
+		{name: "two levels", report: report([]int{1, 2}, linear, linear)},
+		// Only a baseline and a level measured twice
+		{name: "repeated level", report: report([]int{1, 2, 2}, linear, linear)},
+		{name: "no responses", report: report([]int{1, 2, 4, 8}, flat(0), linear)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if scalability := Analyze(tt.report); scalability != nil {
+				t.Errorf("expected no analysis, got %+v", scalability)
+			}
+		})
+	}
+}
+
+func TestAnalyzeNeedsBaselineToFit(t *testing.T) {
+	// Without a single-VU throughput there is no λ, so only the knee is found
+	r := report([]int{1, 2, 4, 8, 16}, law(100, 0.05, 0.001), func(n float64) float64 { return n * n })
+	r.Baseline = nil
+	scalability := Analyze(r)
+	if scalability == nil || scalability.Fitted {
+		t.Fatalf("expected the knee alone, got %+v", scalability)
+	}
+	if scalability.KneeLevel != 8 {
+		t.Errorf("knee = %d, want 8", scalability.KneeLevel)
+	}
+}
+
+func TestAnalyzeOpenModel(t *testing.T) {
+	r := &types.LoadTestReport{Model: types.ModelOpen, Baseline: &types.LoadTestResult{RPS: 1, P90: 10}}
+	for i, p90 := range []float64{10, 10, 10, 10, 100, 200, 300, 400} {
+		rate := 100 * (i + 1)
+		r.Iterations = append(r.Iterations, types.IterationResult{Rate: rate, Result: &types.LoadTestResult{RPS: float64(rate), P90: p90}})
+	}
+	scalability := Analyze(r)
+	if scalability == nil || scalability.Fitted {
+		t.Fatalf("expected the knee alone, got %+v", scalability)
+	}
+	if scalability.KneeLevel != 400 || scalability.KneeP90 != 10 {
+		t.Errorf("knee = %d requests/s at %vms, want 400 at 10ms", scalability.KneeLevel, scalability.KneeP90)
+	}
+}
+
+func TestKnee(t *testing.T) {
+	tests := []struct {
+		name     string
+		p90      []float64 // At levels 1, 2, 3 and so on
+		wantKnee int
+		wantP90  float64
+	}{
+		// Flat until 6 VUs, then climbing steeply
+		{name: "hockey stick", p90: []float64{10, 10, 10, 10, 10, 10, 100, 200, 300, 400}, wantKnee: 6, wantP90: 10},
+		{name: "gradual then steep", p90: []float64{10, 11, 12, 13, 14, 30, 60, 120}, wantKnee: 5, wantP90: 14},
+		{name: "flat", p90: []float64{10, 10, 10, 10}},
+		// A straight line bends nowhere
+		{name: "linear", p90: []float64{10, 20, 30, 40, 50}},
+		// Climbing first and levelling off is concave, with no knee
+		{name: "concave", p90: []float64{10, 100, 150, 170, 175}},
+		// The highest latency in the middle still leaves the knee before it
+		{name: "non-monotonic", p90: []float64{10, 10, 10, 200, 120, 150}, wantKnee: 3, wantP90: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := make([]point, len(tt.p90))
+			for i, p90 := range tt.p90 {
+				points[i] = point{level: float64(i + 1), rps: 100, p90: p90}
+			}
+			level, p90 := knee(points)
+			if level != tt.wantKnee || p90 != tt.wantP90 {
+				t.Errorf("knee = %d at %vms, want %d at %vms", level, p90, tt.wantKnee, tt.wantP90)
+			}
+		})
+	}
+}
@@ -0,0 +1,449 @@
+package webui
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cursor-roomer/loadtest/feeder"
+	"cursor-roomer/loadtest/runner"
+	"cursor-roomer/loadtest/store"
+	"cursor-roomer/loadtest/types"
+)
+
+// apiPrefix is the root of the versioned JSON API
+const apiPrefix = "/api/v1"
+
+// RunRequest describes a load test to start. It is accepted by /run-test and
+// POST /api/v1/runs.
+type RunRequest struct {
+	URL                string  `json:"url" openapi:"required"`
+	Goroutines         int     `json:"goroutines"`
+	Duration           string  `json:"duration" openapi:"required"` // Go duration string such as "30s"
+	Warmup             string  `json:"warmup"`                      // Load applied at the start of every iteration and left out of its results
+	Cooldown           string  `json:"cooldown"`                    // Idle time before every iteration and trial after the baseline
+	Trials             int     `json:"trials"`                      // Times each level is run, 1 when omitted
+	MaxLatencyIncrease float64 `json:"maxLatencyIncrease"`
+	MinRpsIncrease     float64 `json:"minRpsIncrease"`
+	MaxErrorRate       float64 `json:"maxErrorRate"`
+	CorrectedLatency   bool    `json:"correctedLatency"` // Check the P90 threshold and latency SLOs against corrected latencies
+	Debug              bool    `json:"debug"`
+	Method             string  `json:"method"`
+	Body               string  `json:"body"`
+	ContentType        string  `json:"contentType"` // application/json when empty
+	Headers            string  `json:"headers"`     // One "Name: value" header per line
+	BearerToken        string  `json:"bearerToken"`
+	BasicAuth          string  `json:"basicAuth"`  // user:password
+	Cookies            string  `json:"cookies"`    // Cookie header form, e.g. "session=abc; theme=dark"
+	ClientType         string  `json:"clientType"` // k6 (default), wrk, ghz or native
+	Proto              string  `json:"proto"`      // .proto file name inside the server's proto directory
+	Call               string  `json:"call"`
+	TLS                bool    `json:"tls"` // Connect to the gRPC server over TLS
+	SkipTLSVerify      bool    `json:"skipTlsVerify"`
+	Scaling            string  `json:"scaling"`
+	ScaleFactor        float64 `json:"scaleFactor"`
+	ScaleStep          int     `json:"scaleStep"`
+	ScaleLevels        string  `json:"scaleLevels"` // Comma-separated VU counts
+	ScaleLow           int     `json:"scaleLow"`
+	ScaleHigh          int     `json:"scaleHigh"`
+	ScaleResolution    int     `json:"scaleResolution"`
+	Refine             bool    `json:"refine"`
+	SLOs               string  `json:"slos"`        // Comma-separated SLO rules such as "p99<250ms"
+	Scenario           string  `json:"scenario"`    // One "weight METHOD url [body]" request per line; url may be a path
+	Feeder             string  `json:"feeder"`      // CSV with a header row whose rows fill {{.field}} references
+	FeederMode         string  `json:"feederMode"`  // sequential (default), random or unique
+	Model              string  `json:"model"`       // closed (default) ramps VUs, open ramps the arrival rate
+	ArrivalRate        int     `json:"arrivalRate"` // Open model: requests/s of the first step after the baseline
+	MaxVUs             int     `json:"maxVUs"`      // Open model: most requests in flight before arrivals are dropped
+	MaxDropped         float64 `json:"maxDropped"`  // Open model: percentage of arrivals that may be dropped, 0 disables the check
+}
+
+// LoadTestConfig validates the request and builds the config to run it with.
+// It defaults ClientType to k6. Proto files are looked up in protoDir, and
+// rejected when it is empty.
+func (req *RunRequest) LoadTestConfig(protoDir string) (types.LoadTestConfig, error) {
+	if req.URL == "" {
+		return types.LoadTestConfig{}, fmt.Errorf("url is required")
+	}
+	if _, err := url.Parse(req.URL); err != nil {
+		return types.LoadTestConfig{}, fmt.Errorf("invalid URL format")
+	}
+	if req.ClientType == "" {
+		req.ClientType = "k6" // Default to k6 if not specified
+	}
+
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		return types.LoadTestConfig{}, fmt.Errorf("invalid duration format")
+	}
+
+	// Warmup and cooldown are optional, unlike the duration
+	var warmup, cooldown time.Duration
+	for name, phase := range map[string]struct {
+		value  string
+		target *time.Duration
+	}{"warmup": {req.Warmup, &warmup}, "cooldown": {req.Cooldown, &cooldown}} {
+		if phase.value == "" {
+			continue
+		}
+		if *phase.target, err = time.ParseDuration(phase.value); err != nil || *phase.target < 0 {
+			return types.LoadTestConfig{}, fmt.Errorf("invalid %s format", name)
+		}
+	}
+	if req.Trials < 0 {
+		return types.LoadTestConfig{}, fmt.Errorf("trials must not be negative")
+	}
+
+	proto, err := resolveProto(protoDir, req.Proto)
+	if err != nil {
+		return types.LoadTestConfig{}, err
+	}
+
+	levels, err := runner.ParseLevels(req.ScaleLevels)
+	if err != nil {
+		return types.LoadTestConfig{}, err
+	}
+
+	slos, err := runner.ParseSLOs(req.SLOs)
+	if err != nil {
+		return types.LoadTestConfig{}, err
+	}
+
+	headers, err := runner.ParseHeaders(strings.Split(req.Headers, "\n"))
+	if err != nil {
+		return types.LoadTestConfig{}, err
+	}
+
+	cookies, err := runner.ParseCookies(req.Cookies)
+	if err != nil {
+		return types.LoadTestConfig{}, err
+	}
+
+	if err := runner.ValidateAuth(req.BearerToken, req.BasicAuth); err != nil {
+		return types.LoadTestConfig{}, err
+	}
+
+	scenario, err := runner.ParseScenario(strings.Split(req.Scenario, "\n"), req.URL)
+	if err != nil {
+		return types.LoadTestConfig{}, err
+	}
+	if len(scenario) > 0 {
+		if err := runner.ValidateScenario(scenario); err != nil {
+			return types.LoadTestConfig{}, err
+		}
+	}
+
+	var requestFeeder *types.Feeder
+	if strings.TrimSpace(req.Feeder) != "" {
+		if requestFeeder, err = feeder.Parse(strings.NewReader(req.Feeder), "csv", req.FeederMode); err != nil {
+			return types.LoadTestConfig{}, fmt.Errorf("feeder: %v", err)
+		}
+	}
+
+	config := types.LoadTestConfig{
+		URL:                req.URL,
+		Goroutines:         req.Goroutines,
+		Duration:           duration,
+		Warmup:             warmup,
+		Cooldown:           cooldown,
+		Trials:             req.Trials,
+		MaxLatencyIncrease: req.MaxLatencyIncrease,
+		MinRpsIncrease:     req.MinRpsIncrease,
+		MaxErrorRate:       req.MaxErrorRate,
+		CorrectedLatency:   req.CorrectedLatency,
+		Debug:              req.Debug,
+		Method:             req.Method,
+		Body:               req.Body,
+		Headers:            headers,
+		ContentType:        req.ContentType,
+		BearerToken:        req.BearerToken,
+		BasicAuth:          req.BasicAuth,
+		Cookies:            cookies,
+		Proto:              proto,
+		Call:               req.Call,
+		TLS:                req.TLS,
+		SkipTLSVerify:      req.SkipTLSVerify,
+		Scaling: types.ScalingConfig{
+			Strategy:   req.Scaling,
+			Factor:     req.ScaleFactor,
+			Step:       req.ScaleStep,
+			Levels:     levels,
+			Low:        req.ScaleLow,
+			High:       req.ScaleHigh,
+			Resolution: req.ScaleResolution,
+			Refine:     req.Refine,
+		},
+		SLOs:     slos,
+		Scenario: scenario,
+		Feeder:   requestFeeder,
+	}
+	if req.Model != types.ModelClosed {
+		config.Model = req.Model
+	}
+	if config.Open() {
+		config.ArrivalRate = req.ArrivalRate
+		config.MaxVUs = req.MaxVUs
+		config.MaxDropped = req.MaxDropped
+		if req.ClientType != "k6" && req.ClientType != "native" {
+			return types.LoadTestConfig{}, fmt.Errorf("%s has no arrival-rate mode; use k6 or native for the open model", req.ClientType)
+		}
+	}
+	if err := runner.ValidateModel(config); err != nil {
+		return types.LoadTestConfig{}, err
+	}
+	// ghz fills {{.field}} references in bodies with its own call data
+	if req.ClientType != "ghz" {
+		if err := runner.ValidateTemplates(config); err != nil {
+			return types.LoadTestConfig{}, err
+		}
+	}
+	return config, nil
+}
+
+// RunResults is the outcome of a finished run
+type RunResults struct {
+	ID     string                `json:"id"`
+	Status JobStatus             `json:"status"`
+	Report *types.LoadTestReport `json:"report"`
+	Error  string                `json:"error,omitempty"`
+}
+
+func runResults(info JobInfo) RunResults {
+	return RunResults{ID: info.ID, Status: info.Status, Report: info.Report, Error: info.Error}
+}
+
+// APIError is the body of every API error response
+type APIError struct {
+	Error string `json:"error"`
+}
+
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIError{Error: fmt.Sprintf(format, args...)})
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// resolveProto returns the path of the named .proto file inside dir. Requests
+// come from the network, so they may only name files the server was started
+// to offer, never arbitrary paths on its disk.
+func resolveProto(dir, name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	if dir == "" {
+		return "", fmt.Errorf("proto files are disabled on this server; use server reflection or start it with -proto-dir")
+	}
+	if filepath.IsAbs(name) || !filepath.IsLocal(name) || filepath.Ext(name) != ".proto" {
+		return "", fmt.Errorf("proto must be the relative path of a .proto file in the server's proto directory, got %q", name)
+	}
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("proto directory: %v", err)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(root, name))
+	if err != nil {
+		return "", fmt.Errorf("proto %q not found in the server's proto directory", name)
+	}
+	// A symlink inside the directory may still point out of it
+	if rel, err := filepath.Rel(root, path); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("proto %q is outside the server's proto directory", name)
+	}
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("proto %q is not a file", name)
+	}
+	return path, nil
+}
+
+// handleAPIRuns serves /api/v1/runs: POST starts a run, GET lists them
+func (s *Server) handleAPIRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var req RunRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+		config, err := req.LoadTestConfig(s.protoDir)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		job := s.jobs.Submit(config, req.ClientType)
+		w.Header().Set("Location", apiPrefix+"/runs/"+job.Info().ID)
+		writeAPIJSON(w, http.StatusAccepted, job.Info())
+	case http.MethodGet:
+		runs, err := s.listRuns()
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, runs)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleAPIRun serves /api/v1/runs/{id} and its cancel, results and events
+// sub-resources
+func (s *Server) handleAPIRun(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, apiPrefix+"/runs/"), "/")
+
+	if id == "" {
+		writeAPIError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	var allowed string
+	switch action {
+	case "", "results", "events":
+		allowed = http.MethodGet
+	case "cancel":
+		allowed = http.MethodPost
+	default:
+		writeAPIError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != allowed {
+		w.Header().Set("Allow", allowed)
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if action == "events" {
+		s.handleAPIRunEvents(w, r, id)
+		return
+	}
+
+	info, err := s.getRun(id)
+	if errors.Is(err, ErrJobNotFound) {
+		writeAPIError(w, http.StatusNotFound, "run %s not found", id)
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	switch action {
+	case "":
+		writeAPIJSON(w, http.StatusOK, info)
+	case "cancel":
+		if info.FinishedAt != nil {
+			writeAPIError(w, http.StatusConflict, "run %s already finished", id)
+			return
+		}
+		s.jobs.Cancel(id)
+		info, _ = s.getRun(id)
+		writeAPIJSON(w, http.StatusAccepted, info)
+	case "results":
+		if info.FinishedAt == nil {
+			writeAPIError(w, http.StatusConflict, "run %s has not finished", id)
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, runResults(info))
+	}
+}
+
+// handleAPIRunEvents streams the events of a run as server-sent events. A
+// client that reconnects with Last-Event-ID picks up where it left off. Runs
+// that are only in the history get just their run_finished event.
+func (s *Server) handleAPIRunEvents(w http.ResponseWriter, r *http.Request, id string) {
+	job, err := s.jobs.Get(id)
+	if err != nil {
+		info, err := s.getRun(id)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, "run %s not found", id)
+			return
+		}
+		data, err := json.Marshal(runResults(info))
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		setSSEHeaders(w)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventRunFinished, data)
+		return
+	}
+
+	setSSEHeaders(w)
+	s.streamJob(w, r, job, lastEventID(r))
+}
+
+// getRun returns a run by ID, from the jobs in memory or else the history
+func (s *Server) getRun(id string) (JobInfo, error) {
+	if job, err := s.jobs.Get(id); err == nil {
+		return job.Info(), nil
+	}
+	if s.store == nil {
+		return JobInfo{}, ErrJobNotFound
+	}
+	run, err := s.store.Get(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return JobInfo{}, ErrJobNotFound
+	}
+	if err != nil {
+		return JobInfo{}, err
+	}
+	return jobInfoFromRun(run), nil
+}
+
+// listRuns returns the jobs in memory and the recorded runs, newest first
+func (s *Server) listRuns() ([]JobInfo, error) {
+	infos := s.jobs.List()
+	if s.store == nil {
+		return infos, nil
+	}
+
+	seen := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		seen[info.ID] = true
+	}
+	runs, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		if !seen[run.ID] {
+			infos = append(infos, jobInfoFromRun(run))
+		}
+	}
+	sortJobInfos(infos)
+	return infos, nil
+}
+
+// jobInfoFromRun describes a recorded run the way a finished job is described
+func jobInfoFromRun(run *store.Run) JobInfo {
+	status := JobDone
+	switch {
+	case run.Report != nil && run.Report.StopReason == types.StopCancelled:
+		status = JobCancelled
+	case run.Error != "":
+		status = JobFailed
+	}
+	startedAt, finishedAt := run.StartedAt, run.FinishedAt
+	return JobInfo{
+		ID:         run.ID,
+		Client:     run.Client,
+		Config:     run.Config,
+		Status:     status,
+		Report:     run.Report,
+		Error:      run.Error,
+		CreatedAt:  run.StartedAt,
+		StartedAt:  &startedAt,
+		FinishedAt: &finishedAt,
+	}
+}
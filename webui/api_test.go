@@ -0,0 +1,195 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cursor-roomer/loadtest/types"
+)
+
+func TestResolveProto(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	for _, path := range []string{
+		filepath.Join(dir, "greeter.proto"),
+		filepath.Join(dir, "nested", "health.proto"),
+		filepath.Join(dir, "notes.txt"),
+		filepath.Join(outside, "secret.proto"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(`syntax = "proto3";`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.proto"), filepath.Join(dir, "link.proto")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "dir.proto"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		dir     string
+		proto   string
+		want    string
+		wantErr bool
+	}{
+		{name: "reflection", dir: dir, proto: "", want: ""},
+		{name: "reflection without a directory", dir: "", proto: "", want: ""},
+		{name: "file in the directory", dir: dir, proto: "greeter.proto", want: filepath.Join(root, "greeter.proto")},
+		{name: "nested file", dir: dir, proto: "nested/health.proto", want: filepath.Join(root, "nested", "health.proto")},
+		{name: "no directory configured", dir: "", proto: "greeter.proto", wantErr: true},
+		{name: "absolute path", dir: dir, proto: filepath.Join(dir, "greeter.proto"), wantErr: true},
+		{name: "parent traversal", dir: dir, proto: "../" + filepath.Base(outside) + "/secret.proto", wantErr: true},
+		{name: "traversal through a subdirectory", dir: dir, proto: "nested/../../secret.proto", wantErr: true},
+		{name: "not a proto file", dir: dir, proto: "notes.txt", wantErr: true},
+		{name: "missing file", dir: dir, proto: "missing.proto", wantErr: true},
+		{name: "symlink out of the directory", dir: dir, proto: "link.proto", wantErr: true},
+		{name: "directory", dir: dir, proto: "dir.proto", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveProto(tt.dir, tt.proto)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveProto: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveProto = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// sseEvent is an event as read back from a server-sent event stream
+type sseEvent struct {
+	id   string
+	name string
+	data string
+}
+
+func parseSSE(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		var event sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "id":
+				event.id = value
+			case "event":
+				event.name = value
+			case "data":
+				event.data = value
+			default:
+				t.Fatalf("unexpected line %q in:\n%s", line, body)
+			}
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// getEvents requests the events of a run, resuming after lastID when it is set
+func getEvents(s *Server, id, lastID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, apiPrefix+"/runs/"+id+"/events", nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	w := httptest.NewRecorder()
+	s.handleAPIRun(w, req)
+	return w
+}
+
+func TestRunEventsResume(t *testing.T) {
+	runner := newStubRunner("http://example.test/")
+	s := &Server{jobs: newTestJobManager(t, 1, runner, nil)}
+	job := s.jobs.Submit(types.LoadTestConfig{URL: "http://example.test/"}, "native")
+	waitForStatus(t, job, JobRunning)
+	job.WriteLine("second\nthird")
+	runner.outcomes["http://example.test/"] <- stubOutcome{report: &types.LoadTestReport{}}
+	waitForDone(t, job)
+
+	full := getEvents(s, job.Info().ID, "")
+	if origin := full.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want other sites kept from reading the stream", origin)
+	}
+	all := parseSSE(t, full.Body.String())
+	want := []sseEvent{
+		{id: "1", name: eventLog, data: "Running http://example.test/"},
+		{id: "2", name: eventLog, data: "second"},
+		{id: "3", name: eventLog, data: "third"},
+	}
+	if len(all) != 4 || !reflect.DeepEqual(all[:3], want) || all[3].id != "4" || all[3].name != eventRunFinished {
+		t.Fatalf("events = %+v, want the log lines then %s", all, eventRunFinished)
+	}
+
+	tests := []struct {
+		lastID string
+		want   []sseEvent
+	}{
+		{lastID: "2", want: all[2:]},
+		{lastID: "0", want: all},
+		{lastID: "4", want: nil},
+		// IDs past the end replay nothing, and unreadable ones everything
+		{lastID: "9", want: nil},
+		{lastID: "x", want: all},
+	}
+	for _, tt := range tests {
+		t.Run("after "+tt.lastID, func(t *testing.T) {
+			w := getEvents(s, job.Info().ID, tt.lastID)
+			var got []sseEvent
+			if strings.TrimSpace(w.Body.String()) != "" {
+				got = parseSSE(t, w.Body.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunEventsResumeWhileRunning(t *testing.T) {
+	runner := newStubRunner("http://example.test/")
+	s := &Server{jobs: newTestJobManager(t, 1, runner, nil)}
+	job := s.jobs.Submit(types.LoadTestConfig{URL: "http://example.test/"}, "native")
+	waitForStatus(t, job, JobRunning)
+	job.WriteLine("second")
+
+	// A client that saw the first event reconnects while the run goes on
+	responses := make(chan *httptest.ResponseRecorder)
+	go func() {
+		responses <- getEvents(s, job.Info().ID, "1")
+	}()
+	job.WriteLine("third")
+	runner.outcomes["http://example.test/"] <- stubOutcome{report: &types.LoadTestReport{}}
+
+	select {
+	case w := <-responses:
+		events := parseSSE(t, w.Body.String())
+		if len(events) != 3 || events[0].id != "2" || events[0].data != "second" || events[1].data != "third" || events[2].name != eventRunFinished {
+			t.Errorf("events = %+v, want those after the first through %s", events, eventRunFinished)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream didn't end with the run")
+	}
+}
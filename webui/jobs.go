@@ -0,0 +1,306 @@
+package webui
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cursor-roomer/loadtest/runner"
+	"cursor-roomer/loadtest/store"
+	"cursor-roomer/loadtest/types"
+)
+
+// ErrJobNotFound is returned when no job with the requested ID exists
+var ErrJobNotFound = errors.New("job not found")
+
+// jobRetention is how long finished jobs stay in memory. They remain in the
+// run history after that.
+const jobRetention = time.Hour
+
+// JobStatus is the lifecycle state of a job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"    // Waiting for a free run slot
+	JobRunning   JobStatus = "running"   // The load test is in progress
+	JobDone      JobStatus = "done"      // The load test finished
+	JobFailed    JobStatus = "failed"    // The load test could not be run
+	JobCancelled JobStatus = "cancelled" // Stopped before it finished
+)
+
+// JobInfo is a snapshot of a job
+type JobInfo struct {
+	ID         string                `json:"id"`
+	Client     string                `json:"client"`
+	Config     types.LoadTestConfig  `json:"config"`
+	Status     JobStatus             `json:"status"`
+	Report     *types.LoadTestReport `json:"report,omitempty"`
+	Error      string                `json:"error,omitempty"`
+	CreatedAt  time.Time             `json:"createdAt"`
+	StartedAt  *time.Time            `json:"startedAt,omitempty"`
+	FinishedAt *time.Time            `json:"finishedAt,omitempty"`
+}
+
+// Event names of the job stream besides the runner's own events
+const (
+	eventLog         = "log"          // A line of text output
+	eventRunFinished = "run_finished" // The run is over; the data is its RunResults
+)
+
+// jobEvent is one server-sent event of a job. Its ID is its position in the
+// job's event log, counting from 1.
+type jobEvent struct {
+	name string
+	data string
+}
+
+// Job is a single load test run managed by the JobManager. It implements
+// types.OutputHandler and keeps everything the run printed, so any number of
+// clients can follow it from the start.
+type Job struct {
+	mu     sync.Mutex
+	info   JobInfo
+	cancel context.CancelFunc
+	events []jobEvent
+	notify chan struct{} // Closed and replaced whenever an event is added
+	done   bool
+}
+
+// Info returns a snapshot of the job
+func (j *Job) Info() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info
+}
+
+// Cancel stops the job, whether it is still queued or already running
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+func (j *Job) WriteLine(line string) {
+	// Each line is sent as a separate SSE event to preserve formatting
+	for _, l := range strings.Split(line, "\n") {
+		j.publish(eventLog, l)
+	}
+}
+
+// HandleEvent implements types.EventHandler
+func (j *Job) HandleEvent(event types.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("failed to encode %s event: %v", event.Type, err)
+		return
+	}
+	j.publish(string(event.Type), string(data))
+}
+
+func (j *Job) publish(name, data string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.events = append(j.events, jobEvent{name: name, data: data})
+	close(j.notify)
+	j.notify = make(chan struct{})
+}
+
+// eventsSince returns the events after the first n, a channel that is closed
+// when more arrive, and whether the job has finished publishing
+func (j *Job) eventsSince(n int) ([]jobEvent, <-chan struct{}, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if n > len(j.events) {
+		n = len(j.events)
+	}
+	return j.events[n:], j.notify, j.done
+}
+
+func (j *Job) setStatus(status JobStatus) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.info.Status = status
+	now := time.Now()
+	switch status {
+	case JobRunning:
+		j.info.StartedAt = &now
+	case JobDone, JobFailed, JobCancelled:
+		j.info.FinishedAt = &now
+	}
+}
+
+// JobManager runs load tests as jobs with their own IDs and lifecycles. At
+// most maxRunning jobs run at once, the rest wait in the queue, so tests
+// started by different people don't skew each other's measurements.
+type JobManager struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	slots chan struct{}
+	store *store.Store // Records finished runs; nil disables history
+	// runTest runs a job's load test, runner.RunLoadTest unless a test
+	// replaces it
+	runTest func(config types.LoadTestConfig, output types.OutputHandler, clientType string) (*types.LoadTestReport, error)
+}
+
+// NewJobManager creates a job manager that runs up to maxRunning jobs at once
+func NewJobManager(maxRunning int, runStore *store.Store) *JobManager {
+	if maxRunning < 1 {
+		maxRunning = 1
+	}
+	return &JobManager{
+		jobs:    make(map[string]*Job),
+		slots:   make(chan struct{}, maxRunning),
+		store:   runStore,
+		runTest: runner.RunLoadTest,
+	}
+}
+
+// Submit queues a load test and returns its job. The config's context is
+// replaced by one owned by the job. The job's info only holds the config with
+// its credentials redacted.
+func (m *JobManager) Submit(config types.LoadTestConfig, clientType string) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	config.Ctx = ctx
+
+	job := &Job{
+		info: JobInfo{
+			ID:        store.NewID(),
+			Client:    clientType,
+			Config:    config.Redacted(),
+			Status:    JobQueued,
+			CreatedAt: time.Now(),
+		},
+		cancel: cancel,
+		notify: make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	m.prune()
+	m.jobs[job.info.ID] = job
+	m.mu.Unlock()
+
+	go m.run(ctx, job, config, clientType)
+	return job
+}
+
+func (m *JobManager) run(ctx context.Context, job *Job, config types.LoadTestConfig, clientType string) {
+	defer job.cancel()
+
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-ctx.Done():
+		job.setStatus(JobCancelled)
+		job.WriteLine("Test cancelled before it started")
+		m.finish(job)
+		return
+	}
+
+	job.setStatus(JobRunning)
+	report, err := m.runTest(config, job, clientType)
+
+	job.mu.Lock()
+	job.info.Report = report
+	if err != nil {
+		job.info.Error = err.Error()
+	}
+	job.mu.Unlock()
+
+	switch {
+	case ctx.Err() != nil:
+		job.setStatus(JobCancelled)
+	case err != nil:
+		job.setStatus(JobFailed)
+		job.WriteLine("Error: " + err.Error())
+	default:
+		job.setStatus(JobDone)
+	}
+	m.finish(job)
+}
+
+// finish records the job in the run history and publishes its final event
+func (m *JobManager) finish(job *Job) {
+	info := job.Info()
+
+	if m.store != nil {
+		run := &store.Run{
+			ID:         info.ID,
+			Client:     info.Client,
+			Config:     info.Config,
+			Report:     info.Report,
+			Error:      info.Error,
+			StartedAt:  info.CreatedAt,
+			FinishedAt: *info.FinishedAt,
+		}
+		if info.StartedAt != nil {
+			run.StartedAt = *info.StartedAt
+		}
+		if err := m.store.Save(run); err != nil {
+			log.Printf("failed to record run %s: %v", info.ID, err)
+		}
+	}
+
+	if data, err := json.Marshal(runResults(info)); err == nil {
+		job.publish(eventRunFinished, string(data))
+	}
+
+	job.mu.Lock()
+	job.done = true
+	close(job.notify)
+	job.notify = make(chan struct{})
+	job.mu.Unlock()
+}
+
+// Get returns the job with the given ID
+func (m *JobManager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// Cancel stops the job with the given ID
+func (m *JobManager) Cancel(id string) error {
+	job, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	job.Cancel()
+	return nil
+}
+
+// List returns a snapshot of every job still in memory, newest first
+func (m *JobManager) List() []JobInfo {
+	m.mu.Lock()
+	infos := make([]JobInfo, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		infos = append(infos, job.Info())
+	}
+	m.mu.Unlock()
+
+	sortJobInfos(infos)
+	return infos
+}
+
+func sortJobInfos(infos []JobInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.After(infos[j].CreatedAt)
+	})
+}
+
+// prune drops jobs that finished more than jobRetention ago. The caller must
+// hold m.mu.
+func (m *JobManager) prune() {
+	for id, job := range m.jobs {
+		info := job.Info()
+		if info.FinishedAt != nil && time.Since(*info.FinishedAt) > jobRetention {
+			delete(m.jobs, id)
+		}
+	}
+}
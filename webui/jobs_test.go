@@ -0,0 +1,257 @@
+package webui
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"cursor-roomer/loadtest/store"
+	"cursor-roomer/loadtest/types"
+)
+
+// stubRunner stands in for the runner. Each test it runs, told apart by
+// URL, blocks until its outcome is sent or the job is cancelled.
+type stubRunner struct {
+	outcomes map[string]chan stubOutcome
+}
+
+type stubOutcome struct {
+	report *types.LoadTestReport
+	err    error
+}
+
+func newStubRunner(urls ...string) *stubRunner {
+	r := &stubRunner{outcomes: make(map[string]chan stubOutcome)}
+	for _, url := range urls {
+		r.outcomes[url] = make(chan stubOutcome, 1)
+	}
+	return r
+}
+
+func (r *stubRunner) run(config types.LoadTestConfig, output types.OutputHandler, clientType string) (*types.LoadTestReport, error) {
+	output.WriteLine("Running " + config.URL)
+	select {
+	case outcome := <-r.outcomes[config.URL]:
+		return outcome.report, outcome.err
+	case <-config.Ctx.Done():
+		return nil, fmt.Errorf("test cancelled")
+	}
+}
+
+func newTestJobManager(t *testing.T, maxRunning int, runner *stubRunner, runStore *store.Store) *JobManager {
+	t.Helper()
+	m := NewJobManager(maxRunning, runStore)
+	m.runTest = runner.run
+	return m
+}
+
+// waitForStatus waits for the job to reach the status and returns its info
+func waitForStatus(t *testing.T, job *Job, status JobStatus) JobInfo {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info := job.Info()
+		if info.Status == status {
+			return info
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", info.ID, info.Status, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitForDone waits for the job to publish its last event and returns the
+// events it published
+func waitForDone(t *testing.T, job *Job) []jobEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		events, notify, done := job.eventsSince(0)
+		if done {
+			return events
+		}
+		select {
+		case <-notify:
+		case <-timeout:
+			t.Fatalf("job %s never finished", job.Info().ID)
+		}
+	}
+}
+
+func logged(events []jobEvent, line string) bool {
+	for _, event := range events {
+		if event.name == eventLog && event.data == line {
+			return true
+		}
+	}
+	return false
+}
+
+func TestJobLifecycle(t *testing.T) {
+	runner := newStubRunner("http://first.test/", "http://second.test/")
+	runStore, err := store.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("store.New: %v", err)
+	}
+	m := newTestJobManager(t, 1, runner, runStore)
+
+	first := m.Submit(types.LoadTestConfig{URL: "http://first.test/", BearerToken: "s3cret"}, "native")
+	waitForStatus(t, first, JobRunning)
+	// Only one job runs at a time, so the second waits its turn
+	second := m.Submit(types.LoadTestConfig{URL: "http://second.test/"}, "native")
+	time.Sleep(20 * time.Millisecond)
+	if info := second.Info(); info.Status != JobQueued || info.StartedAt != nil {
+		t.Errorf("second job is %s, started at %v; want it queued", info.Status, info.StartedAt)
+	}
+	if info := first.Info(); info.Config.BearerToken == "s3cret" {
+		t.Error("the job's info holds the bearer token")
+	}
+
+	report := &types.LoadTestReport{MaxConcurrency: 10, StopReason: types.StopLatencyThreshold}
+	runner.outcomes["http://first.test/"] <- stubOutcome{report: report}
+	info := waitForStatus(t, first, JobDone)
+	if info.Report != report || info.Error != "" || info.StartedAt == nil || info.FinishedAt == nil {
+		t.Errorf("done job = %+v, want its report and times", info)
+	}
+	events := waitForDone(t, first)
+	if last := events[len(events)-1]; last.name != eventRunFinished {
+		t.Errorf("last event = %s, want %s", last.name, eventRunFinished)
+	}
+
+	// The slot is free again
+	waitForStatus(t, second, JobRunning)
+	runner.outcomes["http://second.test/"] <- stubOutcome{err: errors.New("connection refused")}
+	info = waitForStatus(t, second, JobFailed)
+	if info.Error != "connection refused" {
+		t.Errorf("error = %q, want the runner's", info.Error)
+	}
+	if events := waitForDone(t, second); !logged(events, "Error: connection refused") {
+		t.Error("the failure wasn't logged")
+	}
+
+	// Both runs are in the history, newest first
+	runs, err := runStore.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(runs) != 2 || runs[0].ID != second.Info().ID || runs[1].ID != first.Info().ID {
+		t.Fatalf("history = %d runs, want both jobs newest first", len(runs))
+	}
+	if runs[1].Report == nil || runs[1].Report.MaxConcurrency != 10 || runs[0].Error != "connection refused" {
+		t.Errorf("history = %+v and %+v, want the report and the error recorded", runs[1], runs[0])
+	}
+
+	if jobs := m.List(); len(jobs) != 2 || jobs[0].ID != second.Info().ID {
+		t.Errorf("List = %d jobs, want both newest first", len(jobs))
+	}
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	runner := newStubRunner("http://running.test/", "http://queued.test/")
+	m := newTestJobManager(t, 1, runner, nil)
+	running := m.Submit(types.LoadTestConfig{URL: "http://running.test/"}, "native")
+	waitForStatus(t, running, JobRunning)
+	queued := m.Submit(types.LoadTestConfig{URL: "http://queued.test/"}, "native")
+
+	if err := m.Cancel(queued.Info().ID); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	info := waitForStatus(t, queued, JobCancelled)
+	if info.StartedAt != nil || info.FinishedAt == nil {
+		t.Errorf("cancelled job started at %v, finished at %v; want it never started", info.StartedAt, info.FinishedAt)
+	}
+	events := waitForDone(t, queued)
+	if !logged(events, "Test cancelled before it started") || logged(events, "Running http://queued.test/") {
+		t.Error("the queued job ran or wasn't reported as cancelled before it started")
+	}
+
+	// The running job is unaffected and still holds the only slot
+	if status := running.Info().Status; status != JobRunning {
+		t.Errorf("running job is %s, want it still running", status)
+	}
+	runner.outcomes["http://running.test/"] <- stubOutcome{report: &types.LoadTestReport{}}
+	waitForStatus(t, running, JobDone)
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	runner := newStubRunner("http://running.test/", "http://next.test/")
+	m := newTestJobManager(t, 1, runner, nil)
+	running := m.Submit(types.LoadTestConfig{URL: "http://running.test/"}, "native")
+	waitForStatus(t, running, JobRunning)
+	next := m.Submit(types.LoadTestConfig{URL: "http://next.test/"}, "native")
+
+	running.Cancel()
+	// The runner's error is kept, but the job counts as cancelled rather than failed
+	info := waitForStatus(t, running, JobCancelled)
+	if info.Error != "test cancelled" || info.StartedAt == nil {
+		t.Errorf("cancelled job = %+v, want it started and stopped by the cancellation", info)
+	}
+	waitForDone(t, running)
+
+	// Cancelling frees the slot for the next job
+	waitForStatus(t, next, JobRunning)
+	next.Cancel()
+	waitForStatus(t, next, JobCancelled)
+
+	// Cancelling a finished job changes nothing
+	running.Cancel()
+	if status := running.Info().Status; status != JobCancelled {
+		t.Errorf("status = %s after a second cancel, want cancelled", status)
+	}
+}
+
+func TestConcurrentJobs(t *testing.T) {
+	const jobs = 8
+	urls := make([]string, jobs)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("http://job%d.test/", i)
+	}
+	runner := newStubRunner(urls...)
+	m := newTestJobManager(t, 3, runner, nil)
+
+	submitted := make([]*Job, jobs)
+	for i, url := range urls {
+		submitted[i] = m.Submit(types.LoadTestConfig{URL: url}, "native")
+	}
+	for i, url := range urls {
+		if i%2 == 0 {
+			submitted[i].Cancel()
+		} else {
+			runner.outcomes[url] <- stubOutcome{report: &types.LoadTestReport{}}
+		}
+	}
+	for i, job := range submitted {
+		waitForDone(t, job)
+		want := JobDone
+		if i%2 == 0 {
+			want = JobCancelled
+		}
+		if status := job.Info().Status; status != want {
+			t.Errorf("job %d is %s, want %s", i, status, want)
+		}
+	}
+}
+
+func TestJobManagerUnknownJob(t *testing.T) {
+	m := newTestJobManager(t, 1, newStubRunner(), nil)
+	if _, err := m.Get("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Get error = %v, want ErrJobNotFound", err)
+	}
+	if err := m.Cancel("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Cancel error = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestJobWriteLineSplitsLines(t *testing.T) {
+	job := &Job{notify: make(chan struct{})}
+	job.WriteLine("first\nsecond")
+	events, _, _ := job.eventsSince(0)
+	if len(events) != 2 || events[0].data != "first" || events[1].data != "second" {
+		t.Errorf("events = %+v, want a log event per line", events)
+	}
+	if events, _, _ := job.eventsSince(5); len(events) != 0 {
+		t.Errorf("events past the end = %+v, want none", events)
+	}
+}
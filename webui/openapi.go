@@ -0,0 +1,203 @@
+package webui
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"cursor-roomer/loadtest/types"
+)
+
+// openAPIDocument describes the /api/v1 endpoints. The schemas are generated
+// from the Go request and response types, so the document can't drift from
+// what the handlers actually accept and return.
+func openAPIDocument() map[string]interface{} {
+	schemas := make(map[string]interface{})
+	ref := func(v interface{}) map[string]interface{} {
+		return schemaFor(reflect.TypeOf(v), schemas)
+	}
+	content := func(schema map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		}
+	}
+	response := func(description string, schema map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"description": description, "content": content(schema)}
+	}
+	errorResponse := func(description string) map[string]interface{} {
+		return response(description, ref(APIError{}))
+	}
+	idParam := []interface{}{map[string]interface{}{
+		"name":     "id",
+		"in":       "path",
+		"required": true,
+		"schema":   map[string]interface{}{"type": "string"},
+	}}
+
+	paths := map[string]interface{}{
+		"/runs": map[string]interface{}{
+			"post": map[string]interface{}{
+				"summary":     "Start a run",
+				"operationId": "createRun",
+				"requestBody": map[string]interface{}{"required": true, "content": content(ref(RunRequest{}))},
+				"responses": map[string]interface{}{
+					"202": response("The run was queued", ref(JobInfo{})),
+					"400": errorResponse("The request is invalid"),
+				},
+			},
+			"get": map[string]interface{}{
+				"summary":     "List runs, newest first",
+				"operationId": "listRuns",
+				"responses": map[string]interface{}{
+					"200": response("Runs in progress and recorded runs", ref([]JobInfo{})),
+				},
+			},
+		},
+		"/runs/{id}": map[string]interface{}{
+			"parameters": idParam,
+			"get": map[string]interface{}{
+				"summary":     "Get a run",
+				"operationId": "getRun",
+				"responses": map[string]interface{}{
+					"200": response("The run", ref(JobInfo{})),
+					"404": errorResponse("No run with this ID"),
+				},
+			},
+		},
+		"/runs/{id}/cancel": map[string]interface{}{
+			"parameters": idParam,
+			"post": map[string]interface{}{
+				"summary":     "Cancel a queued or running run",
+				"operationId": "cancelRun",
+				"responses": map[string]interface{}{
+					"202": response("The run is being cancelled", ref(JobInfo{})),
+					"404": errorResponse("No run with this ID"),
+					"409": errorResponse("The run already finished"),
+				},
+			},
+		},
+		"/runs/{id}/events": map[string]interface{}{
+			"parameters": idParam,
+			"get": map[string]interface{}{
+				"summary": "Follow a run as server-sent events",
+				"description": "Events are iteration_started, iteration_result and threshold_breached (data: " + ref(types.Event{})["$ref"].(string) +
+					"), log (data: a line of text) and run_finished (data: " + ref(RunResults{})["$ref"].(string) + "). " +
+					"Reconnect with Last-Event-ID to resume after the last event received.",
+				"operationId": "getRunEvents",
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The event stream",
+						"content": map[string]interface{}{
+							"text/event-stream": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+						},
+					},
+					"404": errorResponse("No run with this ID"),
+				},
+			},
+		},
+		"/runs/{id}/results": map[string]interface{}{
+			"parameters": idParam,
+			"get": map[string]interface{}{
+				"summary":     "Get the capacity report of a finished run",
+				"operationId": "getRunResults",
+				"responses": map[string]interface{}{
+					"200": response("The results", ref(RunResults{})),
+					"404": errorResponse("No run with this ID"),
+					"409": errorResponse("The run has not finished"),
+				},
+			},
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Roomer Capacity Planning API",
+			"version": "1.0.0",
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": apiPrefix}},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// schemaFor returns the JSON schema of t as encoding/json would marshal it.
+// Named structs are added to schemas and referenced by name. Fields are
+// optional unless tagged openapi:"required".
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "Nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem(), schemas)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; ok {
+			return ref
+		}
+		// Register the name first so recursive types terminate
+		schemas[t.Name()] = nil
+		schemas[t.Name()] = structSchema(t, schemas)
+		return ref
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaFor(field.Type, schemas)
+		if field.Tag.Get("openapi") == "required" {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// handleOpenAPI serves the OpenAPI document of the API
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeAPIJSON(w, http.StatusOK, openAPIDocument())
+}
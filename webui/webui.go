@@ -1,103 +1,114 @@
 package webui
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
-	"sync"
-	"time"
 
-	"cursor-roomer/loadtest/runner"
+	"cursor-roomer/loadtest/compare"
+	"cursor-roomer/loadtest/store"
 	"cursor-roomer/loadtest/types"
 )
 
 type Server struct {
-	port int
-	mu   sync.Mutex
-	ctx  context.Context
+	port     int
+	jobs     *JobManager
+	store    *store.Store // Recorded runs; nil disables history
+	protoDir string       // Directory the .proto files ghz tests name are taken from; empty disables them
 }
 
-func NewServer(port int) *Server {
+// NewServer creates a web server that runs up to maxRunning load tests at
+// once and records them in runStore. Tests may name .proto files in protoDir.
+func NewServer(port int, runStore *store.Store, maxRunning int, protoDir string) *Server {
 	return &Server{
-		port: port,
-		ctx:  context.Background(),
+		port:     port,
+		jobs:     NewJobManager(maxRunning, runStore),
+		store:    runStore,
+		protoDir: protoDir,
 	}
 }
 
-type SSEOutputHandler struct {
-	w http.ResponseWriter
+// handleStopTest cancels the job named by the id query parameter
+func (s *Server) handleStopTest(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	if err := s.jobs.Cancel(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 }
 
-func (h *SSEOutputHandler) WriteLine(line string) {
-	// Send each line as a separate SSE event to preserve formatting
-	lines := strings.Split(line, "\n")
-	for _, l := range lines {
-		fmt.Fprintf(h.w, "data: %s\n\n", l)
-		if f, ok := h.w.(http.Flusher); ok {
-			f.Flush()
-		}
+// handleJobs lists the jobs the server knows about, newest first
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
+	writeJSON(w, s.jobs.List())
 }
 
-func (s *Server) handleStopTest(w http.ResponseWriter, r *http.Request) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func setSSEHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+}
 
-	if s.ctx != nil {
-		// Cancel the current context
-		if cancel, ok := s.ctx.Value("cancel").(context.CancelFunc); ok {
-			cancel()
-		}
-		// Create a new context for future tests
-		s.ctx = context.Background()
+// lastEventID returns the ID of the last event a reconnecting client saw,
+// from the Last-Event-ID header, or 0 for a new client
+func lastEventID(r *http.Request) int {
+	id, err := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	if err != nil || id < 0 {
+		return 0
 	}
+	return id
+}
 
-	w.WriteHeader(http.StatusOK)
+// streamJob sends the events of a job after the first sent as server-sent
+// events, until the job finishes or the client goes away. The job keeps
+// running if it does, and the client can resume from the last event ID.
+func (s *Server) streamJob(w http.ResponseWriter, r *http.Request, job *Job, sent int) {
+	flusher, _ := w.(http.Flusher)
+	for {
+		events, notify, done := job.eventsSince(sent)
+		for _, event := range events {
+			sent++
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", sent, event.name, event.data)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if done {
+			return
+		}
+
+		select {
+		case <-notify:
+		case <-r.Context().Done():
+			return
+		}
+	}
 }
 
 func (s *Server) handleRunTest(w http.ResponseWriter, r *http.Request) {
-	// Set headers for SSE
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	// Create output handler
-	output := &SSEOutputHandler{w: w}
+	setSSEHeaders(w)
 
 	// Try to parse request body first
-	var req struct {
-		URL                string  `json:"url"`
-		Goroutines         int     `json:"goroutines"`
-		Duration           string  `json:"duration"`
-		MaxLatencyIncrease float64 `json:"maxLatencyIncrease"`
-		MinRpsIncrease     float64 `json:"minRpsIncrease"`
-		Debug              bool    `json:"debug"`
-		Method             string  `json:"method"`
-		Body               string  `json:"body"`
-		ClientType         string  `json:"clientType"`
-	}
+	var req RunRequest
 
 	// Try to decode JSON body
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		// If JSON decode fails, try query parameters
 		req.URL = r.URL.Query().Get("url")
-		if req.URL == "" {
-			http.Error(w, "URL is required", http.StatusBadRequest)
-			return
-		}
-
-		// Validate URL format
-		if _, err := url.Parse(req.URL); err != nil {
-			http.Error(w, "Invalid URL format", http.StatusBadRequest)
-			return
-		}
 
 		goroutines, err := strconv.Atoi(r.URL.Query().Get("goroutines"))
 		if err != nil {
@@ -113,6 +124,17 @@ func (s *Server) handleRunTest(w http.ResponseWriter, r *http.Request) {
 		}
 		req.Duration = fmt.Sprintf("%ds", duration)
 
+		for name, target := range map[string]*string{"warmup": &req.Warmup, "cooldown": &req.Cooldown} {
+			if value := r.URL.Query().Get(name); value != "" {
+				seconds, err := strconv.Atoi(value)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Invalid %s value", name), http.StatusBadRequest)
+					return
+				}
+				*target = fmt.Sprintf("%ds", seconds)
+			}
+		}
+
 		req.MaxLatencyIncrease, err = strconv.ParseFloat(r.URL.Query().Get("latencyThreshold"), 64)
 		if err != nil {
 			http.Error(w, "Invalid latency threshold value", http.StatusBadRequest)
@@ -125,97 +147,197 @@ func (s *Server) handleRunTest(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if value := r.URL.Query().Get("errorThreshold"); value != "" {
+			req.MaxErrorRate, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				http.Error(w, "Invalid error rate threshold value", http.StatusBadRequest)
+				return
+			}
+		}
+
 		req.Debug = r.URL.Query().Get("debug") == "true"
+		req.CorrectedLatency = r.URL.Query().Get("correctedLatency") == "true"
 		req.Method = r.URL.Query().Get("method")
 		req.Body = r.URL.Query().Get("body")
 		req.ClientType = r.URL.Query().Get("clientType")
-		if req.ClientType == "" {
-			req.ClientType = "k6" // Default to k6 if not specified
+		req.Proto = r.URL.Query().Get("proto")
+		req.Call = r.URL.Query().Get("call")
+		req.TLS = r.URL.Query().Get("tls") == "true"
+		req.SkipTLSVerify = r.URL.Query().Get("skipTlsVerify") == "true"
+		req.Scaling = r.URL.Query().Get("scaling")
+		req.ScaleLevels = r.URL.Query().Get("scaleLevels")
+		req.Refine = r.URL.Query().Get("refine") == "true"
+		req.SLOs = r.URL.Query().Get("slos")
+		// Credentials and feeders, whose rows can hold user tokens, aren't
+		// accepted in the query string, where they would end up in access
+		// logs; send a JSON body for authenticated tests
+		req.ContentType = r.URL.Query().Get("contentType")
+		req.Headers = strings.Join(r.URL.Query()["header"], "\n")
+		req.Scenario = strings.Join(r.URL.Query()["request"], "\n")
+		req.Model = r.URL.Query().Get("model")
+		for name, target := range map[string]*int{"scaleStep": &req.ScaleStep, "scaleLow": &req.ScaleLow, "scaleHigh": &req.ScaleHigh, "scaleResolution": &req.ScaleResolution, "arrivalRate": &req.ArrivalRate, "maxVUs": &req.MaxVUs, "trials": &req.Trials} {
+			if value := r.URL.Query().Get(name); value != "" {
+				if *target, err = strconv.Atoi(value); err != nil {
+					http.Error(w, fmt.Sprintf("Invalid %s value", name), http.StatusBadRequest)
+					return
+				}
+			}
 		}
-	} else {
-		// Validate URL format for JSON request
-		if _, err := url.Parse(req.URL); err != nil {
-			http.Error(w, "Invalid URL format", http.StatusBadRequest)
-			return
+		if value := r.URL.Query().Get("scaleFactor"); value != "" {
+			if req.ScaleFactor, err = strconv.ParseFloat(value, 64); err != nil {
+				http.Error(w, "Invalid scale factor value", http.StatusBadRequest)
+				return
+			}
 		}
-		if req.ClientType == "" {
-			req.ClientType = "k6" // Default to k6 if not specified
+		if value := r.URL.Query().Get("maxDropped"); value != "" {
+			if req.MaxDropped, err = strconv.ParseFloat(value, 64); err != nil {
+				http.Error(w, "Invalid max dropped value", http.StatusBadRequest)
+				return
+			}
 		}
 	}
 
-	// Parse duration string
-	duration, err := time.ParseDuration(req.Duration)
+	config, err := req.LoadTestConfig(s.protoDir)
 	if err != nil {
-		http.Error(w, "Invalid duration format", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Create a new context for this test
-	s.mu.Lock()
-	ctx, cancel := context.WithCancel(s.ctx)
-	s.ctx = ctx
-	s.ctx = context.WithValue(s.ctx, "cancel", cancel)
-	s.mu.Unlock()
+	job := s.jobs.Submit(config, req.ClientType)
 
-	config := types.LoadTestConfig{
-		URL:                req.URL,
-		Goroutines:         req.Goroutines,
-		Duration:           duration,
-		MaxLatencyIncrease: req.MaxLatencyIncrease,
-		MinRpsIncrease:     req.MinRpsIncrease,
-		Debug:              req.Debug,
-		Ctx:                ctx,
-		Method:             req.Method,
-		Body:               req.Body,
+	// Tell the page which job this is so it can stop it
+	fmt.Fprintf(w, "event: job\ndata: %s\n\n", job.Info().ID)
+	s.streamJob(w, r, job, 0)
+}
+
+// handleRuns lists the recorded runs, newest first
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	if err := runner.RunLoadTest(config, output, req.ClientType); err != nil {
-		fmt.Fprintf(w, "data: Error: %v\n\n", err)
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
+	runs := []*store.Run{}
+	if s.store != nil {
+		stored, err := s.store.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		runs = append(runs, stored...)
 	}
+	writeJSON(w, runs)
 }
 
-func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.ParseFiles("webui/templates/index.html")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// handleRun returns or deletes the run named in the path, /runs/{id}
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/runs/")
+	if s.store == nil || id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
 		return
 	}
 
-	if err := tmpl.Execute(w, nil); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		var run *store.Run
+		if run, err = s.store.Get(id); err == nil {
+			writeJSON(w, run)
+			return
+		}
+	case http.MethodDelete:
+		if err = s.store.Delete(id); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-}
 
-// RunLoadTest runs a load test with the given configuration and returns the output
-func RunLoadTest(config types.LoadTestConfig, clientType string) (string, error) {
-	output := &StringOutputHandler{}
-	if err := runner.RunLoadTest(config, output, clientType); err != nil {
-		return "", err
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
 	}
-	return output.String(), nil
+	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
 
-// StringOutputHandler implements types.OutputHandler and collects output as a string
-type StringOutputHandler struct {
-	output []string
+// handleCompare compares two recorded runs, /runs/compare?base={id}&candidate={id}
+func (s *Server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.store == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	tolerance := compare.DefaultTolerance
+	if value := r.URL.Query().Get("tolerance"); value != "" {
+		var err error
+		if tolerance, err = strconv.ParseFloat(value, 64); err != nil {
+			http.Error(w, "Invalid tolerance value", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var reports []*types.LoadTestReport
+	for _, param := range []string{"base", "candidate"} {
+		run, err := s.store.Get(r.URL.Query().Get(param))
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, fmt.Sprintf("%s run not found", param), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s run: %v", param, err), http.StatusBadRequest)
+			return
+		}
+		reports = append(reports, run.Report)
+	}
+
+	comparison, err := compare.Compare(reports[0], reports[1], tolerance)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, comparison)
 }
 
-func (h *StringOutputHandler) WriteLine(line string) {
-	h.output = append(h.output, line)
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
 }
 
-func (h *StringOutputHandler) String() string {
-	return fmt.Sprintf("%s\n", h.output)
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.ParseFiles("webui/templates/index.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl.Execute(w, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
 
 func (s *Server) Start() error {
 	http.HandleFunc("/", s.handleIndex)
 	http.HandleFunc("/run-test", s.handleRunTest)
 	http.HandleFunc("/stop-test", s.handleStopTest)
+	http.HandleFunc("/jobs", s.handleJobs)
+	http.HandleFunc("/runs", s.handleRuns)
+	http.HandleFunc("/runs/", s.handleRun)
+	http.HandleFunc("/runs/compare", s.handleCompare)
+	http.HandleFunc(apiPrefix+"/runs", s.handleAPIRuns)
+	http.HandleFunc(apiPrefix+"/runs/", s.handleAPIRun)
+	http.HandleFunc(apiPrefix+"/openapi.json", s.handleOpenAPI)
 
 	addr := fmt.Sprintf(":%d", s.port)
 	log.Printf("Starting web server on %s", addr)